@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
+)
+
+// Error codes returned in the "code" field of JSON error bodies. They are
+// stable: clients switch on them, so existing codes never change meaning.
+//
+//	invalid_request         the body isn't a valid run request, or its patch is malformed
+//	unsupported_media_type  the body isn't application/json
+//	test_not_found          no task has that name, or it lacks the file asked for
+//	test_hidden             test files aren't served by this server
+//	result_not_found        no job has that ID, or it has expired
+//	rate_limited            the task is already running as often as allowed
+//	budget_exhausted        the user has used up their runtime budget
+//	queue_full              the async queue or the wait for a run slot is full
+//	out_of_memory           the server can't buffer another run right now
+//	warming_up              the server is still warming up after starting
+//	callback_not_allowed    the callbackUrl was rejected
+//	admin_required          an option was used that needs the admin token
+//	context_too_large       the submission has too many files
+//	patch_conflict          the submitted patch doesn't apply to the task's code
+//	build_failed            the image couldn't be built, or the build produced none
+//	type_check_failed       the test command rejected the submission's types
+//	base_image_not_allowed  the Dockerfile builds FROM an image not on the allowlist
+//	timeout                 the run, or the whole request, exceeded its time limit
+//	memory_exceeded         the run was killed for nearing its memory limit
+//	report_missing          the run didn't write a report
+//	report_too_large        the report exceeds the size limit
+//	report_unreadable       the report was truncated or isn't valid JUnit
+//	test_tampered           the packaged test doesn't match its checksum
+//	daemon_unavailable      the Docker daemon can't be reached
+//	daemon_unsupported      the Docker daemon lacks a feature the task needs
+//	cancelled               the client went away before the run finished
+//	internal                anything else
+const (
+	codeInvalidRequest     = "invalid_request"
+	codeUnsupportedMedia   = "unsupported_media_type"
+	codeTestNotFound       = "test_not_found"
+	codeTestHidden         = "test_hidden"
+	codeResultNotFound     = "result_not_found"
+	codeRateLimited        = "rate_limited"
+	codeBudgetExhausted    = "budget_exhausted"
+	codeQueueFull          = "queue_full"
+	codeOutOfMemory        = "out_of_memory"
+	codeWarmingUp          = "warming_up"
+	codeCallbackNotAllowed = "callback_not_allowed"
+	codeAdminRequired      = "admin_required"
+	codeContextTooLarge    = "context_too_large"
+	codePatchConflict      = "patch_conflict"
+	codeBuildFailed        = "build_failed"
+	codeTypeCheckFailed    = "type_check_failed"
+	codeBaseImageDenied    = "base_image_not_allowed"
+	codeTimeout            = "timeout"
+	codeMemoryExceeded     = "memory_exceeded"
+	codeReportMissing      = "report_missing"
+	codeReportTooLarge     = "report_too_large"
+	codeReportUnreadable   = "report_unreadable"
+	codeTestTampered       = "test_tampered"
+	codeDaemonUnavailable  = "daemon_unavailable"
+	codeDaemonUnsupported  = "daemon_unsupported"
+	codeCancelled          = "cancelled"
+	codeInternal           = "internal"
+)
+
+type apiError struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+	// Diagnostics lists the compiler errors of a failed build or type
+	// check, if any were recognised in its output.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// RuntimeError is the uncaught exception that stopped a run before it
+	// wrote its report, if its stderr shows one.
+	RuntimeError *RuntimeError `json:"runtimeError,omitempty"`
+}
+
+// classifyError maps an error from the run pipeline to its HTTP status and
+// error code.
+func classifyError(err error) (int, string) {
+	switch {
+	case errors.Is(err, errRunTimedOut):
+		return http.StatusGatewayTimeout, codeTimeout
+	case errors.Is(err, errMemoryExceeded):
+		return http.StatusUnprocessableEntity, codeMemoryExceeded
+	case errors.Is(err, errInvalidPatch):
+		return http.StatusBadRequest, codeInvalidRequest
+	case errors.Is(err, errPatchConflict):
+		return http.StatusUnprocessableEntity, codePatchConflict
+	case errors.Is(err, errTooManyFiles):
+		return http.StatusRequestEntityTooLarge, codeContextTooLarge
+	case errors.Is(err, errBaseImageNotAllowed):
+		return http.StatusForbidden, codeBaseImageDenied
+	case errors.Is(err, errBuildFailed):
+		return http.StatusUnprocessableEntity, codeBuildFailed
+	case errors.Is(err, errTypeCheckFailed):
+		return http.StatusUnprocessableEntity, codeTypeCheckFailed
+	case errors.Is(err, errReportTooLarge):
+		return http.StatusUnprocessableEntity, codeReportTooLarge
+	case errors.Is(err, errTruncatedReport), errors.Is(err, errInvalidReport):
+		return http.StatusUnprocessableEntity, codeReportUnreadable
+	case errors.Is(err, errTestTampered):
+		return http.StatusInternalServerError, codeTestTampered
+	case errors.Is(err, errUnsupportedAPI):
+		return http.StatusNotImplemented, codeDaemonUnsupported
+	case errors.Is(err, errNoSlot):
+		return http.StatusServiceUnavailable, codeQueueFull
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, codeCancelled
+	case client.IsErrConnectionFailed(err):
+		return http.StatusServiceUnavailable, codeDaemonUnavailable
+	case cerrdefs.IsNotFound(err):
+		return http.StatusUnprocessableEntity, codeReportMissing
+	default:
+		return http.StatusInternalServerError, codeInternal
+	}
+}
+
+// infraFailure reports whether err is the host's fault rather than the
+// submission's, so that trying the run again may succeed: the daemon
+// couldn't be reached, or answered with a server error. Other internal
+// errors aren't retried, as most fail the same way every time, such as a
+// task file that can't be read or a run that crashed before its report.
+func infraFailure(err error) bool {
+	switch _, code := classifyError(err); code {
+	case codeDaemonUnavailable:
+		return true
+	case codeInternal:
+		return cerrdefs.IsInternal(err) || cerrdefs.IsUnavailable(err)
+	default:
+		return false
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(marshalResponse(r, apiError{Code: code, Error: message}))
+}
+
+// runErrorBody returns the status classifyError picks for err and the body
+// describing it.
+func runErrorBody(err error) (int, apiError) {
+	status, code := classifyError(err)
+	body := apiError{Code: code, Error: err.Error()}
+
+	failure := &buildFailure{}
+	if errors.As(err, &failure) && len(failure.diagnostics) > 0 {
+		body.Diagnostics = failure.diagnostics
+	}
+	check := &checkFailure{}
+	if errors.As(err, &check) {
+		body.Diagnostics = check.diagnostics
+	}
+	runtime := &runtimeFailure{}
+	if errors.As(err, &runtime) {
+		body.RuntimeError = runtime.runtimeError
+	}
+	return status, body
+}
+
+// writeRunError responds with the status and body runErrorBody picks.
+func writeRunError(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := runErrorBody(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(marshalResponse(r, body))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	cerrdefs "github.com/containerd/errdefs"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errRunTimedOut, http.StatusGatewayTimeout, codeTimeout},
+		{errMemoryExceeded, http.StatusUnprocessableEntity, codeMemoryExceeded},
+		{errInvalidPatch, http.StatusBadRequest, codeInvalidRequest},
+		{errPatchConflict, http.StatusUnprocessableEntity, codePatchConflict},
+		{errTooManyFiles, http.StatusRequestEntityTooLarge, codeContextTooLarge},
+		{errBaseImageNotAllowed, http.StatusForbidden, codeBaseImageDenied},
+		{errBuildFailed, http.StatusUnprocessableEntity, codeBuildFailed},
+		{&checkFailure{err: errors.New("no report"), diagnostics: []Diagnostic{{Message: "bad"}}}, http.StatusUnprocessableEntity, codeTypeCheckFailed},
+		{errReportTooLarge, http.StatusUnprocessableEntity, codeReportTooLarge},
+		{errTruncatedReport, http.StatusUnprocessableEntity, codeReportUnreadable},
+		{errInvalidReport, http.StatusUnprocessableEntity, codeReportUnreadable},
+		{errTestTampered, http.StatusInternalServerError, codeTestTampered},
+		{errUnsupportedAPI, http.StatusNotImplemented, codeDaemonUnsupported},
+		{errNoSlot, http.StatusServiceUnavailable, codeQueueFull},
+		{context.Canceled, http.StatusServiceUnavailable, codeCancelled},
+		{cerrdefs.ErrNotFound, http.StatusUnprocessableEntity, codeReportMissing},
+		{errors.New("something else"), http.StatusInternalServerError, codeInternal},
+	}
+	for _, test := range tests {
+		// Errors reach the handlers wrapped.
+		err := fmt.Errorf("running: %w", test.err)
+		status, code := classifyError(err)
+		if status != test.status || code != test.code {
+			t.Errorf("classifyError(%v) = %d %s, want %d %s", err, status, code, test.status, test.code)
+		}
+	}
+}
+
+func TestInfraFailure(t *testing.T) {
+	for _, err := range []error{cerrdefs.ErrInternal, cerrdefs.ErrUnavailable, fmt.Errorf("starting container: %w", cerrdefs.ErrInternal)} {
+		if !infraFailure(err) {
+			t.Errorf("daemon error %v isn't retried", err)
+		}
+	}
+	for _, err := range []error{errors.New("reading metadata"), errBuildFailed, errRunTimedOut, errTestTampered, context.Canceled, cerrdefs.ErrInvalidArgument} {
+		if infraFailure(err) {
+			t.Errorf("%v is retried", err)
+		}
+	}
+}
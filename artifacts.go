@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+)
+
+// maxArtifactLog caps each log kept for a bundle.
+const maxArtifactLog = 1 << 20
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a chatty build or test can't grow it without bound.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func newCappedBuffer(limit int) *cappedBuffer {
+	return &cappedBuffer{limit: limit}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// Contents returns what was kept, noting when the rest was cut off.
+func (b *cappedBuffer) Contents() []byte {
+	if b.truncated {
+		return append(bytes.Clone(b.Bytes()), fmt.Sprintf("\n[truncated at %d bytes]\n", b.limit)...)
+	}
+	return bytes.Clone(b.Bytes())
+}
+
+// runArtifacts is what a bundle holds for a run.
+type runArtifacts struct {
+	Code     string
+	BuildLog []byte
+	RunLog   []byte
+	Report   []byte
+}
+
+// newRunArtifacts collects the artifacts of an execution, or returns nil
+// unless KEEP_ARTIFACTS is set.
+func newRunArtifacts(cfg *Config, req RunRequest, execution *Execution) *runArtifacts {
+	if !cfg.KeepArtifacts {
+		return nil
+	}
+	artifacts := &runArtifacts{Code: req.Code}
+	if execution != nil {
+		artifacts.BuildLog = execution.BuildLog
+		artifacts.RunLog = execution.RunLog
+		artifacts.Report = execution.Report
+	}
+	return artifacts
+}
+
+// readRunLog returns the container's stdout and stderr, interleaved and
+// capped at maxArtifactLog.
+func readRunLog(ctx context.Context, cli *client.Client, containerID string) ([]byte, error) {
+	logs, err := cli.ContainerLogs(ctx, containerID, client.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, fmt.Errorf("reading container logs: %w", err)
+	}
+	defer logs.Close()
+
+	output := newCappedBuffer(maxArtifactLog)
+	if _, err := stdcopy.StdCopy(output, output, logs); err != nil {
+		return nil, fmt.Errorf("demultiplexing container logs: %w", err)
+	}
+	return output.Contents(), nil
+}
+
+// bundleHandler serves GET /results/{id}/bundle, a zip of the job and its
+// artifacts streamed as it is written. There are no per-user credentials,
+// so it sits behind the admin token, which sees every user's results.
+func bundleHandler(store *resultStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		job, ok := store.Get(id)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, codeResultNotFound, "Can't find result "+id)
+			return
+		}
+		if job.artifacts == nil {
+			writeError(w, r, http.StatusNotFound, codeResultNotFound, "No artifacts were kept for result "+id)
+			return
+		}
+
+		jobJSON, err := json.MarshalIndent(job, "", "  ")
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, job.ID))
+
+		archive := zip.NewWriter(w)
+		for _, entry := range []struct {
+			name string
+			data []byte
+		}{
+			{"job.json", jobJSON},
+			{"code.ts", []byte(job.artifacts.Code)},
+			{"build.log", job.artifacts.BuildLog},
+			{"run.log", job.artifacts.RunLog},
+			{"report.xml", job.artifacts.Report},
+		} {
+			if entry.data == nil {
+				continue
+			}
+			file, err := archive.Create(entry.name)
+			if err == nil {
+				_, err = file.Write(entry.data)
+			}
+			if err != nil {
+				// The status is already sent; cut the archive short.
+				slog.Error("writing bundle", "job", job.ID, "error", err)
+				return
+			}
+		}
+		if err := archive.Close(); err != nil {
+			slog.Error("writing bundle", "job", job.ID, "error", err)
+		}
+	}
+}
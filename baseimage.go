@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errBaseImageNotAllowed = errors.New("base image not allowed")
+
+// normalizeImage spells out the registry and namespace Docker assumes for
+// short names, so "deno" and "docker.io/library/deno" compare equal.
+func normalizeImage(image string) string {
+	first, rest, found := strings.Cut(image, "/")
+	if !found {
+		return "docker.io/library/" + image
+	}
+	if first == "index.docker.io" {
+		return normalizeImage(rest)
+	}
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return "docker.io/" + image
+	}
+	return image
+}
+
+// imageName strips the tag and digest from a normalized image reference.
+func imageName(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// baseImageAllowed reports whether image matches an entry of allowed, an
+// ALLOWED_BASE_IMAGES list.
+func baseImageAllowed(allowed string, image string) bool {
+	image = normalizeImage(image)
+	for _, entry := range strings.Split(allowed, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(entry, "/*"); ok {
+			if strings.HasPrefix(image, normalizeImage(prefix)+"/") {
+				return true
+			}
+			continue
+		}
+
+		entry = normalizeImage(entry)
+		if entry == image || (entry == imageName(entry) && entry == imageName(image)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerfileBaseImages returns the images a Dockerfile builds FROM, after
+// substituting its ARGs, skipping scratch and references to earlier stages.
+func dockerfileBaseImages(dockerfile []byte, meta Metadata) []string {
+	images := []string{}
+	stages := map[string]bool{}
+
+	for _, line := range strings.Split(resolveDockerfile(dockerfile, meta), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		image := strings.Trim(fields[0], `"'`)
+		if image != "scratch" && !stages[strings.ToLower(image)] {
+			images = append(images, image)
+		}
+		if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+			stages[strings.ToLower(fields[2])] = true
+		}
+	}
+	return images
+}
+
+// checkBaseImages refuses Dockerfiles that build FROM an image outside
+// ALLOWED_BASE_IMAGES.
+func checkBaseImages(cfg *Config, dockerfile []byte, meta Metadata) error {
+	if cfg.AllowedBaseImages == "" {
+		return nil
+	}
+	for _, image := range dockerfileBaseImages(dockerfile, meta) {
+		if !baseImageAllowed(cfg.AllowedBaseImages, image) {
+			return fmt.Errorf("%w: %s", errBaseImageNotAllowed, image)
+		}
+	}
+	return nil
+}
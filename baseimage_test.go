@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeImage(t *testing.T) {
+	tests := map[string]string{
+		"deno":                           "docker.io/library/deno",
+		"denoland/deno:2.1.4":            "docker.io/denoland/deno:2.1.4",
+		"docker.io/library/deno":         "docker.io/library/deno",
+		"index.docker.io/denoland/deno":  "docker.io/denoland/deno",
+		"ghcr.io/acme/runner":            "ghcr.io/acme/runner",
+		"localhost/runner":               "localhost/runner",
+		"registry.local:5000/runner:1.0": "registry.local:5000/runner:1.0",
+	}
+	for image, want := range tests {
+		if got := normalizeImage(image); got != want {
+			t.Errorf("normalizeImage(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestBaseImageAllowed(t *testing.T) {
+	const allowed = "denoland/deno, node:20-alpine, ghcr.io/acme/*, registry.local:5000/runner"
+	tests := []struct {
+		image   string
+		allowed bool
+	}{
+		{"denoland/deno", true},
+		{"docker.io/denoland/deno:2.1.4", true},
+		{"denoland/deno@sha256:abc", true},
+		{"node:20-alpine", true},
+		{"node:22", false},
+		{"node", false},
+		{"ghcr.io/acme/runner:1", true},
+		{"ghcr.io/acme/tools/lint", true},
+		{"ghcr.io/acme", false},
+		{"ghcr.io/evil/runner", false},
+		{"registry.local:5000/runner:2.0", true},
+		{"registry.local/runner", false},
+		{"denoland/deno-evil", false},
+		{"evil.io/denoland/deno", false},
+	}
+	for _, tt := range tests {
+		if got := baseImageAllowed(allowed, tt.image); got != tt.allowed {
+			t.Errorf("baseImageAllowed(%q) = %v, want %v", tt.image, got, tt.allowed)
+		}
+	}
+}
+
+func TestDockerfileBaseImages(t *testing.T) {
+	dockerfile := []byte(`ARG DENO_VERSION=2.1.4
+ARG REGISTRY
+FROM --platform=linux/amd64 "denoland/deno:${DENO_VERSION}" AS base
+FROM base AS test
+from node:20-alpine as tools
+FROM scratch
+FROM $REGISTRY/runner
+COPY --from=tools /usr/local/bin/node /bin/node
+`)
+
+	got := dockerfileBaseImages(dockerfile, Metadata{})
+	if want := []string{"denoland/deno:2.1.4", "node:20-alpine", "/runner"}; !slices.Equal(got, want) {
+		t.Errorf("base images are %q, want %q", got, want)
+	}
+
+	got = dockerfileBaseImages(dockerfile, Metadata{BuildArgs: map[string]string{"DENO_VERSION": "2.2.0", "REGISTRY": "ghcr.io/acme"}})
+	if want := []string{"denoland/deno:2.2.0", "node:20-alpine", "ghcr.io/acme/runner"}; !slices.Equal(got, want) {
+		t.Errorf("with build args base images are %q, want %q", got, want)
+	}
+}
+
+func TestCheckBaseImages(t *testing.T) {
+	dockerfile := []byte("ARG BASE=denoland/deno\nFROM ${BASE}\n")
+	tests := []struct {
+		name    string
+		allowed string
+		args    map[string]string
+		ok      bool
+	}{
+		{"no allowlist", "", map[string]string{"BASE": "evil/image"}, true},
+		{"allowed", "denoland/deno", nil, true},
+		{"build arg swaps the image", "denoland/deno", map[string]string{"BASE": "evil/image"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, map[string]string{"ALLOWED_BASE_IMAGES": tt.allowed})
+			err := checkBaseImages(cfg, dockerfile, Metadata{BuildArgs: tt.args})
+			if tt.ok && err != nil {
+				t.Errorf("allowed base image refused: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, errBaseImageNotAllowed) {
+				t.Errorf("got %v, want errBaseImageNotAllowed", err)
+			}
+		})
+	}
+}
+
+func TestBuildImageRefusesDisallowedBase(t *testing.T) {
+	memFS, err := createFS("sum", "export const sum = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, allowed := range []string{"denoland/deno", "node"} {
+		docker := newFakeDocker(t)
+		cfg := testConfig(t, map[string]string{"ALLOWED_BASE_IMAGES": allowed})
+		err := buildImage(context.Background(), cfg, docker.client, "built", Metadata{}, memFS, io.Discard, false)
+		if allowed == "denoland/deno" {
+			if err != nil {
+				t.Errorf("build FROM an allowed image failed: %v", err)
+			}
+			continue
+		}
+		if !errors.Is(err, errBaseImageNotAllowed) {
+			t.Errorf("build FROM denoland/deno with only node allowed got %v", err)
+		}
+		if len(docker.Builds()) != 0 {
+			t.Error("disallowed base image was built")
+		}
+	}
+}
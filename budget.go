@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const budgetRemainingHeader = "X-Runtime-Budget-Remaining"
+
+// runtimeBudget caps the container runtime each user may consume per window.
+// Windows are aligned to multiples of the window length since the Unix epoch,
+// so a 24h window resets at midnight UTC.
+//
+// The counters are kept here rather than derived from the resultStore,
+// which drops its oldest jobs once full and would hand their runtime back
+// to the users who ran them. Like the store, they live in memory only and
+// reset when the server restarts.
+type runtimeBudget struct {
+	limit  time.Duration
+	window time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	used        map[string]time.Duration
+}
+
+// newRuntimeBudget returns nil when limit is zero, disabling the budget.
+func newRuntimeBudget(limit time.Duration, window time.Duration) *runtimeBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &runtimeBudget{limit: limit, window: window, now: time.Now, used: map[string]time.Duration{}}
+}
+
+// rollover clears the counters once the current window has ended. The caller
+// must hold b.mu.
+func (b *runtimeBudget) rollover() {
+	start := b.now().UTC().Truncate(b.window)
+	if !start.Equal(b.windowStart) {
+		b.windowStart = start
+		clear(b.used)
+	}
+}
+
+// Remaining returns how much runtime user has left in the current window.
+func (b *runtimeBudget) Remaining(user string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+
+	return max(b.limit-b.used[user], 0)
+}
+
+// Charge adds a run's container runtime to user's usage.
+func (b *runtimeBudget) Charge(user string, runtime time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+
+	b.used[user] += runtime
+}
+
+func (b *runtimeBudget) setHeader(w http.ResponseWriter, user string) {
+	w.Header().Set(budgetRemainingHeader, strconv.FormatInt(int64(b.Remaining(user).Seconds()), 10))
+}
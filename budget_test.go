@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRuntimeBudgetDisabled(t *testing.T) {
+	if budget := newRuntimeBudget(0, time.Hour); budget != nil {
+		t.Errorf("zero limit returned budget %v", budget)
+	}
+}
+
+func TestRuntimeBudgetCharge(t *testing.T) {
+	budget := newRuntimeBudget(time.Minute, 24*time.Hour)
+	budget.now = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	if remaining := budget.Remaining("alice"); remaining != time.Minute {
+		t.Errorf("new user has %s left, want 1m", remaining)
+	}
+	budget.Charge("alice", 20*time.Second)
+	budget.Charge("alice", 15*time.Second)
+	if remaining := budget.Remaining("alice"); remaining != 25*time.Second {
+		t.Errorf("alice has %s left after 35s of runs, want 25s", remaining)
+	}
+	if remaining := budget.Remaining("bob"); remaining != time.Minute {
+		t.Errorf("bob has %s left after alice's runs, want 1m", remaining)
+	}
+
+	// A run may go past what is left; the budget bottoms out at zero.
+	budget.Charge("alice", time.Minute)
+	if remaining := budget.Remaining("alice"); remaining != 0 {
+		t.Errorf("alice has %s left after overrunning, want 0", remaining)
+	}
+
+	w := httptest.NewRecorder()
+	budget.setHeader(w, "bob")
+	if got := w.Header().Get(budgetRemainingHeader); got != "60" {
+		t.Errorf("%s is %q, want 60", budgetRemainingHeader, got)
+	}
+}
+
+func TestRuntimeBudgetWindowExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	budget := newRuntimeBudget(time.Minute, 24*time.Hour)
+	budget.now = func() time.Time { return now }
+
+	budget.Charge("alice", time.Minute)
+	now = now.Add(59 * time.Second)
+	if remaining := budget.Remaining("alice"); remaining != 0 {
+		t.Errorf("alice has %s left before midnight, want 0", remaining)
+	}
+
+	// Windows are aligned to the epoch, so a day's window ends at midnight
+	// UTC however late in it the usage came.
+	now = now.Add(time.Second)
+	if remaining := budget.Remaining("alice"); remaining != time.Minute {
+		t.Errorf("alice has %s left at midnight, want the full 1m", remaining)
+	}
+	budget.Charge("alice", 10*time.Second)
+	now = now.Add(23 * time.Hour)
+	if remaining := budget.Remaining("alice"); remaining != 50*time.Second {
+		t.Errorf("alice has %s left later the same day, want 50s", remaining)
+	}
+}
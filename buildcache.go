@@ -0,0 +1,75 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// BuildCache counts the steps of a build that reused a cached layer and
+// those that had to run.
+type BuildCache struct {
+	Cached int `json:"cached"`
+	Built  int `json:"built"`
+	// HitRatio is Cached over all counted steps.
+	HitRatio float64 `json:"hitRatio"`
+}
+
+var (
+	// The legacy builder prints "Step 2/5 : COPY code.ts ." and, for a
+	// cached step, " ---> Using cache" after it.
+	legacyStep  = regexp.MustCompile(`^Step \d+/\d+ : (\S+)`)
+	legacyCache = regexp.MustCompile(`^\s*---> Using cache`)
+	// BuildKit's plain progress prints "#7 [2/4] COPY code.ts ." and
+	// "#7 CACHED" for a cached step.
+	buildkitStep  = regexp.MustCompile(`^#(\d+) \[(?:[^\]]+ )?\d+/\d+\] (\S+)`)
+	buildkitCache = regexp.MustCompile(`^#(\d+) CACHED\b`)
+)
+
+// parseBuildCache counts cached and built steps in either builder's output.
+// FROM steps pull rather than build, so they aren't counted.
+func parseBuildCache(output string) BuildCache {
+	cache := BuildCache{}
+	legacyPending := false
+	buildkitSteps := map[string]bool{}
+	buildkitCached := map[string]bool{}
+
+	for _, line := range strings.Split(ansiEscape.ReplaceAllString(output, ""), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if match := legacyStep.FindStringSubmatch(line); match != nil {
+			if legacyPending {
+				cache.Built++
+			}
+			legacyPending = !strings.EqualFold(match[1], "FROM")
+			continue
+		}
+		if legacyPending && legacyCache.MatchString(line) {
+			cache.Cached++
+			legacyPending = false
+			continue
+		}
+
+		if match := buildkitStep.FindStringSubmatch(line); match != nil && !strings.EqualFold(match[2], "FROM") {
+			buildkitSteps[match[1]] = true
+			continue
+		}
+		if match := buildkitCache.FindStringSubmatch(line); match != nil {
+			buildkitCached[match[1]] = true
+		}
+	}
+	if legacyPending {
+		cache.Built++
+	}
+	for step := range buildkitSteps {
+		if buildkitCached[step] {
+			cache.Cached++
+		} else {
+			cache.Built++
+		}
+	}
+
+	if total := cache.Cached + cache.Built; total > 0 {
+		cache.HitRatio = float64(cache.Cached) / float64(total)
+	}
+	return cache
+}
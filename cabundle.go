@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"testing/fstest"
+)
+
+// caBundleFile is where the CA_BUNDLE appears in every build context. It is
+// only there when CA_BUNDLE is set, so a deployment that needs it edits the
+// embedded Dockerfiles to trust it before anything is fetched, e.g. for the
+// packaged Deno image:
+//
+//	COPY ca-bundle.crt /usr/local/share/ca-certificates/gitblame.crt
+//	ENV DENO_CERT=/usr/local/share/ca-certificates/gitblame.crt
+//
+// npm-based images use NODE_EXTRA_CA_CERTS instead. A certificate copied
+// into the image is trusted by the test containers run from it too.
+const caBundleFile = "ca-bundle.crt"
+
+// caBundle is the configured bundle, set once at startup by loadCABundle.
+var caBundle []byte
+
+func loadCABundle(file string) error {
+	if file == "" {
+		return nil
+	}
+
+	bundle, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading CA bundle: %w", err)
+	}
+	if block, _ := pem.Decode(bundle); block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("CA bundle %s doesn't start with a PEM certificate", file)
+	}
+
+	caBundle = bundle
+	return nil
+}
+
+// addCABundle adds the configured bundle, if any, to a build context.
+func addCABundle(memFS fstest.MapFS) {
+	if caBundle != nil {
+		memFS[caBundleFile] = &fstest.MapFile{Data: caBundle, Mode: 0644}
+	}
+}
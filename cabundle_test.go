@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useCABundle loads a bundle holding one PEM block of type blockType,
+// restoring the previous bundle when the test ends.
+func useCABundle(t *testing.T, blockType string) ([]byte, error) {
+	t.Helper()
+
+	previous := caBundle
+	t.Cleanup(func() { caBundle = previous })
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: []byte("not really DER")})
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return bundle, loadCABundle(file)
+}
+
+func TestLoadCABundle(t *testing.T) {
+	previous := caBundle
+	t.Cleanup(func() { caBundle = previous })
+
+	if err := loadCABundle(""); err != nil || caBundle != nil {
+		t.Errorf("unset CA_BUNDLE loaded %q with error %v", caBundle, err)
+	}
+	if err := loadCABundle(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("missing CA bundle loaded")
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("just text"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadCABundle(notPEM); err == nil {
+		t.Error("CA bundle without PEM loaded")
+	}
+
+	if _, err := useCABundle(t, "PRIVATE KEY"); err == nil {
+		t.Error("CA bundle starting with a private key loaded")
+	}
+	if caBundle != nil {
+		t.Errorf("rejected bundles left %q loaded", caBundle)
+	}
+}
+
+func TestCABundleInBuildContext(t *testing.T) {
+	memFS, err := createFS("sum", "export const sum = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := memFS[caBundleFile]; ok {
+		t.Errorf("build context has %s without CA_BUNDLE", caBundleFile)
+	}
+
+	bundle, err := useCABundle(t, "CERTIFICATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memFS, err = createFS("sum", "export const sum = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, ok := memFS[caBundleFile]; !ok || !bytes.Equal(file.Data, bundle) {
+		t.Errorf("build context lacks the CA bundle")
+	}
+
+	// The bundle reaches the daemon with the rest of the context.
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t)
+	if err := buildImage(context.Background(), cfg, docker.client, "with-ca", Metadata{}, memFS, io.Discard, false); err != nil {
+		t.Fatal(err)
+	}
+	builds := docker.Builds()
+	if len(builds) != 1 || !bytes.Equal(builds[0].Files[caBundleFile], bundle) {
+		t.Errorf("build context sent to the daemon lacks the CA bundle")
+	}
+}
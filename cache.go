@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serveCached writes embedded content with a Cache-Control max-age and an
+// ETag derived from the content, answering If-None-Match with 304. The ETag
+// follows the bytes, so it changes whenever a build embeds different content.
+func serveCached(w http.ResponseWriter, r *http.Request, cfg *Config, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cfg.ContentMaxAge/time.Second)))
+	w.Header().Set("Content-Type", contentType)
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+// codeHash identifies a submission by the SHA-256 of its code.
+func codeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// submissionETag is the ETag of a run's response. It follows the submitted
+// code only, so identical submissions of a task share it whoever sends them.
+func submissionETag(hash string) string {
+	return `"` + hash[:32] + `"`
+}
+
+// etagMatches reports whether the request's If-None-Match lists etag. Weak
+// tags match their strong form.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := submissionETag(codeHash("export const sum = 1"))
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"absent", "", false},
+		{"exact", etag, true},
+		{"weak", "W/" + etag, true},
+		{"in a list", `"other", ` + etag, true},
+		{"wildcard", "*", true},
+		{"other code", submissionETag(codeHash("export const sum = 2")), false},
+		{"unquoted", strings.Trim(etag, `"`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/test/sum/run", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			if got := etagMatches(r, etag); got != tt.want {
+				t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunHandlerETag(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t).withImage(cfg, "sum").withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+	results := newResultStore(100, 100)
+	handler := runHandler(cfg, docker.client, nil, nil, nil, nil, nil, results)
+
+	post := func(code string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/test/sum/run", strings.NewReader(`{"user": "alice", "code": "`+code+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(t, "POST /test/{test}/run", handler, r)
+	}
+
+	// Nothing has run yet, so even a matching tag runs the submission.
+	etag := submissionETag(codeHash("export const sum = 1"))
+	first := post("export const sum = 1", etag)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first run responded %d: %s", first.Code, first.Body)
+	}
+	if got := first.Header().Get("ETag"); got != etag {
+		t.Errorf("ETag is %q, want %q", got, etag)
+	}
+
+	again := post("export const sum = 1", etag)
+	if again.Code != http.StatusNotModified || again.Body.Len() != 0 {
+		t.Errorf("repeat with a matching tag responded %d with %q, want an empty 304", again.Code, again.Body)
+	}
+	if got := again.Header().Get("ETag"); got != etag {
+		t.Errorf("304 ETag is %q, want %q", got, etag)
+	}
+	if got := len(docker.Containers()); got != 1 {
+		t.Errorf("matching tag started %d containers in all, want only the first run's", got)
+	}
+
+	changed := post("export const sum = 2", etag)
+	if changed.Code != http.StatusOK {
+		t.Errorf("different code with the old tag responded %d, want 200", changed.Code)
+	}
+	if got := changed.Header().Get("ETag"); got == etag {
+		t.Error("different code got the old code's ETag")
+	}
+	if got := len(docker.Containers()); got != 2 {
+		t.Errorf("non-matching tag started %d containers in all, want 2", got)
+	}
+}
+
+func TestRunHandlerETagDisabled(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"RESULT_CACHE_AGE": "0"})
+	docker := newFakeDocker(t).withImage(cfg, "sum").withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+	results := newResultStore(100, 100)
+	handler := runHandler(cfg, docker.client, nil, nil, nil, nil, nil, results)
+
+	etag := submissionETag(codeHash("export const sum = 1"))
+	for range 2 {
+		r := httptest.NewRequest("POST", "/test/sum/run", strings.NewReader(`{"user": "alice", "code": "export const sum = 1"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("If-None-Match", etag)
+		if w := serve(t, "POST /test/{test}/run", handler, r); w.Code != http.StatusOK {
+			t.Errorf("with RESULT_CACHE_AGE=0 responded %d, want 200", w.Code)
+		}
+	}
+	if got := len(docker.Containers()); got != 2 {
+		t.Errorf("started %d containers, want 2", got)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+const (
+	casesFromManifest = "manifest"
+	casesFromSource   = "parsed"
+)
+
+type taskCases struct {
+	Task string `json:"task"`
+	// Source is "manifest" when the names come from the task's cases.json
+	// and "parsed" when they were scraped from test.ts.
+	Source string   `json:"source"`
+	Cases  []string `json:"cases"`
+}
+
+// denoTestName matches the name of a Deno.test call, whether passed as the
+// first argument or as the name of an options object.
+var denoTestName = regexp.MustCompile(`Deno\.test\(\s*(?:\{[^}]*?\bname:\s*)?(?:"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)'|` + "`([^`$]*)`)")
+
+// loadTaskCases lists the test case names of a task without running it.
+// A packaged cases.json is authoritative; otherwise names are scraped from
+// test.ts, which misses tests whose names are computed.
+func loadTaskCases(task string) (taskCases, error) {
+	cases := taskCases{Task: task, Cases: []string{}}
+
+	if manifest, err := files.ReadFile(path.Join("tests", task, "cases.json")); err == nil {
+		if err := json.Unmarshal(manifest, &cases.Cases); err != nil {
+			return cases, fmt.Errorf("parsing cases.json for %s: %w", task, err)
+		}
+		cases.Source = casesFromManifest
+		return cases, nil
+	}
+
+	testFile, err := files.ReadFile(path.Join("tests", task, "test.ts"))
+	if err != nil {
+		return cases, err
+	}
+
+	cases.Source = casesFromSource
+	for _, match := range denoTestName.FindAllSubmatch(testFile, -1) {
+		for _, name := range match[1:] {
+			if name != nil {
+				cases.Cases = append(cases.Cases, string(name))
+				break
+			}
+		}
+	}
+	return cases, nil
+}
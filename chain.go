@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ChainLink records one finished submission of a user. Each link's Hash
+// covers its own fields and the previous link's Hash, so altering,
+// reordering or dropping an earlier submission breaks every later link.
+type ChainLink struct {
+	// Seq numbers the user's submissions from 1.
+	Seq      int       `json:"seq"`
+	JobID    string    `json:"jobId"`
+	Task     string    `json:"task"`
+	CodeHash string    `json:"codeHash"`
+	Status   string    `json:"status"`
+	Passed   int       `json:"passed"`
+	Failed   int       `json:"failed"`
+	Finished time.Time `json:"finished"`
+	// Prev is the previous link's Hash, empty for the first submission.
+	Prev string `json:"prev"`
+	// Hash is the hex SHA-256 of the fields above, one per line in this
+	// order, with Finished in RFC 3339 with nanoseconds.
+	Hash string `json:"hash"`
+}
+
+func (l ChainLink) digest() string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d\n%s\n%s\n%s\n%s\n%d\n%d\n%s\n%s",
+		l.Seq, l.JobID, l.Task, l.CodeHash, l.Status, l.Passed, l.Failed, l.Finished.Format(time.RFC3339Nano), l.Prev))
+	return hex.EncodeToString(sum[:])
+}
+
+// newChainLink links the finished job after prev, which is nil for the
+// user's first submission.
+func newChainLink(prev *ChainLink, job *Job) ChainLink {
+	link := ChainLink{Seq: 1, JobID: job.ID, Task: job.Task, CodeHash: job.CodeHash, Status: job.Status, Finished: *job.Finished}
+	if job.Result != nil {
+		link.Passed, link.Failed = job.Result.Passed, job.Result.Failed
+	}
+	if prev != nil {
+		link.Seq = prev.Seq + 1
+		link.Prev = prev.Hash
+	}
+	link.Hash = link.digest()
+	return link
+}
+
+// verifyChain returns the index of the first link whose hash or link to its
+// predecessor doesn't hold, or -1 if the chain is intact. The oldest links
+// may have been trimmed, so the first link's Prev is taken as given.
+func verifyChain(links []ChainLink) int {
+	for i, link := range links {
+		if link.Hash != link.digest() {
+			return i
+		}
+		if i > 0 && (link.Prev != links[i-1].Hash || link.Seq != links[i-1].Seq+1) {
+			return i
+		}
+	}
+	return -1
+}
+
+type chainVerification struct {
+	User  string `json:"user"`
+	Valid bool   `json:"valid"`
+	// BrokenAt is the index in Links of the first link that fails.
+	BrokenAt *int        `json:"brokenAt,omitempty"`
+	Links    []ChainLink `json:"links"`
+}
+
+// chainHandler serves GET /admin/users/{user}/chain: the user's submission
+// chain, oldest first, and whether it verifies. Clients can check it
+// themselves from the documented ChainLink hash.
+func chainHandler(store *resultStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("user")
+		links := store.Chain(user)
+
+		verification := chainVerification{User: user, Valid: true, Links: links}
+		if broken := verifyChain(links); broken >= 0 {
+			verification.Valid = false
+			verification.BrokenAt = &broken
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(marshalResponse(r, verification))
+	}
+}
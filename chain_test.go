@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordRuns records count finished runs of the user's, passing i of the
+// i-th one's cases.
+func recordRuns(store *resultStore, user string, count int) {
+	for i := range count {
+		req := RunRequest{Task: "sum", User: user, Code: fmt.Sprintf("export const sum = %d", i)}
+		store.Record(req, &RunResult{Passed: i, Failed: 1, Total: i + 1}, nil, nil)
+	}
+}
+
+func TestChainLinks(t *testing.T) {
+	store := newResultStore(10, 10)
+	recordRuns(store, "alice", 3)
+
+	chain := store.Chain("alice")
+	if len(chain) != 3 {
+		t.Fatalf("chain has %d links, want 3", len(chain))
+	}
+	for i, link := range chain {
+		if link.Seq != i+1 || link.Passed != i || link.Failed != 1 || link.Task != "sum" {
+			t.Errorf("link %d is %+v", i, link)
+		}
+		if link.CodeHash != codeHash(fmt.Sprintf("export const sum = %d", i)) {
+			t.Errorf("link %d has code hash %s", i, link.CodeHash)
+		}
+		if link.Hash != link.digest() {
+			t.Errorf("link %d has hash %s, want its digest %s", i, link.Hash, link.digest())
+		}
+	}
+	if chain[0].Prev != "" || chain[1].Prev != chain[0].Hash || chain[2].Prev != chain[1].Hash {
+		t.Errorf("links don't point at their predecessors: %+v", chain)
+	}
+	if verifyChain(chain) != -1 {
+		t.Errorf("recorded chain doesn't verify at %d", verifyChain(chain))
+	}
+
+	if chain := store.Chain("bob"); len(chain) != 0 {
+		t.Errorf("user without runs has chain %+v", chain)
+	}
+}
+
+// Unfinished jobs join the chain when they finish, once.
+func TestChainLinksFinishedJobs(t *testing.T) {
+	store := newResultStore(10, 10)
+	store.Put(&Job{ID: "job-1", Task: "sum", User: "alice", Status: jobQueued})
+	if chain := store.Chain("alice"); len(chain) != 0 {
+		t.Fatalf("queued job is linked: %+v", chain)
+	}
+
+	finish := func(job *Job) {
+		finished := time.Now().UTC()
+		job.Status, job.Finished = jobDone, &finished
+	}
+	store.update("job-1", finish)
+	store.update("job-1", func(job *Job) { job.Status = jobError })
+	if chain := store.Chain("alice"); len(chain) != 1 || chain[0].JobID != "job-1" || chain[0].Status != jobDone {
+		t.Errorf("chain after finishing the job is %+v", chain)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	store := newResultStore(10, 10)
+	recordRuns(store, "alice", 4)
+	intact := store.Chain("alice")
+
+	tests := []struct {
+		name   string
+		change func([]ChainLink) []ChainLink
+		want   int
+	}{
+		{"intact", func(links []ChainLink) []ChainLink { return links }, -1},
+		{"empty", func([]ChainLink) []ChainLink { return nil }, -1},
+		{"trimmed oldest", func(links []ChainLink) []ChainLink { return links[2:] }, -1},
+		{"tampered field", func(links []ChainLink) []ChainLink {
+			links[1].Passed = 100
+			return links
+		}, 1},
+		{"tampered field rehashed", func(links []ChainLink) []ChainLink {
+			links[1].Passed = 100
+			links[1].Hash = links[1].digest()
+			return links
+		}, 2},
+		{"tampered hash", func(links []ChainLink) []ChainLink {
+			links[3].Hash = links[2].Hash
+			return links
+		}, 3},
+		{"reordered", func(links []ChainLink) []ChainLink {
+			links[1], links[2] = links[2], links[1]
+			return links
+		}, 1},
+		{"dropped link", func(links []ChainLink) []ChainLink { return append(links[:1], links[2:]...) }, 1},
+		{"broken prev", func(links []ChainLink) []ChainLink {
+			links[2].Prev = links[0].Hash
+			links[2].Hash = links[2].digest()
+			return links
+		}, 2},
+		{"skipped seq", func(links []ChainLink) []ChainLink {
+			links[2].Seq = 4
+			links[2].Hash = links[2].digest()
+			return links
+		}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			links := test.change(append([]ChainLink{}, intact...))
+			if got := verifyChain(links); got != test.want {
+				t.Errorf("verifyChain = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// Chains keep the user's newest capacity of links, outliving the jobs,
+// and only the most recently extended chainUsers users keep one.
+func TestChainBounds(t *testing.T) {
+	store := newResultStore(3, 2)
+	recordRuns(store, "alice", 5)
+
+	chain := store.Chain("alice")
+	if len(chain) != 3 || chain[0].Seq != 3 || chain[2].Seq != 5 {
+		t.Fatalf("chain past capacity is %+v, want links 3 to 5", chain)
+	}
+	if verifyChain(chain) != -1 {
+		t.Errorf("trimmed chain doesn't verify at %d", verifyChain(chain))
+	}
+
+	recordRuns(store, "bob", 1)
+	recordRuns(store, "carol", 1)
+	if chain := store.Chain("alice"); len(chain) != 0 {
+		t.Errorf("least recently extended user kept chain %+v", chain)
+	}
+	if len(store.Chain("bob")) != 1 || len(store.Chain("carol")) != 1 {
+		t.Errorf("bob has %d links and carol %d, want 1 each", len(store.Chain("bob")), len(store.Chain("carol")))
+	}
+
+	// Extending bob's chain makes carol's the least recent.
+	recordRuns(store, "bob", 1)
+	recordRuns(store, "dave", 1)
+	if len(store.Chain("carol")) != 0 || len(store.Chain("bob")) != 2 || len(store.Chain("dave")) != 1 {
+		t.Errorf("after dave's run carol has %d links, bob %d and dave %d, want 0, 2 and 1", len(store.Chain("carol")), len(store.Chain("bob")), len(store.Chain("dave")))
+	}
+
+	// Bob's first job has gone from the store, but his chain keeps it.
+	if _, ok := store.Get(store.Chain("bob")[0].JobID); ok {
+		t.Error("store kept more jobs than its capacity")
+	}
+
+	// A dropped user starts a new chain.
+	recordRuns(store, "alice", 1)
+	if chain := store.Chain("alice"); len(chain) != 1 || chain[0].Seq != 1 || chain[0].Prev != "" {
+		t.Errorf("returning user's chain is %+v", chain)
+	}
+}
+
+func TestChainHandler(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ADMIN_TOKEN": "secret"})
+	store := newResultStore(10, 10)
+	recordRuns(store, "alice", 3)
+	handler := requireAdmin(cfg, chainHandler(store))
+
+	get := func(user string) (*httptest.ResponseRecorder, chainVerification) {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/admin/users/"+user+"/chain?format=json", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := serve(t, "GET /admin/users/{user}/chain", handler, r)
+		verification := chainVerification{}
+		if err := json.Unmarshal(w.Body.Bytes(), &verification); err != nil {
+			t.Fatalf("decoding chain %q: %v", w.Body, err)
+		}
+		return w, verification
+	}
+
+	w, verification := get("alice")
+	if w.Code != http.StatusOK || !verification.Valid || verification.BrokenAt != nil || verification.User != "alice" || len(verification.Links) != 3 {
+		t.Errorf("alice's chain responded %d with %+v", w.Code, verification)
+	}
+
+	store.mu.Lock()
+	store.chains["alice"][1].Status = jobError
+	store.mu.Unlock()
+	if _, verification := get("alice"); verification.Valid || verification.BrokenAt == nil || *verification.BrokenAt != 1 {
+		t.Errorf("tampered chain verifies as %+v, want broken at 1", verification)
+	}
+
+	if _, verification := get("bob"); !verification.Valid || len(verification.Links) != 0 {
+		t.Errorf("empty chain is %+v, want valid with no links", verification)
+	}
+
+	r := httptest.NewRequest("GET", "/admin/users/alice/chain", nil)
+	if w := serve(t, "GET /admin/users/{user}/chain", handler, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("without the token status is %d, want 401", w.Code)
+	}
+}
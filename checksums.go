@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+var errTestTampered = errors.New("test file checksum mismatch")
+
+// testChecksums maps each task to the expected SHA-256 of its test.ts. Entries
+// come from Config.TestChecksums, falling back to the digest recorded at
+// startup.
+//
+// Only configured entries protect anything: the fallback digest is taken
+// from the same embedded file it is later checked against, so that check
+// can't fail. Tamper detection therefore needs TEST_CHECKSUMS to list the
+// task, with sums taken from a trusted copy of the tests.
+var testChecksums = map[string]string{}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseTestChecksums parses a comma separated list of task=sha256 pairs.
+func parseTestChecksums(spec string) (map[string]string, error) {
+	checksums := map[string]string{}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		task, sum, ok := strings.Cut(pair, "=")
+		if !ok || task == "" || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid checksum entry %q", pair)
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return nil, fmt.Errorf("invalid checksum entry %q: %w", pair, err)
+		}
+
+		checksums[task] = strings.ToLower(sum)
+	}
+
+	return checksums, nil
+}
+
+// loadTestChecksums records the expected checksum of every packaged test,
+// from configured where it lists the task. Tasks it doesn't list are logged,
+// since checking them is a no-op.
+func loadTestChecksums(configured map[string]string) error {
+	testFiles, err := fs.Glob(files, "tests/*/test.ts")
+	if err != nil {
+		return err
+	}
+
+	unverified := []string{}
+	for _, testFile := range testFiles {
+		task := path.Base(path.Dir(testFile))
+		if sum, ok := configured[task]; ok {
+			testChecksums[task] = sum
+			continue
+		}
+
+		data, err := files.ReadFile(testFile)
+		if err != nil {
+			return err
+		}
+		testChecksums[task] = checksum(data)
+		unverified = append(unverified, task)
+	}
+	if len(unverified) > 0 {
+		slog.Warn("TEST_CHECKSUMS doesn't list these tasks, so their test files aren't verified", "tasks", strings.Join(unverified, ", "))
+	}
+
+	return nil
+}
+
+func verifyTestFile(task string, data []byte) error {
+	expected, ok := testChecksums[task]
+	if !ok {
+		return fmt.Errorf("%w: no checksum recorded for %s", errTestTampered, task)
+	}
+
+	if actual := checksum(data); actual != expected {
+		slog.Error("SECURITY: test file checksum mismatch", "task", task, "checksum", actual, "expected", expected)
+		return fmt.Errorf("%w for %s", errTestTampered, task)
+	}
+
+	return nil
+}
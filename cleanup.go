@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/moby/moby/api/types/filters"
+	"github.com/moby/moby/client"
+)
+
+const (
+	ownerLabel     = "gitblame.owner"
+	ownerValue     = "gitblame-testserver"
+	namespaceLabel = "gitblame.namespace"
+	// contentLabel records contextDigest on built images.
+	contentLabel = "gitblame.content"
+)
+
+// ownerLabels marks resources as created by this server and, when an image
+// prefix is configured, by this environment.
+func ownerLabels(cfg *Config) map[string]string {
+	labels := map[string]string{ownerLabel: ownerValue}
+	if cfg.ImagePrefix != "" {
+		labels[namespaceLabel] = cfg.ImagePrefix
+	}
+	return labels
+}
+
+// ownerFilters matches the resources labelled by ownerLabels.
+func ownerFilters(cfg *Config) []filters.KeyValuePair {
+	owned := []filters.KeyValuePair{filters.Arg("label", fmt.Sprintf("%s=%s", ownerLabel, ownerValue))}
+	if cfg.ImagePrefix != "" {
+		owned = append(owned, filters.Arg("label", fmt.Sprintf("%s=%s", namespaceLabel, cfg.ImagePrefix)))
+	}
+	return owned
+}
+
+// cleanupOrphans removes stopped containers and dangling images left behind
+// by a previous run of the server, identified by the ownership labels. With
+// an image prefix, only this environment's resources are removed.
+func cleanupOrphans(ctx context.Context, cfg *Config, cli *client.Client) error {
+	owned := ownerFilters(cfg)
+
+	containers, err := cli.ContainersPrune(ctx, filters.NewArgs(owned...))
+	if err != nil {
+		return fmt.Errorf("pruning containers: %w", err)
+	}
+	for _, id := range containers.ContainersDeleted {
+		slog.Info("cleanup: removed container", "container", id)
+	}
+
+	images, err := cli.ImagesPrune(ctx, filters.NewArgs(append(owned, filters.Arg("dangling", "true"))...))
+	if err != nil {
+		return fmt.Errorf("pruning images: %w", err)
+	}
+	for _, image := range images.ImagesDeleted {
+		if image.Deleted != "" {
+			slog.Info("cleanup: removed image", "image", image.Deleted)
+		}
+	}
+
+	slog.Info("cleanup finished",
+		"containers", len(containers.ContainersDeleted),
+		"images", len(images.ImagesDeleted),
+		"reclaimed_bytes", containers.SpaceReclaimed+images.SpaceReclaimed)
+
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+const maxCompositeTasks = 8
+
+var (
+	errUnknownTask       = errors.New("unknown task")
+	errIncompatibleTasks = errors.New("tasks can't share a run")
+)
+
+// CompositeRequest evaluates one submission against several tasks' tests.
+type CompositeRequest struct {
+	User  string   `json:"user"`
+	Code  string   `json:"code"`
+	Tasks []string `json:"tasks"`
+}
+
+// CompositeResult holds each task's result, keyed by task.
+type CompositeResult struct {
+	SchemaVersion string               `json:"schemaVersion"`
+	Results       map[string]RunResult `json:"results"`
+}
+
+func validateCompositeTasks(tasks []string) error {
+	if len(tasks) == 0 || len(tasks) > maxCompositeTasks {
+		return fmt.Errorf("between 1 and %d tasks are required", maxCompositeTasks)
+	}
+
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		if seen[task] {
+			return fmt.Errorf("task %s is listed twice", task)
+		}
+		seen[task] = true
+		if !taskExists(task) {
+			return fmt.Errorf("%w %s", errUnknownTask, task)
+		}
+	}
+	return nil
+}
+
+// compositeFS lays out the build context for a composite run. The shared
+// files sit at the root, and each task gets a directory of its own:
+//
+//	Dockerfile       image/Dockerfile.composite
+//	<task>/test.ts   the task's packaged test
+//	<task>/code.ts   the submission, copied once per task
+//
+// Each test imports "./code.ts", so the submission is repeated beside every
+// test rather than shared. The runner discovers all */test.ts files in one
+// run and reports one suite per file, named after its path.
+func compositeFS(tasks []string, code string) (fstest.MapFS, error) {
+	dockerfile, err := files.ReadFile("image/Dockerfile.composite")
+	if err != nil {
+		return nil, fmt.Errorf("reading composite Dockerfile: %w", err)
+	}
+	memFS := fstest.MapFS{
+		"Dockerfile": &fstest.MapFile{Data: dockerfile, Mode: 0644},
+	}
+
+	for _, task := range tasks {
+		testFile, err := files.ReadFile(path.Join("tests", task, "test.ts"))
+		if err != nil {
+			return nil, fmt.Errorf("reading test file for %s: %w", task, err)
+		}
+		if err := verifyTestFile(task, testFile); err != nil {
+			return nil, err
+		}
+
+		memFS[path.Join(task, "test.ts")] = &fstest.MapFile{Data: testFile, Mode: 0644}
+		memFS[path.Join(task, "code.ts")] = &fstest.MapFile{Data: []byte(code), Mode: 0644}
+	}
+	addCABundle(memFS)
+
+	return memFS, nil
+}
+
+// compositeMetadata combines the tasks' metadata into the settings of
+// their shared run, failing with errIncompatibleTasks when they can't be
+// combined:
+//
+//   - the timeout is the sum of the tasks', since their tests run one after
+//     another, or the server's if any task has none;
+//   - the memory and CPU limits are the largest any task gets;
+//   - build args, DNS servers and extra hosts are merged, and a build arg
+//     set to different values is an error;
+//   - the test command, entrypoint, stop signal, hostname, build network
+//     and seccomp profile apply to the one container, so every task must
+//     agree on them.
+//
+// A shared test command must not name test files: each task's test is at
+// <task>/test.ts. Settings describing a task's own layout, such as its
+// report path, don't apply; the composite Dockerfile fixes those.
+func compositeMetadata(cfg *Config, tasks []string, metas []Metadata) (Metadata, error) {
+	combined := Metadata{Rebuild: true}
+	var timeout time.Duration
+	memory, cpus := runResources(cfg, metas[0])
+	firstOpts, err := securityOpts(tasks[0], metas[0])
+	if err != nil {
+		return combined, err
+	}
+
+	for i, meta := range metas {
+		task := tasks[i]
+		if timeout >= 0 {
+			if taskTimeout, err := time.ParseDuration(meta.Timeout); err == nil {
+				timeout += taskTimeout
+			} else {
+				timeout = -1
+			}
+		}
+		taskMemory, taskCPUs := runResources(cfg, meta)
+		memory, cpus = largerLimit(memory, taskMemory), largerLimit(cpus, taskCPUs)
+
+		for name, value := range meta.BuildArgs {
+			if previous, ok := combined.BuildArgs[name]; ok && previous != value {
+				return combined, fmt.Errorf("%w: build arg %s differs for %s", errIncompatibleTasks, name, task)
+			}
+			if combined.BuildArgs == nil {
+				combined.BuildArgs = map[string]string{}
+			}
+			combined.BuildArgs[name] = value
+		}
+		combined.DNS = appendMissing(combined.DNS, meta.DNS)
+		combined.ExtraHosts = appendMissing(combined.ExtraHosts, meta.ExtraHosts)
+
+		opts, err := securityOpts(task, meta)
+		if err != nil {
+			return combined, err
+		}
+		if !slices.Equal(meta.TestCommand, metas[0].TestCommand) || !slices.Equal(meta.Entrypoint, metas[0].Entrypoint) {
+			return combined, fmt.Errorf("%w: %s and %s run different commands", errIncompatibleTasks, tasks[0], task)
+		}
+		if meta.stopSignal() != metas[0].stopSignal() || meta.Hostname != metas[0].Hostname || meta.BuildNetwork != metas[0].BuildNetwork {
+			return combined, fmt.Errorf("%w: %s and %s need different container settings", errIncompatibleTasks, tasks[0], task)
+		}
+		if !slices.Equal(opts, firstOpts) {
+			return combined, fmt.Errorf("%w: %s and %s use different seccomp profiles", errIncompatibleTasks, tasks[0], task)
+		}
+	}
+
+	if timeout > 0 {
+		combined.Timeout = timeout.String()
+	}
+	if memory > 0 {
+		combined.MemoryLimit = strconv.FormatInt(memory, 10)
+	}
+	combined.CPULimit = float64(cpus) / 1e9
+	combined.TestCommand = metas[0].TestCommand
+	combined.Entrypoint = metas[0].Entrypoint
+	combined.StopSignal = metas[0].StopSignal
+	combined.Hostname = metas[0].Hostname
+	combined.BuildNetwork = metas[0].BuildNetwork
+	combined.SeccompProfile = metas[0].SeccompProfile
+	combined.seccompTask = tasks[0]
+	return combined, nil
+}
+
+// largerLimit returns the looser of two resource limits, where zero is
+// unlimited.
+func largerLimit(a int64, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return max(a, b)
+}
+
+// appendMissing appends the values not already in list.
+func appendMissing(list []string, values []string) []string {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// executeComposite builds one image holding every task's tests and runs
+// them together under meta, from compositeMetadata, taking a slot from
+// builds and then from runs.
+func executeComposite(ctx context.Context, cfg *Config, cli *client.Client, req CompositeRequest, meta Metadata, builds *limiter, runs *limiter) (*Execution, error) {
+	memFS, err := compositeFS(req.Tasks, req.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	label := strings.Join(req.Tasks, "+")
+	execution := &Execution{ExitCode: -1}
+
+	buildStarted := time.Now()
+	imageName := userImageName(cfg, req.User, label)
+	buildLog := newCappedBuffer(maxArtifactLog)
+	err = buildWithSlot(ctx, builds, func() error {
+		return buildImage(ctx, cfg, cli, imageName, meta, memFS, buildLog, false)
+	})
+	execution.BuildLog = buildLog.Contents()
+	execution.BuildDuration = time.Since(buildStarted)
+	if err != nil {
+		return execution, err
+	}
+
+	return runImage(ctx, cfg, cli, RunRequest{Task: label, User: req.User, Code: req.Code, Runs: runs}, meta, imageName, execution)
+}
+
+// suiteTask returns the task a composite report suite or case belongs to:
+// the first directory of its test file's path.
+func suiteTask(name string) string {
+	name = strings.TrimPrefix(name, "file://")
+	name = strings.TrimPrefix(name, defaultWorkingDir+"/")
+	name = strings.TrimPrefix(name, "./")
+	task, _, _ := strings.Cut(name, "/")
+	return task
+}
+
+// compositeResult splits a composite run's report into a result per task.
+// It gives up waiting for a parse slot once ctx is done.
+func compositeResult(ctx context.Context, cfg *Config, tasks []string, execution *Execution) (CompositeResult, error) {
+	combined := CompositeResult{SchemaVersion: resultSchemaVersion, Results: map[string]RunResult{}}
+
+	if err := parseSlots.Acquire(ctx); err != nil {
+		return combined, fmt.Errorf("waiting to parse report: %w", err)
+	}
+	suites, err := parseJUnitSuites(execution.Report)
+	parseSlots.Release()
+	if err != nil {
+		return combined, err
+	}
+
+	byTask := map[string][]junitTestSuite{}
+	for _, suite := range suites {
+		for _, c := range suite.Cases {
+			task := suiteTask(c.Classname)
+			if task == "" {
+				task = suiteTask(suite.Name)
+			}
+			byTask[task] = append(byTask[task], junitTestSuite{Name: suite.Name, Cases: []junitTestCase{c}})
+		}
+	}
+
+	for _, task := range tasks {
+		result := resultFromSuites(byTask[task], cfg.MaxReportCases)
+		result.TimedOut = execution.TimedOut
+		result.MemoryExceeded = execution.MemoryExceeded
+		result.ExitReason = execution.ExitReason
+		result.TaskVersion = taskVersions[task]
+		result.assignCaseIDs()
+		applyPostProcessors(task, &result)
+		combined.Results[task] = result
+	}
+
+	return combined, nil
+}
+
+// compositeHandler serves POST /run, evaluating one submission against the
+// tests of every task it lists. Like a run of a single task, it is held to
+// the user's runtime budget and each task's concurrency limit, and it is
+// recorded, as a result per task, and logged.
+func compositeHandler(cfg *Config, cli *client.Client, builds *limiter, runs *limiter, budget *runtimeBudget, memory *memoryGuard, results *resultStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			writeError(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "Content-Type must be application/json")
+			return
+		}
+
+		req := CompositeRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if err := validateCompositeTasks(req.Tasks); errors.Is(err, errUnknownTask) {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+
+		metas := make([]Metadata, len(req.Tasks))
+		for i, task := range req.Tasks {
+			var err error
+			if metas[i], err = loadMetadata(task); err != nil {
+				slog.Error("loading metadata", "request_id", requestID(r.Context()), "task", task, "error", err)
+				writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+		}
+		meta, err := compositeMetadata(cfg, req.Tasks, metas)
+		if errors.Is(err, errIncompatibleTasks) {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		} else if err != nil {
+			writeRunError(w, r, err)
+			return
+		}
+
+		if budgetExhausted(w, r, budget, req.User) {
+			return
+		}
+
+		reserved := int64(len(req.Tasks)) * runMemory(cfg, req.Code)
+		if !memory.Reserve(reserved) {
+			writeError(w, r, http.StatusServiceUnavailable, codeOutOfMemory, "Server is out of memory for new runs")
+			return
+		}
+		defer memory.Release(reserved)
+
+		releaseTasks, ok := acquireTaskSlots(w, r, req.Tasks, metas)
+		if !ok {
+			return
+		}
+		defer releaseTasks()
+
+		run := RunRequest{Task: strings.Join(req.Tasks, "+"), User: req.User, Code: req.Code}
+		summary := newRunSummary(requestID(r.Context()), run)
+		defer summary.log()
+
+		execution, err := executeComposite(r.Context(), cfg, cli, req, meta, builds, runs)
+		chargeBudget(w, budget, req.User, execution)
+		var result CompositeResult
+		if err == nil {
+			result, err = compositeResult(r.Context(), cfg, req.Tasks, execution)
+		}
+		if err != nil {
+			summary.record(execution, nil, err)
+			for _, task := range req.Tasks {
+				results.Record(RunRequest{Task: task, User: req.User, Code: req.Code}, nil, err, nil)
+			}
+			slog.Error("running composite test", "request_id", requestID(r.Context()), "task", run.Task, "error", err)
+			writeRunError(w, r, err)
+			return
+		}
+
+		total := newRunResult()
+		for _, task := range req.Tasks {
+			taskResult := result.Results[task]
+			taskResult.recordCode(cfg, req.Code)
+			result.Results[task] = taskResult
+			results.Record(RunRequest{Task: task, User: req.User, Code: req.Code}, &taskResult, nil, nil)
+			total.Passed += taskResult.Passed
+			total.Failed += taskResult.Failed
+			total.Skipped += taskResult.Skipped
+			total.Total += taskResult.Total
+		}
+		summary.record(execution, &total, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(marshalResponse(r, result))
+	}
+}
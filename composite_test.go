@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompositeMetadata(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"RUN_MEMORY_LIMIT": "256m", "RUN_CPU_LIMIT": "1"})
+
+	meta, err := compositeMetadata(cfg, []string{"sum", "sub"}, []Metadata{
+		{Timeout: "20s", MemoryLimit: "512m", BuildArgs: map[string]string{"DENO_VERSION": "2.1.4"}, DNS: []string{"1.1.1.1"}},
+		{Timeout: "10s", CPULimit: 2, BuildArgs: map[string]string{"DENO_VERSION": "2.1.4", "MODE": "strict"}, DNS: []string{"1.1.1.1", "8.8.8.8"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Rebuild {
+		t.Error("composite run doesn't rebuild")
+	}
+	if meta.runTimeout(time.Hour) != 30*time.Second {
+		t.Errorf("timeout is %s, want the tasks' 30s together", meta.runTimeout(time.Hour))
+	}
+	memory, nanoCPUs := runResources(cfg, meta)
+	if memory != 512<<20 || nanoCPUs != 2e9 {
+		t.Errorf("limits are %d bytes and %d nano-CPUs, want the largest of each task's", memory, nanoCPUs)
+	}
+	if len(meta.BuildArgs) != 2 || meta.BuildArgs["MODE"] != "strict" || len(meta.DNS) != 2 {
+		t.Errorf("build args %v and DNS %v aren't merged", meta.BuildArgs, meta.DNS)
+	}
+
+	// A task without a timeout of its own gets the server's.
+	meta, err = compositeMetadata(cfg, []string{"sum", "sub"}, []Metadata{{Timeout: "20s"}, {}})
+	if err != nil || meta.Timeout != "" {
+		t.Errorf("timeout with an unbounded task is %q, %v; want the server's", meta.Timeout, err)
+	}
+}
+
+func TestCompositeMetadataIncompatible(t *testing.T) {
+	cfg := testConfig(t, nil)
+	tests := []struct {
+		name  string
+		metas []Metadata
+	}{
+		{"build arg", []Metadata{{BuildArgs: map[string]string{"V": "1"}}, {BuildArgs: map[string]string{"V": "2"}}}},
+		{"test command", []Metadata{{TestCommand: []string{"test", "--allow-net"}}, {}}},
+		{"entrypoint", []Metadata{{}, {Entrypoint: []string{"/bin/run"}}}},
+		{"stop signal", []Metadata{{StopSignal: "SIGINT"}, {}}},
+		{"hostname", []Metadata{{Hostname: "grader"}, {Hostname: "other"}}},
+	}
+	for _, test := range tests {
+		if _, err := compositeMetadata(cfg, []string{"sum", "sub"}, test.metas); !errors.Is(err, errIncompatibleTasks) {
+			t.Errorf("%s: got %v, want errIncompatibleTasks", test.name, err)
+		}
+	}
+}
+
+// compositeServer serves POST /run against the fake daemon.
+func compositeServer(t *testing.T, cfg *Config, docker *fakeDocker, budget *runtimeBudget, results *resultStore) *httptest.Server {
+	t.Helper()
+
+	router := http.NewServeMux()
+	router.HandleFunc("POST /run", compositeHandler(cfg, docker.client, nil, nil, budget, nil, results))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func postComposite(t *testing.T, server *httptest.Server, body string) *http.Response {
+	t.Helper()
+
+	resp, err := http.Post(server.URL+"/run", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestCompositeHandler(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t).withReport(junitReport(
+		`<testcase name="adds" classname="./sum/test.ts"/>`,
+		`<testcase name="subtracts" classname="./sub/test.ts"><failure message="off by one"/></testcase>`,
+	))
+	docker.runFor = 50 * time.Millisecond
+	budget := newRuntimeBudget(time.Hour, 24*time.Hour)
+	results := newResultStore(10, 10)
+	server := compositeServer(t, cfg, docker, budget, results)
+
+	resp := postComposite(t, server, `{"user": "alice", "code": "export const sum = 1", "tasks": ["sum", "sub"]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("composite run responded %d", resp.StatusCode)
+	}
+	result := CompositeResult{}
+	decodeBody(t, resp, &result)
+	if result.Results["sum"].Passed != 1 || result.Results["sub"].Failed != 1 {
+		t.Errorf("results are %+v", result.Results)
+	}
+
+	if resp.Header.Get(budgetRemainingHeader) == "" || budget.Remaining("alice") == time.Hour {
+		t.Error("composite run wasn't charged to the budget")
+	}
+	for _, task := range []string{"sum", "sub"} {
+		if jobs, _ := results.Task(task); len(jobs) != 1 || jobs[0].Result == nil || jobs[0].User != "alice" {
+			t.Errorf("%s has recorded jobs %+v, want the composite run's", task, jobs)
+		}
+	}
+	if builds := docker.Builds(); len(builds) != 1 || builds[0].Files["sum/test.ts"] == nil || builds[0].Files["sub/code.ts"] == nil {
+		t.Errorf("composite run didn't build one image of both tasks")
+	}
+}
+
+func TestCompositeHandlerBudgetExhausted(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t)
+	budget := newRuntimeBudget(time.Second, 24*time.Hour)
+	budget.Charge("alice", time.Second)
+	server := compositeServer(t, cfg, docker, budget, newResultStore(10, 10))
+
+	resp := postComposite(t, server, `{"user": "alice", "code": "export const sum = 1", "tasks": ["sum", "sub"]}`)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("composite run over budget responded %d, want 429", resp.StatusCode)
+	}
+	apiErr := apiError{}
+	decodeBody(t, resp, &apiErr)
+	if apiErr.Code != codeBudgetExhausted {
+		t.Errorf("error code is %q, want %q", apiErr.Code, codeBudgetExhausted)
+	}
+	if len(docker.Builds()) != 0 {
+		t.Error("composite run over budget built an image")
+	}
+}
+
+func TestCompositeResultCancelledWaitingToParse(t *testing.T) {
+	previous := parseSlots
+	parseSlots = newLimiter(0)
+	t.Cleanup(func() { parseSlots = previous })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := compositeResult(ctx, testConfig(t, nil), []string{"sum"}, &Execution{Report: []byte(junitReport())})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("parse with the client gone gave %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// imagePrefix is a Docker path component: lowercase alphanumerics joined by
+// single separators, so it can't end in one and run into what follows.
+var imagePrefix = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+
+// Config holds every setting read from the environment. It is loaded once at
+// startup and passed to the handlers and the run pipeline.
+type Config struct {
+	Addr           string
+	CleanupOnStart bool
+	// TestChecksums, from TEST_CHECKSUMS, lists the expected SHA-256 of
+	// tasks' test.ts. Tasks it doesn't list aren't verified; see
+	// testChecksums.
+	TestChecksums map[string]string
+	OTLPEndpoint  string
+	// LogFormat is "text" or "json"; LogAddSource adds the file and line to
+	// each record.
+	LogFormat      string
+	LogAddSource   bool
+	PostProcessors string
+	AdminToken     string
+	// ImagePrefix namespaces every image, as "<prefix>-<user>-..." for
+	// per-user images and "<prefix>/gitblame-base/<task>" for base images,
+	// and labels every created resource, so environments sharing a daemon
+	// don't collide. The separators are added, so it doesn't end in one.
+	ImagePrefix string
+	// ExposeTestFiles serves each task's test.ts. Off by default so
+	// students can't read the tests they're graded against.
+	ExposeTestFiles bool
+	// StrictTasks refuses to start when an embedded task is incomplete or,
+	// with SmokeTestOnStart, fails its smoke test.
+	StrictTasks bool
+	// SmokeTestOnStart runs every task's reference solution at startup.
+	SmokeTestOnStart bool
+	// WarmupOnStart builds every task's base image at startup. Runs are
+	// refused until it, and non-strict smoke tests, finish.
+	WarmupOnStart bool
+	// ContentMaxAge is how long clients may cache task content.
+	ContentMaxAge time.Duration
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+	// RequestDeadline cancels any non-streaming request still being handled
+	// after this long and responds 503. Zero disables it.
+	RequestDeadline time.Duration
+
+	RunTimeout      time.Duration
+	StopGracePeriod time.Duration
+	// WaitCheckInterval is how often a run whose wait hasn't returned
+	// inspects the container, in case the daemon missed its exit.
+	WaitCheckInterval time.Duration
+	// RunAttempts is how many times a run that fails for infrastructure
+	// reasons, not because of the submission, is tried before giving up,
+	// waiting RunRetryBackoff, doubling, between attempts. Only daemon
+	// errors count; see infraFailure.
+	RunAttempts     int
+	RunRetryBackoff time.Duration
+	// RunMemoryLimit and RunCPULimit are the memory, in bytes, and CPUs
+	// test containers get unless their task sets its own. RunMaxMemoryLimit
+	// and RunMaxCPULimit cap both. Zero means no limit or no cap.
+	RunMemoryLimit    int64
+	RunMaxMemoryLimit int64
+	RunCPULimit       float64
+	RunMaxCPULimit    float64
+	// MemoryKillThreshold kills a run once its memory use reaches this
+	// percentage of the container's limit. Zero leaves it to the OOM killer.
+	MemoryKillThreshold int
+	MaxConcurrentRuns   int
+	// MaxConcurrentBuilds bounds image builds separately from runs, which
+	// acquire a run slot only once their build is done.
+	MaxConcurrentBuilds int
+	// MaxImageAge forces a rebuild of base images older than this. Zero
+	// keeps them until their content changes.
+	MaxImageAge time.Duration
+	// AllowedBaseImages lists, comma-separated, the images Dockerfiles may
+	// build FROM: "name" allows any tag of it, "name:tag" that tag only and
+	// "registry/path/*" anything under the path. Empty allows any image.
+	AllowedBaseImages string
+	MaxContextFiles   int
+	// MaxRepeatRuns bounds ?repeat=N, which runs a submission N times to
+	// find flaky tests.
+	MaxRepeatRuns int
+	// MaxReportCases caps the cases returned per result. Zero returns all.
+	MaxReportCases int
+	// ReportParseWorkers caps how many reports are parsed at once.
+	ReportParseWorkers int
+	// MaxReportBytes caps the size of the report read from a container.
+	MaxReportBytes int64
+	// ReportStrategy is how reports leave the container: "copy" reads them
+	// with CopyFromContainer, "mount" bind-mounts a fresh directory under
+	// ReportMountDir, on the daemon's host, at the report path.
+	ReportStrategy string
+	ReportMountDir string
+	// ReportPollInterval is how often tasks with pollReport look for their
+	// report while the runner is still going.
+	ReportPollInterval time.Duration
+	// MemoryBudget caps the bytes buffered by all runs in flight, estimated
+	// per run from its submission and MaxReportBytes. Zero disables it.
+	MemoryBudget      int64
+	WarmupConcurrency int
+	// AsyncQueueSize bounds the runs waiting in the async queue and
+	// ResultStoreSize the results kept for polling and stats.
+	AsyncQueueSize  int
+	ResultStoreSize int
+	// ChainUsers bounds the users whose submission chains are kept; the
+	// one whose chain was extended least recently is dropped beyond it.
+	ChainUsers int
+	// ResultCacheAge is how long a result answers If-None-Match for an
+	// identical submission. Zero disables it.
+	ResultCacheAge time.Duration
+	// KeepArtifacts keeps each stored result's build log, run log, report
+	// and code so GET /results/{id}/bundle can serve them.
+	KeepArtifacts bool
+	// EchoCode includes the submitted code in results, unless it is larger
+	// than EchoCodeMaxBytes. Results always carry the code's hash.
+	EchoCode         bool
+	EchoCodeMaxBytes int
+	// RuntimeBudget is the container runtime each user may use per
+	// RuntimeBudgetWindow. Zero disables the budget.
+	RuntimeBudget       time.Duration
+	RuntimeBudgetWindow time.Duration
+
+	// WebhookAllowedHosts lists, comma-separated, the hosts async callbacks
+	// may be sent to. Callbacks are disabled when it is empty.
+	WebhookAllowedHosts string
+	// WebhookSecret signs callback bodies.
+	WebhookSecret   string
+	WebhookAttempts int
+
+	// BuildNetworkMode is the network used by image builds, which may need
+	// to fetch dependencies; RunNetworkMode is the network test containers
+	// get. Tasks can override the build network in their metadata.
+	BuildNetworkMode string
+	RunNetworkMode   string
+	// SeccompProfile selects the run containers' seccomp profile; see
+	// seccomp.go.
+	SeccompProfile string
+	// CABundle is the path of a PEM bundle of extra CA certificates that
+	// builds need, e.g. behind a TLS-intercepting proxy; see caBundleFile.
+	CABundle string
+	// RunLogDriver and RunLogOpts, comma-separated key=value pairs, are
+	// the log driver of test containers. The default json-file driver is
+	// size-capped so chatty runs can't fill the daemon's disk; other
+	// drivers get no options unless RUN_LOG_OPTS sets them. The server
+	// reads container logs for progress, stdout comparison and stderr
+	// parsing, which a driver such as "none" turns off.
+	RunLogDriver string
+	RunLogOpts   map[string]string
+	// RunHostname and RunDomainname name test containers, so that the code
+	// sees a fixed, neutral identity instead of a container ID or, under
+	// host networking, the host's own name. Tasks can override the
+	// hostname in their metadata.
+	RunHostname   string
+	RunDomainname string
+
+	UlimitNofile int64
+	UlimitFsize  int64
+	UlimitNproc  int64
+}
+
+type envReader struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (e *envReader) string(name string, fallback string) string {
+	if value := e.getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (e *envReader) bool(name string, fallback bool) bool {
+	value := e.getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a boolean", name, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (e *envReader) int(name string, fallback int) int {
+	value := e.getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a non-negative integer", name, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (e *envReader) float(name string, fallback float64) float64 {
+	value := e.getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a non-negative number", name, value))
+		return fallback
+	}
+	return parsed
+}
+
+// bytes reads a size such as "512m"; see parseByteSize.
+func (e *envReader) bytes(name string, fallback int64) int64 {
+	value := e.getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := parseByteSize(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+		return fallback
+	}
+	return parsed
+}
+
+func (e *envReader) duration(name string, fallback time.Duration) time.Duration {
+	value := e.getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a non-negative duration", name, value))
+		return fallback
+	}
+	return parsed
+}
+
+// loadConfig reads the configuration through getenv, normally os.Getenv,
+// reporting every invalid value at once.
+func loadConfig(getenv func(string) string) (*Config, error) {
+	env := &envReader{getenv: getenv}
+
+	cfg := &Config{
+		Addr:             env.string("ADDR", ":8086"),
+		CleanupOnStart:   env.bool("CLEANUP_ON_START", false),
+		PostProcessors:   env.string("POST_PROCESSORS", ""),
+		AdminToken:       env.string("ADMIN_TOKEN", ""),
+		ImagePrefix:      env.string("IMAGE_PREFIX", ""),
+		ExposeTestFiles:  env.bool("EXPOSE_TEST_FILES", false),
+		StrictTasks:      env.bool("STRICT_TASKS", false),
+		SmokeTestOnStart: env.bool("SMOKE_TEST_ON_START", false),
+		WarmupOnStart:    env.bool("WARMUP_ON_START", false),
+		ContentMaxAge:    env.duration("CONTENT_MAX_AGE", 5*time.Minute),
+		OTLPEndpoint:     env.string("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", getenv("OTEL_EXPORTER_OTLP_ENDPOINT")),
+		LogFormat:        env.string("LOG_FORMAT", logFormatText),
+		LogAddSource:     env.bool("LOG_ADD_SOURCE", false),
+
+		ReadHeaderTimeout: env.duration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       env.duration("HTTP_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      env.duration("HTTP_WRITE_TIMEOUT", 10*time.Minute),
+		RequestDeadline:   env.duration("REQUEST_DEADLINE", 10*time.Minute),
+		IdleTimeout:       env.duration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    env.int("HTTP_MAX_HEADER_BYTES", 64<<10),
+		ShutdownTimeout:   env.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		RunTimeout:          env.duration("RUN_TIMEOUT", 2*time.Minute),
+		StopGracePeriod:     env.duration("STOP_GRACE_PERIOD", 5*time.Second),
+		WaitCheckInterval:   env.duration("WAIT_CHECK_INTERVAL", 30*time.Second),
+		RunAttempts:         env.int("RUN_ATTEMPTS", 1),
+		RunRetryBackoff:     env.duration("RUN_RETRY_BACKOFF", time.Second),
+		RunMemoryLimit:      env.bytes("RUN_MEMORY_LIMIT", 0),
+		RunMaxMemoryLimit:   env.bytes("RUN_MAX_MEMORY_LIMIT", 0),
+		RunCPULimit:         env.float("RUN_CPU_LIMIT", 0),
+		RunMaxCPULimit:      env.float("RUN_MAX_CPU_LIMIT", 0),
+		MemoryKillThreshold: env.int("MEMORY_KILL_THRESHOLD", 95),
+		MaxConcurrentRuns:   env.int("MAX_CONCURRENT_RUNS", 4),
+		MaxConcurrentBuilds: env.int("MAX_CONCURRENT_BUILDS", 2),
+		MaxImageAge:         env.duration("MAX_IMAGE_AGE", 0),
+		AllowedBaseImages:   env.string("ALLOWED_BASE_IMAGES", ""),
+		MaxContextFiles:     env.int("MAX_CONTEXT_FILES", 100),
+		MaxRepeatRuns:       env.int("MAX_REPEAT_RUNS", 5),
+		MaxReportCases:      env.int("MAX_REPORT_CASES", 1000),
+		ReportParseWorkers:  env.int("REPORT_PARSE_WORKERS", runtime.NumCPU()),
+		MaxReportBytes:      env.bytes("MAX_REPORT_BYTES", 10<<20),
+		ReportStrategy:      env.string("REPORT_STRATEGY", reportCopy),
+		ReportPollInterval:  env.duration("REPORT_POLL_INTERVAL", 500*time.Millisecond),
+		ReportMountDir:      env.string("REPORT_MOUNT_DIR", ""),
+		MemoryBudget:        env.bytes("MEMORY_BUDGET", 512<<20),
+		WarmupConcurrency:   env.int("WARMUP_CONCURRENCY", 2),
+		AsyncQueueSize:      env.int("ASYNC_QUEUE_SIZE", 100),
+		ResultStoreSize:     env.int("RESULT_STORE_SIZE", 1000),
+		ChainUsers:          env.int("CHAIN_USERS", 1000),
+		ResultCacheAge:      env.duration("RESULT_CACHE_AGE", 10*time.Minute),
+		KeepArtifacts:       env.bool("KEEP_ARTIFACTS", false),
+		EchoCode:            env.bool("ECHO_CODE", false),
+		EchoCodeMaxBytes:    env.int("ECHO_CODE_MAX_BYTES", 64<<10),
+		RuntimeBudget:       env.duration("RUNTIME_BUDGET", 0),
+		RuntimeBudgetWindow: env.duration("RUNTIME_BUDGET_WINDOW", 24*time.Hour),
+
+		WebhookAllowedHosts: env.string("WEBHOOK_ALLOWED_HOSTS", ""),
+		WebhookSecret:       env.string("WEBHOOK_SECRET", ""),
+		WebhookAttempts:     env.int("WEBHOOK_ATTEMPTS", 3),
+
+		BuildNetworkMode: env.string("BUILD_NETWORK_MODE", "host"),
+		RunNetworkMode:   env.string("RUN_NETWORK_MODE", "none"),
+		SeccompProfile:   env.string("SECCOMP_PROFILE", ""),
+		CABundle:         env.string("CA_BUNDLE", ""),
+		RunLogDriver:     env.string("RUN_LOG_DRIVER", "json-file"),
+		RunHostname:      env.string("RUN_HOSTNAME", "sandbox"),
+		RunDomainname:    env.string("RUN_DOMAINNAME", ""),
+
+		UlimitNofile: int64(env.int("ULIMIT_NOFILE", 1024)),
+		UlimitFsize:  env.bytes("ULIMIT_FSIZE", 64<<20),
+		UlimitNproc:  int64(env.int("ULIMIT_NPROC", 0)),
+	}
+
+	checksums, err := parseTestChecksums(getenv("TEST_CHECKSUMS"))
+	if err != nil {
+		env.errs = append(env.errs, fmt.Errorf("TEST_CHECKSUMS: %w", err))
+	}
+	cfg.TestChecksums = checksums
+
+	// The default options are json-file's; other drivers, such as none,
+	// would refuse them.
+	defaultLogOpts := ""
+	if cfg.RunLogDriver == "json-file" {
+		defaultLogOpts = "max-size=10m,max-file=1"
+	}
+	logOpts, err := parseLogOpts(env.string("RUN_LOG_OPTS", defaultLogOpts))
+	if err != nil {
+		env.errs = append(env.errs, fmt.Errorf("RUN_LOG_OPTS: %w", err))
+	}
+	cfg.RunLogOpts = logOpts
+
+	if cfg.ImagePrefix != "" && !imagePrefix.MatchString(cfg.ImagePrefix) {
+		env.errs = append(env.errs, fmt.Errorf("IMAGE_PREFIX: %q must be lowercase letters and digits, joined by '.', '_' or '-'", cfg.ImagePrefix))
+	}
+	if cfg.MaxConcurrentRuns == 0 {
+		env.errs = append(env.errs, errors.New("MAX_CONCURRENT_RUNS must be positive"))
+	}
+	if cfg.MaxConcurrentBuilds == 0 {
+		env.errs = append(env.errs, errors.New("MAX_CONCURRENT_BUILDS must be positive"))
+	}
+	if cfg.LogFormat != logFormatText && cfg.LogFormat != logFormatJSON {
+		env.errs = append(env.errs, fmt.Errorf("LOG_FORMAT: %q is not text or json", cfg.LogFormat))
+	}
+	if cfg.WarmupConcurrency == 0 {
+		env.errs = append(env.errs, errors.New("WARMUP_CONCURRENCY must be positive"))
+	}
+	if cfg.MaxRepeatRuns == 0 {
+		env.errs = append(env.errs, errors.New("MAX_REPEAT_RUNS must be positive"))
+	}
+	if cfg.ResultStoreSize == 0 {
+		env.errs = append(env.errs, errors.New("RESULT_STORE_SIZE must be positive"))
+	}
+	if cfg.ChainUsers == 0 {
+		env.errs = append(env.errs, errors.New("CHAIN_USERS must be positive"))
+	}
+	if cfg.WebhookAllowedHosts != "" && cfg.WebhookSecret == "" {
+		env.errs = append(env.errs, errors.New("WEBHOOK_SECRET is required when WEBHOOK_ALLOWED_HOSTS is set"))
+	}
+	if cfg.WebhookAttempts == 0 {
+		env.errs = append(env.errs, errors.New("WEBHOOK_ATTEMPTS must be positive"))
+	}
+	if cfg.RunMemoryLimit != 0 && cfg.RunMemoryLimit < minMemoryLimit {
+		env.errs = append(env.errs, fmt.Errorf("RUN_MEMORY_LIMIT must be at least %d bytes", minMemoryLimit))
+	}
+	if cfg.RunMaxMemoryLimit != 0 && cfg.RunMaxMemoryLimit < minMemoryLimit {
+		env.errs = append(env.errs, fmt.Errorf("RUN_MAX_MEMORY_LIMIT must be at least %d bytes", minMemoryLimit))
+	}
+	if cfg.MemoryKillThreshold > 100 {
+		env.errs = append(env.errs, fmt.Errorf("MEMORY_KILL_THRESHOLD: %d is not a percentage", cfg.MemoryKillThreshold))
+	}
+	if cfg.ReportStrategy != reportCopy && cfg.ReportStrategy != reportMount {
+		env.errs = append(env.errs, fmt.Errorf("REPORT_STRATEGY: %q is not copy or mount", cfg.ReportStrategy))
+	}
+	if cfg.RunTimeout == 0 {
+		env.errs = append(env.errs, errors.New("RUN_TIMEOUT must be positive"))
+	}
+	if cfg.ReportParseWorkers == 0 {
+		env.errs = append(env.errs, errors.New("REPORT_PARSE_WORKERS must be positive"))
+	}
+	if cfg.ReportPollInterval == 0 {
+		env.errs = append(env.errs, errors.New("REPORT_POLL_INTERVAL must be positive"))
+	}
+	if cfg.WaitCheckInterval == 0 {
+		env.errs = append(env.errs, errors.New("WAIT_CHECK_INTERVAL must be positive"))
+	}
+	if cfg.RunAttempts == 0 {
+		env.errs = append(env.errs, errors.New("RUN_ATTEMPTS must be positive"))
+	}
+	if cfg.RuntimeBudget != 0 && cfg.RuntimeBudgetWindow == 0 {
+		env.errs = append(env.errs, errors.New("RUNTIME_BUDGET_WINDOW must be positive"))
+	}
+	if !networkModes[cfg.BuildNetworkMode] {
+		env.errs = append(env.errs, fmt.Errorf("BUILD_NETWORK_MODE: %q is not one of default, bridge, host or none", cfg.BuildNetworkMode))
+	}
+	if !networkModes[cfg.RunNetworkMode] {
+		env.errs = append(env.errs, fmt.Errorf("RUN_NETWORK_MODE: %q is not one of default, bridge, host or none", cfg.RunNetworkMode))
+	}
+	if !hostLabel.MatchString(cfg.RunHostname) {
+		env.errs = append(env.errs, fmt.Errorf("RUN_HOSTNAME: %q is not a valid hostname", cfg.RunHostname))
+	}
+	if cfg.RunDomainname != "" && !validDomainname(cfg.RunDomainname) {
+		env.errs = append(env.errs, fmt.Errorf("RUN_DOMAINNAME: %q is not a valid domain name", cfg.RunDomainname))
+	}
+	if cfg.RequestDeadline != 0 && cfg.RequestDeadline < cfg.RunTimeout {
+		env.errs = append(env.errs, fmt.Errorf("REQUEST_DEADLINE %s is shorter than RUN_TIMEOUT %s", cfg.RequestDeadline, cfg.RunTimeout))
+	}
+	if cfg.WriteTimeout != 0 && cfg.WriteTimeout < cfg.RunTimeout {
+		env.errs = append(env.errs, fmt.Errorf("HTTP_WRITE_TIMEOUT %s is shorter than RUN_TIMEOUT %s", cfg.WriteTimeout, cfg.RunTimeout))
+	}
+
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
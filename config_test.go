@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg := testConfig(t, nil)
+
+	if cfg.Addr != ":8086" {
+		t.Errorf("Addr = %q, want :8086", cfg.Addr)
+	}
+	if cfg.RunTimeout != 2*time.Minute {
+		t.Errorf("RunTimeout = %s, want 2m", cfg.RunTimeout)
+	}
+	if cfg.MaxConcurrentRuns != 4 || cfg.MaxConcurrentBuilds != 2 {
+		t.Errorf("concurrency = %d runs, %d builds, want 4 and 2", cfg.MaxConcurrentRuns, cfg.MaxConcurrentBuilds)
+	}
+	if cfg.RunNetworkMode != "none" {
+		t.Errorf("RunNetworkMode = %q, want none", cfg.RunNetworkMode)
+	}
+	if cfg.ReportStrategy != reportCopy {
+		t.Errorf("ReportStrategy = %q, want %q", cfg.ReportStrategy, reportCopy)
+	}
+	if cfg.RunLogOpts["max-size"] != "10m" {
+		t.Errorf("RunLogOpts = %v, want a max-size", cfg.RunLogOpts)
+	}
+}
+
+func TestLoadConfigFromEnvironment(t *testing.T) {
+	cfg := testConfig(t, map[string]string{
+		"ADDR":                ":9000",
+		"RUN_TIMEOUT":         "30s",
+		"MAX_CONCURRENT_RUNS": "8",
+		"EXPOSE_TEST_FILES":   "true",
+		"RUN_MEMORY_LIMIT":    "512m",
+		"RUN_CPU_LIMIT":       "1.5",
+		"TEST_CHECKSUMS":      "sum=" + strings.Repeat("ab", 32),
+		"RUN_LOG_OPTS":        "max-size=1m",
+	})
+
+	if cfg.Addr != ":9000" {
+		t.Errorf("Addr = %q, want :9000", cfg.Addr)
+	}
+	if cfg.RunTimeout != 30*time.Second {
+		t.Errorf("RunTimeout = %s, want 30s", cfg.RunTimeout)
+	}
+	if cfg.MaxConcurrentRuns != 8 {
+		t.Errorf("MaxConcurrentRuns = %d, want 8", cfg.MaxConcurrentRuns)
+	}
+	if !cfg.ExposeTestFiles {
+		t.Error("ExposeTestFiles isn't set")
+	}
+	if cfg.RunMemoryLimit != 512<<20 {
+		t.Errorf("RunMemoryLimit = %d, want %d", cfg.RunMemoryLimit, 512<<20)
+	}
+	if cfg.RunCPULimit != 1.5 {
+		t.Errorf("RunCPULimit = %g, want 1.5", cfg.RunCPULimit)
+	}
+	if cfg.TestChecksums["sum"] != strings.Repeat("ab", 32) {
+		t.Errorf("TestChecksums = %v", cfg.TestChecksums)
+	}
+	if len(cfg.RunLogOpts) != 1 || cfg.RunLogOpts["max-size"] != "1m" {
+		t.Errorf("RunLogOpts = %v, want only max-size=1m", cfg.RunLogOpts)
+	}
+}
+
+func TestLoadConfigReportsEveryError(t *testing.T) {
+	env := map[string]string{
+		"RUN_TIMEOUT":           "soon",
+		"MAX_CONCURRENT_RUNS":   "-1",
+		"CLEANUP_ON_START":      "maybe",
+		"LOG_FORMAT":            "xml",
+		"WEBHOOK_ALLOWED_HOSTS": "example.com",
+	}
+	_, err := loadConfig(func(name string) string { return env[name] })
+	if err == nil {
+		t.Fatal("loadConfig succeeded")
+	}
+
+	for _, name := range []string{"RUN_TIMEOUT", "MAX_CONCURRENT_RUNS", "CLEANUP_ON_START", "LOG_FORMAT", "WEBHOOK_SECRET"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error doesn't mention %s: %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfigImagePrefix(t *testing.T) {
+	for _, prefix := range []string{"staging", "ci.team-a", "pr_123", "a--b"} {
+		if _, err := loadConfig(func(name string) string { return map[string]string{"IMAGE_PREFIX": prefix}[name] }); err != nil {
+			t.Errorf("IMAGE_PREFIX %q rejected: %v", prefix, err)
+		}
+	}
+	// The separator is added, so a prefix ending in one would double it.
+	for _, prefix := range []string{"staging-", "staging.", "staging_", "staging/", "-staging", "Staging", "a..b"} {
+		if _, err := loadConfig(func(name string) string { return map[string]string{"IMAGE_PREFIX": prefix}[name] }); err == nil {
+			t.Errorf("IMAGE_PREFIX %q accepted", prefix)
+		}
+	}
+}
+
+func TestLoadConfigByteSizes(t *testing.T) {
+	cfg := testConfig(t, map[string]string{
+		"MAX_REPORT_BYTES": "512k",
+		"MEMORY_BUDGET":    "1g",
+		"ULIMIT_FSIZE":     "1048576",
+	})
+	if cfg.MaxReportBytes != 512<<10 || cfg.MemoryBudget != 1<<30 || cfg.UlimitFsize != 1<<20 {
+		t.Errorf("sizes are %d, %d and %d, want %d, %d and %d", cfg.MaxReportBytes, cfg.MemoryBudget, cfg.UlimitFsize, 512<<10, 1<<30, 1<<20)
+	}
+
+	env := map[string]string{"MAX_REPORT_BYTES": "10 MB", "MEMORY_BUDGET": "-1", "ULIMIT_FSIZE": "64x"}
+	_, err := loadConfig(func(name string) string { return env[name] })
+	if err == nil {
+		t.Fatal("loadConfig accepted invalid sizes")
+	}
+	for name := range env {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error doesn't mention %s: %v", name, err)
+		}
+	}
+}
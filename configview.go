@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const redacted = "[redacted]"
+
+// secretWords mark log driver options whose values are credentials.
+var secretWords = []string{"token", "secret", "password", "key"}
+
+// redactedConfig returns the configuration with its secrets replaced by
+// "[redacted]" when set, keyed by field name. Durations are written as
+// strings like "2m0s".
+func redactedConfig(cfg *Config) map[string]any {
+	view := *cfg
+	for _, secret := range []*string{&view.AdminToken, &view.WebhookSecret} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	// Collectors may take credentials in the endpoint's userinfo.
+	if endpoint, err := url.Parse(view.OTLPEndpoint); err == nil && endpoint.User != nil {
+		endpoint.User = url.User(redacted)
+		view.OTLPEndpoint = endpoint.String()
+	}
+	view.RunLogOpts = map[string]string{}
+	for name, value := range cfg.RunLogOpts {
+		for _, word := range secretWords {
+			if strings.Contains(strings.ToLower(name), word) {
+				value = redacted
+				break
+			}
+		}
+		view.RunLogOpts[name] = value
+	}
+
+	fields := map[string]any{}
+	value := reflect.ValueOf(view)
+	for i := range value.NumField() {
+		field := value.Field(i)
+		if duration, ok := field.Interface().(time.Duration); ok {
+			fields[value.Type().Field(i).Name] = duration.String()
+			continue
+		}
+		fields[value.Type().Field(i).Name] = field.Interface()
+	}
+	return fields
+}
+
+// configHandler serves GET /admin/config, the configuration the server
+// resolved at startup, secrets redacted.
+func configHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(marshalResponse(r, redactedConfig(cfg)))
+	}
+}
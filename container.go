@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+var (
+	errRunTimedOut    = errors.New("test run timed out")
+	errMemoryExceeded = errors.New("memory limit exceeded")
+)
+
+// ulimits returns the resource limits applied to every test container. A
+// zero value leaves the daemon's default in place.
+//
+//   - nofile caps open file descriptors, bounding descriptor exhaustion.
+//   - fsize caps the size in bytes of any single file the test writes,
+//     including the report.
+//   - nproc caps processes and threads. The kernel counts these per UID
+//     across the whole host, not per container, so it is off by default and
+//     should only be set when tests run as a dedicated user.
+func ulimits(cfg *Config) []*container.Ulimit {
+	limits := []*container.Ulimit{}
+	for _, limit := range []container.Ulimit{
+		{Name: "nofile", Soft: cfg.UlimitNofile},
+		{Name: "fsize", Soft: cfg.UlimitFsize},
+		{Name: "nproc", Soft: cfg.UlimitNproc},
+	} {
+		if limit.Soft > 0 {
+			limits = append(limits, &container.Ulimit{Name: limit.Name, Soft: limit.Soft, Hard: limit.Soft})
+		}
+	}
+
+	return limits
+}
+
+// parseLogOpts parses a comma separated list of key=value log driver
+// options.
+func parseLogOpts(spec string) (map[string]string, error) {
+	opts := map[string]string{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid log option %q", pair)
+		}
+		opts[key] = value
+	}
+	return opts, nil
+}
+
+func hostConfig(cfg *Config, meta Metadata, securityOpt []string) *container.HostConfig {
+	memory, nanoCPUs := runResources(cfg, meta)
+	hostConfig := &container.HostConfig{
+		NetworkMode: container.NetworkMode(cfg.RunNetworkMode),
+		SecurityOpt: securityOpt,
+		LogConfig:   container.LogConfig{Type: cfg.RunLogDriver, Config: cfg.RunLogOpts},
+		Resources: container.Resources{
+			Memory:   memory,
+			NanoCPUs: nanoCPUs,
+			Ulimits:  ulimits(cfg),
+		},
+	}
+	// Without a network there is nothing to resolve.
+	if cfg.RunNetworkMode != "none" {
+		hostConfig.DNS = meta.DNS
+		hostConfig.ExtraHosts = meta.ExtraHosts
+	}
+	return hostConfig
+}
+
+// containerExited inspects the container and returns its exit code if it
+// has stopped running.
+func containerExited(ctx context.Context, cli *client.Client, containerID string) (int64, bool, error) {
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, false, fmt.Errorf("inspecting container: %w", err)
+	}
+	if inspect.State == nil || inspect.State.Running {
+		return 0, false, nil
+	}
+	return int64(inspect.State.ExitCode), true, nil
+}
+
+// signalNames names the signals a test process is commonly killed by.
+var signalNames = map[int64]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	4:  "SIGILL",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	13: "SIGPIPE",
+	15: "SIGTERM",
+	// A write past ULIMIT_FSIZE.
+	25: "SIGXFSZ",
+}
+
+// exitReason describes why a run's container stopped. The server's own
+// timeout and memory kills take precedence over the exit code they cause,
+// then the kernel's OOM killer, an error reported by the wait, and finally
+// the exit code, which the shell sets to 128 plus the signal number when the
+// process was killed by a signal.
+func exitReason(execution *Execution, oomKilled bool, waitError string) string {
+	switch {
+	case execution.TimedOut:
+		return "timed out"
+	case execution.MemoryExceeded:
+		return "killed nearing its memory limit"
+	case execution.ReportPolled:
+		return "killed after writing its report"
+	case oomKilled:
+		return "killed by the out-of-memory killer"
+	case waitError != "":
+		return "wait failed: " + waitError
+	case execution.ExitCode < 0:
+		return "unknown"
+	case execution.ExitCode > 128 && execution.ExitCode <= 128+64:
+		signal := execution.ExitCode - 128
+		if name, ok := signalNames[signal]; ok {
+			return fmt.Sprintf("killed by %s (signal %d)", name, signal)
+		}
+		return fmt.Sprintf("killed by signal %d", signal)
+	default:
+		return fmt.Sprintf("exited with code %d", execution.ExitCode)
+	}
+}
+
+// stopContainer sends the container its stop signal and gives it grace to
+// flush a partial report before the daemon kills it. If the stop itself fails the
+// container is killed outright.
+func stopContainer(ctx context.Context, cli *client.Client, containerID string, grace time.Duration) error {
+	seconds := int(grace.Seconds())
+	err := cli.ContainerStop(ctx, containerID, client.ContainerStopOptions{Timeout: &seconds})
+	if err == nil {
+		return nil
+	}
+
+	slog.Warn("stopping container failed, killing it", "container", containerID, "error", err)
+	if err := cli.ContainerKill(ctx, containerID, "SIGKILL"); err != nil {
+		return fmt.Errorf("killing container: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+func TestUlimits(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ULIMIT_NOFILE": "256", "ULIMIT_FSIZE": "1048576", "ULIMIT_NPROC": "0"})
+
+	got := map[string]container.Ulimit{}
+	for _, limit := range hostConfig(cfg, Metadata{}, nil).Ulimits {
+		got[limit.Name] = *limit
+	}
+	want := map[string]container.Ulimit{
+		"nofile": {Name: "nofile", Soft: 256, Hard: 256},
+		"fsize":  {Name: "fsize", Soft: 1 << 20, Hard: 1 << 20},
+	}
+	if len(got) != len(want) {
+		t.Errorf("ulimits are %v, want %v", got, want)
+	}
+	for name, limit := range want {
+		if got[name] != limit {
+			t.Errorf("%s ulimit is %+v, want %+v", name, got[name], limit)
+		}
+	}
+}
+
+// A test writing past ULIMIT_FSIZE is killed by SIGXFSZ before it can write
+// its report, which has to fail the run with a reason naming the signal.
+func TestRunImageOverFileSizeLimit(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.exitCode = 128 + 25
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, map[string]string{"ULIMIT_FSIZE": "1024"})
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	execution, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	if err == nil {
+		t.Fatal("run killed over its file size limit succeeded")
+	}
+	if want := "killed by SIGXFSZ (signal 25)"; execution.ExitReason != want {
+		t.Errorf("exit reason is %q, want %q", execution.ExitReason, want)
+	}
+
+	limits := docker.Container().HostConfig.Ulimits
+	if !containsUlimit(limits, container.Ulimit{Name: "fsize", Soft: 1024, Hard: 1024}) {
+		t.Errorf("container ulimits are %v, want fsize 1024", limits)
+	}
+}
+
+func containsUlimit(limits []*container.Ulimit, want container.Ulimit) bool {
+	for _, limit := range limits {
+		if *limit == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunImageAppliesDNS(t *testing.T) {
+	meta := Metadata{DNS: []string{"10.0.0.53"}, ExtraHosts: []string{"api.internal:10.0.0.8"}}
+	tests := []struct {
+		network string
+		applied bool
+	}{
+		{"none", false},
+		{"bridge", true},
+	}
+	for _, test := range tests {
+		t.Run(test.network, func(t *testing.T) {
+			docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+			docker.images["base"] = fakeImage(nil)
+			cfg := testConfig(t, map[string]string{"RUN_NETWORK_MODE": test.network})
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			if _, err := runImage(context.Background(), cfg, docker.client, req, meta, "base", &Execution{ExitCode: -1}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := docker.Container().HostConfig
+			if string(got.NetworkMode) != test.network {
+				t.Errorf("network mode is %q, want %q", got.NetworkMode, test.network)
+			}
+			if !test.applied {
+				if len(got.DNS) != 0 || len(got.ExtraHosts) != 0 {
+					t.Errorf("without a network got DNS %v and extra hosts %v, want none", got.DNS, got.ExtraHosts)
+				}
+				return
+			}
+			if !slices.Equal(got.DNS, meta.DNS) || !slices.Equal(got.ExtraHosts, meta.ExtraHosts) {
+				t.Errorf("got DNS %v and extra hosts %v, want %v and %v", got.DNS, got.ExtraHosts, meta.DNS, meta.ExtraHosts)
+			}
+		})
+	}
+}
+
+func TestExitReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		execution Execution
+		oomKilled bool
+		waitError string
+		want      string
+	}{
+		{"clean exit", Execution{ExitCode: 0}, false, "", "exited with code 0"},
+		{"non-zero exit", Execution{ExitCode: 1}, false, "", "exited with code 1"},
+		{"named signal", Execution{ExitCode: 128 + 9}, false, "", "killed by SIGKILL (signal 9)"},
+		{"unnamed signal", Execution{ExitCode: 128 + 40}, false, "", "killed by signal 40"},
+		{"past the signal range", Execution{ExitCode: 255}, false, "", "exited with code 255"},
+		{"no exit code", Execution{ExitCode: -1}, false, "", "unknown"},
+		{"out of memory", Execution{ExitCode: 128 + 9}, true, "", "killed by the out-of-memory killer"},
+		{"wait error", Execution{ExitCode: 1}, false, "container vanished", "wait failed: container vanished"},
+		{"timed out", Execution{ExitCode: 128 + 9, TimedOut: true}, true, "", "timed out"},
+		{"memory threshold", Execution{ExitCode: 128 + 9, MemoryExceeded: true}, true, "", "killed nearing its memory limit"},
+		{"report polled", Execution{ExitCode: 128 + 15, ReportPolled: true}, false, "", "killed after writing its report"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitReason(&tt.execution, tt.oomKilled, tt.waitError); got != tt.want {
+				t.Errorf("exitReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The reason comes from what the daemon reports: the wait's status code and
+// error, and the inspected container's OOMKilled.
+func TestRunImageExitReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		exitCode  int64
+		oomKilled bool
+		waitError string
+		want      string
+	}{
+		{"clean exit", 0, false, "", "exited with code 0"},
+		{"non-zero exit", 2, false, "", "exited with code 2"},
+		{"signal", 128 + 11, false, "", "killed by SIGSEGV (signal 11)"},
+		{"out of memory", 128 + 9, true, "", "killed by the out-of-memory killer"},
+		{"wait error", 1, false, "container removed", "wait failed: container removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+			docker.images["base"] = fakeImage(nil)
+			docker.exitCode = tt.exitCode
+			docker.oomKilled = tt.oomKilled
+			docker.waitError = tt.waitError
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			execution, _ := runImage(context.Background(), testConfig(t, nil), docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+			if execution.ExitReason != tt.want {
+				t.Errorf("exit reason is %q, want %q", execution.ExitReason, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunImageSetsStopSignal(t *testing.T) {
+	tests := []struct {
+		name string
+		meta Metadata
+		want string
+	}{
+		{"default", Metadata{}, "SIGTERM"},
+		{"configured", Metadata{StopSignal: "SIGINT"}, "SIGINT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+			docker.images["base"] = fakeImage(nil)
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			if _, err := runImage(context.Background(), testConfig(t, nil), docker.client, req, test.meta, "base", &Execution{ExitCode: -1}); err != nil {
+				t.Fatal(err)
+			}
+			if got := docker.Container().Config.StopSignal; got != test.want {
+				t.Errorf("stop signal is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLogOpts(t *testing.T) {
+	tests := []struct {
+		spec  string
+		want  map[string]string
+		valid bool
+	}{
+		{"", map[string]string{}, true},
+		{"max-size=10m,max-file=1", map[string]string{"max-size": "10m", "max-file": "1"}, true},
+		{" max-size=1m , ,tag=", map[string]string{"max-size": "1m", "tag": ""}, true},
+		{"labels=a=b", map[string]string{"labels": "a=b"}, true},
+		{"max-size", nil, false},
+		{"=10m", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			got, err := parseLogOpts(test.spec)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("parseLogOpts(%q) = %v, want an error", test.spec, got)
+				}
+				return
+			}
+			if err != nil || !maps.Equal(got, test.want) {
+				t.Errorf("parseLogOpts(%q) = %v, %v, want %v", test.spec, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestRunImageAppliesLogConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want container.LogConfig
+	}{
+		{"default", nil, container.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "10m", "max-file": "1"}}},
+		{"configured", map[string]string{"RUN_LOG_DRIVER": "local", "RUN_LOG_OPTS": "max-size=1m"}, container.LogConfig{Type: "local", Config: map[string]string{"max-size": "1m"}}},
+		{"json-file options aren't passed to another driver", map[string]string{"RUN_LOG_DRIVER": "none"}, container.LogConfig{Type: "none", Config: map[string]string{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+			docker.images["base"] = fakeImage(nil)
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			if _, err := runImage(context.Background(), testConfig(t, test.env), docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1}); err != nil {
+				t.Fatal(err)
+			}
+			got := docker.Container().HostConfig.LogConfig
+			if got.Type != test.want.Type || !maps.Equal(got.Config, test.want.Config) {
+				t.Errorf("log config is %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// withDeadline cancels a request's context once it has been handled for
+// REQUEST_DEADLINE, scaled by ?repeat like the write deadline, and responds
+// 503 with code "timeout" if the handler hasn't started its response by
+// then. Cancelling aborts any build or run the request started; their
+// cleanup runs on a context of its own. Event and NDJSON streams are exempt:
+// they end when the run does.
+func withDeadline(cfg *Config, next http.Handler) http.Handler {
+	if cfg.RequestDeadline == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if format := responseFormat(r); format == formatSSE || format == formatNDJSON {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		deadline := cfg.RequestDeadline * time.Duration(repeatCount(r, cfg.MaxRepeatRuns))
+		ctx, cancel := context.WithTimeout(r.Context(), deadline)
+		defer cancel()
+
+		dw := &deadlineWriter{w: w, header: http.Header{}}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(dw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case p := <-panicked:
+			panic(p)
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The client went away; there is no one to respond to.
+				dw.abandon()
+				return
+			}
+			if dw.abandon() {
+				slog.Warn("request exceeded its deadline", "request_id", requestID(r.Context()), "deadline", deadline)
+				writeError(w, r, http.StatusServiceUnavailable, codeTimeout, fmt.Sprintf("request took longer than %s", deadline))
+			}
+		}
+	})
+}
+
+// deadlineWriter passes a handler's response through until withDeadline
+// abandons it, after which writes fail with http.ErrHandlerTimeout. Headers
+// are kept apart from the underlying writer's until the response starts, so
+// a late handler can't race the 503.
+type deadlineWriter struct {
+	mu        sync.Mutex
+	w         http.ResponseWriter
+	header    http.Header
+	started   bool
+	abandoned bool
+}
+
+func (d *deadlineWriter) Header() http.Header {
+	return d.header
+}
+
+// start copies the handler's headers out once its response begins.
+func (d *deadlineWriter) start() {
+	if d.started {
+		return
+	}
+	d.started = true
+	for name, values := range d.header {
+		d.w.Header()[name] = values
+	}
+}
+
+func (d *deadlineWriter) WriteHeader(status int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.abandoned || d.started {
+		return
+	}
+	d.start()
+	d.w.WriteHeader(status)
+}
+
+func (d *deadlineWriter) Write(data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.abandoned {
+		return 0, http.ErrHandlerTimeout
+	}
+	d.start()
+	return d.w.Write(data)
+}
+
+func (d *deadlineWriter) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if flusher, ok := d.w.(http.Flusher); ok && !d.abandoned {
+		d.start()
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, to move its
+// write deadline.
+func (d *deadlineWriter) Unwrap() http.ResponseWriter {
+	return d.w
+}
+
+// abandon stops the handler writing any more of its response, reporting
+// whether it had yet to start one.
+func (d *deadlineWriter) abandon() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.abandoned = true
+	return !d.started
+}
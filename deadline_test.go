@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func deadlineConfig(t *testing.T) *Config {
+	t.Helper()
+	return testConfig(t, map[string]string{"REQUEST_DEADLINE": "50ms", "RUN_TIMEOUT": "10ms"})
+}
+
+func TestWithDeadlineOnTime(t *testing.T) {
+	handler := withDeadline(deadlineConfig(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("handler's context has no deadline")
+		}
+		w.Header().Set("X-Handler", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusCreated || w.Body.String() != "done" || w.Header().Get("X-Handler") != "yes" {
+		t.Errorf("response is %d %q with headers %v, want the handler's", w.Code, w.Body, w.Header())
+	}
+}
+
+// A handler still working at the deadline has its context cancelled, and
+// the client gets a 503 instead of anything the handler writes afterwards.
+func TestWithDeadlineExceeded(t *testing.T) {
+	served := make(chan struct{})
+	late := make(chan error, 1)
+	handler := withDeadline(deadlineConfig(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Write only once withDeadline has given up on the handler.
+		<-served
+		if r.Context().Err() == nil {
+			t.Error("handler's context wasn't cancelled at the deadline")
+		}
+		w.Header().Set("X-Handler", "late")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte("late result"))
+		late <- err
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	close(served)
+	assertAPIError(t, w, http.StatusServiceUnavailable, codeTimeout)
+
+	if err := <-late; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("late write returned %v, want http.ErrHandlerTimeout", err)
+	}
+	if strings.Contains(w.Body.String(), "late result") || w.Header().Get("X-Handler") != "" {
+		t.Errorf("late response leaked into %q with headers %v", w.Body, w.Header())
+	}
+}
+
+// Once the handler has started its response it keeps it; only what it
+// writes after the deadline is dropped.
+func TestWithDeadlineExceededAfterResponseStarted(t *testing.T) {
+	served := make(chan struct{})
+	late := make(chan error, 1)
+	handler := withDeadline(deadlineConfig(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		<-served
+		_, err := w.Write([]byte(" and late"))
+		late <- err
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	close(served)
+	if err := <-late; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("late write returned %v, want http.ErrHandlerTimeout", err)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("response is %d %q, want 200 \"partial\"", w.Code, w.Body)
+	}
+}
+
+func TestWithDeadlineClientGone(t *testing.T) {
+	handled := make(chan struct{})
+	handler := withDeadline(deadlineConfig(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(handled)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	<-handled
+	if w.Body.Len() != 0 {
+		t.Errorf("responded %q to a client that went away", w.Body)
+	}
+}
+
+func TestWithDeadlineExemptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		target string
+	}{
+		{"event stream", map[string]string{"REQUEST_DEADLINE": "50ms", "RUN_TIMEOUT": "10ms"}, "/?format=sse"},
+		{"ndjson stream", map[string]string{"REQUEST_DEADLINE": "50ms", "RUN_TIMEOUT": "10ms"}, "/?format=ndjson"},
+		{"disabled", map[string]string{"REQUEST_DEADLINE": "0"}, "/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := withDeadline(testConfig(t, test.env), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := r.Context().Deadline(); ok {
+					t.Error("exempt request has a deadline")
+				}
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.target, nil))
+		})
+	}
+}
+
+// ?repeat runs the submission several times, so it gets as many deadlines.
+func TestWithDeadlineScalesWithRepeat(t *testing.T) {
+	var remaining time.Duration
+	handler := withDeadline(deadlineConfig(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ := r.Context().Deadline()
+		remaining = time.Until(deadline)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?repeat=3", nil))
+	if remaining <= 100*time.Millisecond || remaining > 150*time.Millisecond {
+		t.Errorf("deadline is %s away, want about 150ms", remaining)
+	}
+}
+
+func TestWithDeadlinePanics(t *testing.T) {
+	handler := withDeadline(deadlineConfig(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+	defer func() {
+		if p := recover(); p != "handler failed" {
+			t.Errorf("recovered %v, want the handler's panic", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
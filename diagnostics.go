@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a TypeScript compiler error or warning found in a build log,
+// or in the stderr of a run whose test command type-checked the submission.
+type Diagnostic struct {
+	// File is relative to the working dir when it lies inside it.
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Severity is "error" or "warning".
+	Severity string `json:"severity"`
+}
+
+// buildFailure is a build that ran but failed, with the diagnostics found in
+// its output.
+type buildFailure struct {
+	message     string
+	diagnostics []Diagnostic
+}
+
+func (f *buildFailure) Error() string {
+	return errBuildFailed.Error() + ": " + f.message
+}
+
+func (f *buildFailure) Unwrap() error {
+	return errBuildFailed
+}
+
+var errTypeCheckFailed = errors.New("type check failed")
+
+// checkFailure is a run that ended without a usable report because its test
+// command, such as deno test, rejected the code when type-checking it.
+type checkFailure struct {
+	err         error
+	diagnostics []Diagnostic
+}
+
+func (f *checkFailure) Error() string {
+	return errTypeCheckFailed.Error() + ": " + f.diagnostics[0].Message + ": " + f.err.Error()
+}
+
+func (f *checkFailure) Unwrap() error {
+	return errTypeCheckFailed
+}
+
+var (
+	// tsc prints file(line,col): error TS1234: message, or file:line:col -
+	// error TS1234: message with --pretty.
+	tscDiagnostic       = regexp.MustCompile(`^(\S[^(]*)\((\d+),(\d+)\): (error|warning) (TS\d+): (.*)$`)
+	tscPrettyDiagnostic = regexp.MustCompile(`^(\S+):(\d+):(\d+) - (error|warning) (TS\d+): (.*)$`)
+	// Deno prints TS1234 [ERROR]: message, with the location on a later
+	// "at file:///path:line:col" line.
+	denoDiagnostic = regexp.MustCompile(`^(?:error: )?(TS\d+) \[(ERROR|WARNING)\]: (.*)$`)
+	denoLocation   = regexp.MustCompile(`^\s+at (?:file://)?(\S+):(\d+):(\d+)$`)
+)
+
+// parseDiagnostics extracts compiler diagnostics from build output, skipping
+// every line that isn't part of one.
+func parseDiagnostics(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	var pending *Diagnostic
+	flush := func() {
+		if pending != nil {
+			diagnostics = append(diagnostics, *pending)
+			pending = nil
+		}
+	}
+
+	for _, line := range strings.Split(ansiEscape.ReplaceAllString(output, ""), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if match := denoDiagnostic.FindStringSubmatch(line); match != nil {
+			flush()
+			pending = &Diagnostic{Code: match[1], Severity: strings.ToLower(match[2]), Message: match[3]}
+			continue
+		}
+		if match := denoLocation.FindStringSubmatch(line); match != nil && pending != nil {
+			pending.File, pending.Line, pending.Column = diagnosticLocation(match[1], match[2], match[3])
+			flush()
+			continue
+		}
+
+		match := tscDiagnostic.FindStringSubmatch(line)
+		if match == nil {
+			match = tscPrettyDiagnostic.FindStringSubmatch(line)
+		}
+		if match != nil {
+			flush()
+			file, lineNumber, column := diagnosticLocation(match[1], match[2], match[3])
+			diagnostics = append(diagnostics, Diagnostic{
+				File: file, Line: lineNumber, Column: column,
+				Code: match[5], Severity: match[4], Message: match[6],
+			})
+		}
+	}
+	flush()
+
+	return diagnostics
+}
+
+// errorDiagnostics returns the errors among the diagnostics in output,
+// leaving out warnings, which don't stop a run.
+func errorDiagnostics(output string) []Diagnostic {
+	errs := []Diagnostic{}
+	for _, diagnostic := range parseDiagnostics(output) {
+		if diagnostic.Severity == "error" {
+			errs = append(errs, diagnostic)
+		}
+	}
+	return errs
+}
+
+func diagnosticLocation(file string, line string, column string) (string, int, int) {
+	lineNumber, _ := strconv.Atoi(line)
+	columnNumber, _ := strconv.Atoi(column)
+	return strings.TrimPrefix(file, defaultWorkingDir+"/"), lineNumber, columnNumber
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	cerrdefs "github.com/containerd/errdefs"
+)
+
+// denoCheckStderr is what deno test prints when the submission doesn't
+// type-check, colours included.
+const denoCheckStderr = "\x1b[0m\x1b[32mCheck\x1b[0m file:///test/test.ts\n" +
+	"\x1b[0m\x1b[1mTS2322 \x1b[0m[\x1b[0m\x1b[1m\x1b[31mERROR\x1b[0m]: Type 'string' is not assignable to type 'number'.\n" +
+	"  return \"3\";\n" +
+	"  ~~~~~~\n" +
+	"    at \x1b[0m\x1b[36mfile:///test/code.ts\x1b[0m:\x1b[0m\x1b[33m2\x1b[0m:\x1b[0m\x1b[33m3\x1b[0m\n" +
+	"\n" +
+	"TS2554 [ERROR]: Expected 2 arguments, but got 1.\n" +
+	"  assertEquals(sum(1), 3);\n" +
+	"               ~~~~~~\n" +
+	"    at file:///test/test.ts:5:16\n" +
+	"\n" +
+	"    An argument for 'b' was not provided.\n" +
+	"      export function sum(a: number, b: number): number {\n" +
+	"                                     ~~~~~~~~~\n" +
+	"        at file:///test/code.ts:1:32\n" +
+	"\n" +
+	"TS6133 [WARNING]: 'unused' is declared but its value is never read.\n" +
+	"    at file:///test/code.ts:4:7\n" +
+	"\n" +
+	"Found 2 errors.\n" +
+	"\n" +
+	"error: Type checking failed.\n"
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Diagnostic
+	}{
+		{"deno test stderr", denoCheckStderr, []Diagnostic{
+			{File: "code.ts", Line: 2, Column: 3, Code: "TS2322", Severity: "error", Message: "Type 'string' is not assignable to type 'number'."},
+			{File: "test.ts", Line: 5, Column: 16, Code: "TS2554", Severity: "error", Message: "Expected 2 arguments, but got 1."},
+			{File: "code.ts", Line: 4, Column: 7, Code: "TS6133", Severity: "warning", Message: "'unused' is declared but its value is never read."},
+		}},
+		{"older deno", "error: TS2304 [ERROR]: Cannot find name 'sum'.\n    at file:///test/test.ts:3:1\n", []Diagnostic{
+			{File: "test.ts", Line: 3, Column: 1, Code: "TS2304", Severity: "error", Message: "Cannot find name 'sum'."},
+		}},
+		{"tsc plain", "code.ts(1,14): error TS2322: Type 'string' is not assignable to type 'number'.\n", []Diagnostic{
+			{File: "code.ts", Line: 1, Column: 14, Code: "TS2322", Severity: "error", Message: "Type 'string' is not assignable to type 'number'."},
+		}},
+		{"tsc pretty", "/test/code.ts:1:14 - error TS2322: Type 'string' is not assignable to type 'number'.\n", []Diagnostic{
+			{File: "code.ts", Line: 1, Column: 14, Code: "TS2322", Severity: "error", Message: "Type 'string' is not assignable to type 'number'."},
+		}},
+		{"deno without a location", "TS1005 [ERROR]: ';' expected.\n", []Diagnostic{
+			{Code: "TS1005", Severity: "error", Message: "';' expected."},
+		}},
+		{"runtime error only", "error: Uncaught (in promise) TypeError: boom\n    at file:///test/code.ts:1:7\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDiagnostics(tt.output)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("diagnostic %d is %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// In the default mode nothing is built from the submission, so its type
+// errors only show up in the stderr of deno test, which exits before
+// writing a report.
+func TestRunImageTypeCheckFailure(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.exitCode = 1
+	docker.stderr = denoCheckStderr
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, nil)
+
+	req := RunRequest{Task: "sum", User: "alice", Code: `export function sum(a: number, b: number): number { return "3" }`}
+	_, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	if !errors.Is(err, errTypeCheckFailed) {
+		t.Fatalf("run that failed its type check returned %v, want errTypeCheckFailed", err)
+	}
+
+	status, body := runErrorBody(err)
+	if status != http.StatusUnprocessableEntity || body.Code != codeTypeCheckFailed {
+		t.Errorf("got %d %s, want 422 %s", status, body.Code, codeTypeCheckFailed)
+	}
+	if len(body.Diagnostics) != 2 || body.Diagnostics[0].Code != "TS2322" || body.Diagnostics[1].Code != "TS2554" {
+		t.Errorf("error diagnostics are %+v, want TS2322 and TS2554", body.Diagnostics)
+	}
+}
+
+func TestCheckFailureKeepsCause(t *testing.T) {
+	missing := cerrdefs.ErrNotFound
+	failure := &checkFailure{err: missing, diagnostics: []Diagnostic{{Code: "TS2322", Message: "Type 'string' is not assignable to type 'number'."}}}
+	if want := "type check failed: Type 'string' is not assignable to type 'number'.: not found"; failure.Error() != want {
+		t.Errorf("message is %q, want %q", failure.Error(), want)
+	}
+}
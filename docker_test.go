@@ -0,0 +1,570 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/client"
+)
+
+// fakeDocker serves just enough of the Docker Engine API for the run
+// pipeline, so it can be tested without a daemon. Every container it creates
+// runs for runFor and then exits with exitCode, unless it is killed or
+// stopped first. The fields above mu configure it and must be set before the
+// first request.
+type fakeDocker struct {
+	t      *testing.T
+	server *httptest.Server
+	client *client.Client
+
+	exitCode int64
+	runFor   time.Duration
+	// hangWait makes the wait never answer, as a stuck daemon's might.
+	hangWait   bool
+	waitStatus int
+	waitError  string
+	oomKilled  bool
+	stdout     string
+	stderr     string
+	stats      []container.StatsResponse
+	// files are in every container from the start, by absolute path,
+	// typically the report the runner would write.
+	files        map[string][]byte
+	copyInStatus int
+	createStatus int
+	buildOutput  []string
+	buildError   string
+	buildNoImage bool
+	images       map[string]image.InspectResponse
+	onStart      func(*fakeContainer)
+	// startFailures is how many container starts fail, as a daemon having
+	// a bad moment would, before they succeed.
+	startFailures  int
+	truncateReport bool
+
+	mu         sync.Mutex
+	containers []*fakeContainer
+	builds     []fakeBuild
+	calls      []string
+}
+
+type fakeContainer struct {
+	ID         string
+	Config     container.Config
+	HostConfig container.HostConfig
+	// Copied holds the files copied in, by absolute path.
+	Copied  map[string][]byte
+	Started time.Time
+	Signals []string
+	Stopped bool
+	Removed bool
+
+	done     chan struct{}
+	finished bool
+	exitCode int64
+}
+
+type fakeBuild struct {
+	Tags      []string
+	NoCache   bool
+	Target    string
+	BuildArgs map[string]*string
+	Labels    map[string]string
+	// Files is the build context, by name.
+	Files map[string][]byte
+}
+
+func newFakeDocker(t *testing.T) *fakeDocker {
+	t.Helper()
+
+	d := &fakeDocker{t: t, runFor: 10 * time.Millisecond, files: map[string][]byte{}, images: map[string]image.InspectResponse{}}
+	d.server = httptest.NewServer(http.HandlerFunc(d.serve))
+	t.Cleanup(d.server.Close)
+
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+d.server.Listener.Addr().String()),
+		client.WithHTTPClient(d.server.Client()),
+		client.WithVersion("1.47"),
+	)
+	if err != nil {
+		t.Fatalf("opening fake client: %v", err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	d.client = cli
+	return d
+}
+
+// withReport puts a report at the task's report path in every container.
+func (d *fakeDocker) withReport(report string) *fakeDocker {
+	d.files[path.Join(defaultWorkingDir, "report.xml")] = []byte(report)
+	return d
+}
+
+// withImage makes the task's base image exist, fresh, for tasks that don't
+// rebuild.
+func (d *fakeDocker) withImage(cfg *Config, task string) *fakeDocker {
+	d.t.Helper()
+
+	meta, err := loadMetadata(task)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	baseCode, err := files.ReadFile(path.Join("tests", task, "code.ts"))
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	memFS, err := createFS(task, string(baseCode))
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	d.images[baseImageName(cfg, task)] = fakeImage(map[string]string{contentLabel: contextDigest(memFS, meta)})
+	return d
+}
+
+func fakeImage(labels map[string]string) image.InspectResponse {
+	// Going through JSON spares naming the image spec's config type.
+	config, _ := json.Marshal(map[string]any{"Config": map[string]any{"Labels": labels}})
+	inspect := image.InspectResponse{}
+	json.Unmarshal(config, &inspect)
+	inspect.ID = "sha256:fake"
+	inspect.Created = time.Now().UTC().Format(time.RFC3339Nano)
+	return inspect
+}
+
+// Containers returns the containers created so far.
+func (d *fakeDocker) Containers() []*fakeContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.containers)
+}
+
+// Container returns the only container created, failing the test if there
+// isn't exactly one.
+func (d *fakeDocker) Container() *fakeContainer {
+	d.t.Helper()
+
+	containers := d.Containers()
+	if len(containers) != 1 {
+		d.t.Fatalf("%d containers were created, want 1", len(containers))
+	}
+	return containers[0]
+}
+
+// Builds returns the image builds so far.
+func (d *fakeDocker) Builds() []fakeBuild {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.builds)
+}
+
+// Called reports whether any request's method and path, without the API
+// version, start with prefix, such as "POST /containers/c1/kill".
+func (d *fakeDocker) Called(prefix string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, call := range d.calls {
+		if strings.HasPrefix(call, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func (d *fakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	p := apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	d.mu.Lock()
+	d.calls = append(d.calls, r.Method+" "+p)
+	d.mu.Unlock()
+
+	switch {
+	case p == "/_ping":
+		w.Header().Set("API-Version", "1.47")
+		io.WriteString(w, "OK")
+	case r.Method == http.MethodPost && p == "/containers/create":
+		d.create(w, r)
+	case r.Method == http.MethodPost && p == "/build":
+		d.build(w, r)
+	case strings.HasPrefix(p, "/images/") && strings.HasSuffix(p, "/json"):
+		d.inspectImage(w, strings.TrimSuffix(strings.TrimPrefix(p, "/images/"), "/json"))
+	case strings.HasPrefix(p, "/containers/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(p, "/containers/"), "/")
+		c := d.container(id)
+		if c == nil {
+			fakeError(w, http.StatusNotFound, "No such container: "+id)
+			return
+		}
+		d.serveContainer(w, r, c, r.Method+" "+action)
+	default:
+		fakeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+func fakeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func (d *fakeDocker) container(id string) *fakeContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, c := range d.containers {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *fakeDocker) create(w http.ResponseWriter, r *http.Request) {
+	if d.createStatus != 0 {
+		fakeError(w, d.createStatus, "can't create container")
+		return
+	}
+
+	request := container.CreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		fakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := d.images[request.Image]; !ok {
+		d.mu.Lock()
+		built := slices.ContainsFunc(d.builds, func(b fakeBuild) bool { return slices.Contains(b.Tags, request.Image) })
+		d.mu.Unlock()
+		if !built || d.buildNoImage {
+			fakeError(w, http.StatusNotFound, "No such image: "+request.Image)
+			return
+		}
+	}
+
+	d.mu.Lock()
+	c := &fakeContainer{ID: fmt.Sprintf("c%d", len(d.containers)+1), Copied: map[string][]byte{}, done: make(chan struct{}), exitCode: d.exitCode}
+	if request.Config != nil {
+		c.Config = *request.Config
+	}
+	if request.HostConfig != nil {
+		c.HostConfig = *request.HostConfig
+	}
+	d.containers = append(d.containers, c)
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(container.CreateResponse{ID: c.ID, Warnings: []string{}})
+}
+
+// finish ends the container's run with exitCode, unless it has ended
+// already, and writes its files to any bind mounts, as a runner writing
+// its report into a mounted directory would. The caller holds d.mu.
+func (d *fakeDocker) finish(c *fakeContainer, exitCode int64) {
+	if c.finished {
+		return
+	}
+	c.finished = true
+	c.exitCode = exitCode
+	close(c.done)
+
+	for _, m := range c.HostConfig.Mounts {
+		for name, data := range d.files {
+			target := ""
+			if name == m.Target {
+				target = m.Source
+			} else if rel, ok := strings.CutPrefix(name, strings.TrimSuffix(m.Target, "/")+"/"); ok {
+				target = filepath.Join(m.Source, rel)
+			} else {
+				continue
+			}
+			os.MkdirAll(filepath.Dir(target), 0777)
+			if err := os.WriteFile(target, data, 0666); err != nil {
+				d.t.Errorf("writing %s to its mount: %v", name, err)
+			}
+		}
+	}
+}
+
+// state returns whether the container is still running and its exit code.
+func (d *fakeDocker) state(c *fakeContainer) (bool, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !c.finished && !c.Started.IsZero() && time.Since(c.Started) >= d.runFor {
+		d.finish(c, d.exitCode)
+	}
+	return !c.Started.IsZero() && !c.finished, c.exitCode
+}
+
+func (d *fakeDocker) serveContainer(w http.ResponseWriter, r *http.Request, c *fakeContainer, action string) {
+	switch action {
+	case "PUT archive":
+		d.copyIn(w, r, c)
+	case "GET archive":
+		d.copyOut(w, r, c)
+	case "POST start":
+		d.mu.Lock()
+		if d.startFailures > 0 {
+			d.startFailures--
+			d.mu.Unlock()
+			fakeError(w, http.StatusInternalServerError, "failed to create task for container")
+			return
+		}
+		c.Started = time.Now()
+		d.mu.Unlock()
+		if d.onStart != nil {
+			d.onStart(c)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "POST wait":
+		d.wait(w, r, c)
+	case "GET json":
+		running, exitCode := d.state(c)
+		status := container.StateExited
+		if running {
+			status = container.StateRunning
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(container.InspectResponse{
+			ID:     c.ID,
+			State:  &container.State{Status: status, Running: running, ExitCode: int(exitCode), OOMKilled: d.oomKilled},
+			Config: &c.Config,
+		})
+	case "POST kill":
+		d.mu.Lock()
+		c.Signals = append(c.Signals, r.URL.Query().Get("signal"))
+		d.finish(c, 137)
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case "POST stop":
+		d.mu.Lock()
+		c.Stopped = true
+		d.finish(c, 143)
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE ":
+		d.mu.Lock()
+		c.Removed = true
+		d.finish(c, c.exitCode)
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case "GET logs":
+		d.logs(w, r, c)
+	case "GET stats":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Ostype", "linux")
+		encoder := json.NewEncoder(w)
+		for _, sample := range d.stats {
+			encoder.Encode(sample)
+			w.(http.Flusher).Flush()
+		}
+		if r.URL.Query().Get("stream") == "1" {
+			select {
+			case <-c.done:
+			case <-r.Context().Done():
+			}
+		}
+	default:
+		fakeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+func (d *fakeDocker) wait(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	if d.waitStatus != 0 {
+		fakeError(w, d.waitStatus, "wait failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	if d.hangWait {
+		<-r.Context().Done()
+		return
+	}
+
+	d.mu.Lock()
+	remaining := d.runFor - time.Since(c.Started)
+	d.mu.Unlock()
+	select {
+	case <-time.After(remaining):
+		d.mu.Lock()
+		d.finish(c, d.exitCode)
+		d.mu.Unlock()
+	case <-c.done:
+	case <-r.Context().Done():
+		return
+	}
+
+	d.mu.Lock()
+	response := container.WaitResponse{StatusCode: c.exitCode}
+	d.mu.Unlock()
+	if d.waitError != "" {
+		response.Error = &container.WaitExitError{Message: d.waitError}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (d *fakeDocker) logs(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+	w.WriteHeader(http.StatusOK)
+	if query.Get("stdout") == "1" && d.stdout != "" {
+		stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(d.stdout))
+	}
+	if query.Get("stderr") == "1" && d.stderr != "" {
+		stdcopy.NewStdWriter(w, stdcopy.Stderr).Write([]byte(d.stderr))
+	}
+	w.(http.Flusher).Flush()
+	if query.Get("follow") == "1" {
+		select {
+		case <-c.done:
+		case <-r.Context().Done():
+		}
+	}
+}
+
+func (d *fakeDocker) copyIn(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	if d.copyInStatus != 0 {
+		fakeError(w, d.copyInStatus, "can't copy into container")
+		return
+	}
+
+	dir := r.URL.Query().Get("path")
+	reader := tar.NewReader(r.Body)
+	for {
+		hdr, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		data, _ := io.ReadAll(reader)
+		d.mu.Lock()
+		c.Copied[path.Join(dir, hdr.Name)] = data
+		d.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyOut serves a file, or a directory's files, from the container's files
+// as a tar stream. With truncateReport the stream ends before the file does.
+func (d *fakeDocker) copyOut(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	wanted := path.Clean(r.URL.Query().Get("path"))
+	entries := map[string][]byte{}
+	d.mu.Lock()
+	for name, data := range d.files {
+		if name == wanted {
+			entries[path.Base(name)] = data
+		} else if rel, ok := strings.CutPrefix(name, wanted+"/"); ok {
+			entries[path.Join(path.Base(wanted), rel)] = data
+		}
+	}
+	d.mu.Unlock()
+	if len(entries) == 0 {
+		fakeError(w, http.StatusNotFound, "Could not find the file "+wanted+" in container "+c.ID)
+		return
+	}
+
+	archive := bytes.Buffer{}
+	writer := tar.NewWriter(&archive)
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(entries[name])), Typeflag: tar.TypeReg})
+		writer.Write(entries[name])
+	}
+	writer.Close()
+	body := archive.Bytes()
+	if d.truncateReport {
+		body = body[:512+len(body[512:])/4]
+	}
+
+	stat, _ := json.Marshal(container.PathStat{Name: path.Base(wanted), Mode: 0644})
+	w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(stat))
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Write(body)
+}
+
+func (d *fakeDocker) build(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	build := fakeBuild{Tags: query["t"], NoCache: query.Get("nocache") == "1", Target: query.Get("target"), Files: map[string][]byte{}}
+	json.Unmarshal([]byte(query.Get("buildargs")), &build.BuildArgs)
+	json.Unmarshal([]byte(query.Get("labels")), &build.Labels)
+
+	reader := tar.NewReader(r.Body)
+	for {
+		hdr, err := reader.Next()
+		if err != nil {
+			break
+		}
+		data, _ := io.ReadAll(reader)
+		build.Files[hdr.Name] = data
+	}
+
+	d.mu.Lock()
+	d.builds = append(d.builds, build)
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	output := d.buildOutput
+	if output == nil {
+		output = []string{"Step 1/2 : FROM denoland/deno\n", " ---> Using cache\n", "Step 2/2 : COPY code.ts .\n", " ---> 1234\n"}
+	}
+	for _, line := range output {
+		encoder.Encode(buildMessage{Stream: line})
+	}
+	if d.buildError != "" {
+		encoder.Encode(buildMessage{Error: d.buildError})
+		return
+	}
+	if !d.buildNoImage {
+		d.mu.Lock()
+		for _, tag := range build.Tags {
+			d.images[tag] = fakeImage(build.Labels)
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *fakeDocker) inspectImage(w http.ResponseWriter, name string) {
+	d.mu.Lock()
+	inspect, ok := d.images[name]
+	d.mu.Unlock()
+	if !ok {
+		fakeError(w, http.StatusNotFound, "No such image: "+name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inspect)
+}
+
+// junitReport wraps testcase elements in a one-suite JUnit report.
+func junitReport(cases ...string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?><testsuites><testsuite name="test.ts">` + strings.Join(cases, "") + `</testsuite></testsuites>`
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/moby/moby/api/types/versions"
+	"github.com/moby/moby/client"
+)
+
+var errUnsupportedAPI = errors.New("unsupported by the Docker daemon")
+
+// apiFeature is a daemon feature the server relies on, with the first API
+// version that supports it.
+type apiFeature struct {
+	name       string
+	minVersion string
+	// required features are needed by every run; the server won't start
+	// without them.
+	required bool
+}
+
+var (
+	featureWaitCondition = apiFeature{name: "waiting for containers to stop", minVersion: "1.30", required: true}
+	featureBuildNetwork  = apiFeature{name: "build network modes", minVersion: "1.25"}
+	featureBuildTarget   = apiFeature{name: "build targets", minVersion: "1.29"}
+
+	apiFeatures = []apiFeature{featureWaitCondition, featureBuildNetwork, featureBuildTarget}
+)
+
+// dockerAPIVersion is the API version negotiated with the daemon at startup.
+var dockerAPIVersion string
+
+// checkDockerAPI negotiates the API version with the daemon and reports
+// which features it lacks, failing if it lacks one every run needs. An
+// unreachable daemon is only logged; /readyz reports it until it's back.
+func checkDockerAPI(ctx context.Context, cli *client.Client) error {
+	if _, err := cli.Ping(ctx); err != nil {
+		slog.Warn("can't check Docker API version", "error", err)
+		return nil
+	}
+	cli.NegotiateAPIVersion(ctx)
+	dockerAPIVersion = cli.ClientVersion()
+	slog.Info("using Docker API", "version", dockerAPIVersion)
+
+	for _, feature := range apiFeatures {
+		err := requireAPI(feature)
+		if err == nil {
+			continue
+		}
+		if feature.required {
+			return err
+		}
+		slog.Warn("Docker API feature disabled", "error", err)
+	}
+
+	return nil
+}
+
+// requireAPI fails with errUnsupportedAPI when the negotiated API version is
+// too old for feature.
+func requireAPI(feature apiFeature) error {
+	if dockerAPIVersion == "" || !versions.LessThan(dockerAPIVersion, feature.minVersion) {
+		return nil
+	}
+	return fmt.Errorf("%s need Docker API %s but the daemon speaks %s: %w", feature.name, feature.minVersion, dockerAPIVersion, errUnsupportedAPI)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// taskDockerfile returns the Dockerfile a task's images are built from, as
+// packaged in fsys. Every task currently builds from the shared
+// image/Dockerfile.
+func taskDockerfile(fsys fs.FS, task string) ([]byte, error) {
+	dockerfile, err := fs.ReadFile(fsys, "image/Dockerfile")
+	if err != nil {
+		return nil, fmt.Errorf("reading Dockerfile for %s: %w", task, err)
+	}
+	return dockerfile, nil
+}
+
+var dockerfileVariable = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// resolveDockerfile substitutes the values of declared ARGs into the lines
+// after them: the task's build arg when it sets one, or else the ARG's
+// default. Other variables are left as written.
+func resolveDockerfile(dockerfile []byte, meta Metadata) string {
+	args := map[string]string{}
+	lines := strings.Split(string(dockerfile), "\n")
+
+	for i, line := range lines {
+		lines[i] = dockerfileVariable.ReplaceAllStringFunc(line, func(reference string) string {
+			match := dockerfileVariable.FindStringSubmatch(reference)
+			name := match[1] + match[2]
+			if value, ok := args[name]; ok {
+				return value
+			}
+			return reference
+		})
+
+		fields := strings.Fields(lines[i])
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "ARG") {
+			continue
+		}
+		for _, declaration := range fields[1:] {
+			name, value, _ := strings.Cut(declaration, "=")
+			if override, ok := meta.BuildArgs[name]; ok {
+				value = override
+			}
+			args[name] = strings.Trim(value, `"'`)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// dockerfileHandler serves GET /admin/test/{test}/dockerfile, the Dockerfile
+// the task's builds use with its build args filled in.
+func dockerfileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		test := r.PathValue("test")
+		if !taskExists(test) {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't find test "+test)
+			return
+		}
+
+		meta, err := loadMetadata(test)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		dockerfile, err := taskDockerfile(files, test)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(resolveDockerfile(dockerfile, meta)))
+	}
+}
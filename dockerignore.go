@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"testing/fstest"
+)
+
+// ignoreRule is one line of a .dockerignore file.
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+// ignoreRules excludes files from build contexts. As with Docker, the last
+// rule matching a file decides, a rule matching a directory covers
+// everything under it, and "!" re-includes what earlier rules excluded.
+type ignoreRules []ignoreRule
+
+// contextIgnore holds the rules of the packaged image/.dockerignore.
+var contextIgnore ignoreRules
+
+// loadContextIgnore reads the packaged image/.dockerignore, if there is one.
+func loadContextIgnore() error {
+	data, err := files.ReadFile("image/.dockerignore")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading .dockerignore: %w", err)
+	}
+
+	rules, err := parseDockerignore(string(data))
+	if err != nil {
+		return fmt.Errorf("parsing .dockerignore: %w", err)
+	}
+	contextIgnore = rules
+	return nil
+}
+
+func parseDockerignore(data string) (ignoreRules, error) {
+	rules := ignoreRules{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		if pattern, ok := strings.CutPrefix(line, "!"); ok {
+			rule.negate = true
+			line = strings.TrimSpace(pattern)
+		}
+		line = strings.TrimPrefix(path.Clean("/"+line), "/")
+		if line == "" {
+			continue
+		}
+
+		pattern, err := regexp.Compile("^" + globPattern(line) + "(/.*)?$")
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", line, err)
+		}
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// globPattern translates a .dockerignore glob into a regular expression:
+// "**" matches any number of directories, "*" and "?" stay within one.
+func globPattern(glob string) string {
+	pattern := strings.Builder{}
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			pattern.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			pattern.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			pattern.WriteString("[^/]*")
+		case glob[i] == '?':
+			pattern.WriteString("[^/]")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return pattern.String()
+}
+
+func (rules ignoreRules) excluded(name string) bool {
+	excluded := false
+	for _, rule := range rules {
+		if rule.pattern.MatchString(name) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// filter returns the files of memFS the rules keep. The Dockerfile is
+// always kept, since the build can't run without it.
+func (rules ignoreRules) filter(memFS fstest.MapFS) fstest.MapFS {
+	if len(rules) == 0 {
+		return memFS
+	}
+
+	kept := fstest.MapFS{}
+	for name, file := range memFS {
+		if name == "Dockerfile" || !rules.excluded(name) {
+			kept[name] = file
+		}
+	}
+	return kept
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDockerignoreExcluded(t *testing.T) {
+	rules, err := parseDockerignore(`
+# editor and VCS files
+.git
+**/*.swp
+node_modules
+docs/*.md
+!docs/keep.md
+/fixtures/?.json
+
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		excluded bool
+	}{
+		{"code.ts", false},
+		{".git", true},
+		{".git/HEAD", true},
+		{"code.ts.swp", true},
+		{"lib/deep/code.ts.swp", true},
+		{"node_modules/left-pad/index.js", true},
+		{"lib/node_modules/x.js", false},
+		{"docs/README.md", true},
+		{"docs/keep.md", false},
+		{"docs/nested/README.md", false},
+		{"fixtures/a.json", true},
+		{"fixtures/ab.json", false},
+	}
+	for _, tt := range tests {
+		if got := rules.excluded(tt.name); got != tt.excluded {
+			t.Errorf("excluded(%q) = %v, want %v", tt.name, got, tt.excluded)
+		}
+	}
+}
+
+func TestDockerignoreFilterKeepsDockerfile(t *testing.T) {
+	rules, err := parseDockerignore("*\n!code.ts\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memFS := fstest.MapFS{
+		"Dockerfile": {Data: []byte("FROM deno\n")},
+		"code.ts":    {Data: []byte("export {}")},
+		"notes.txt":  {Data: []byte("draft")},
+	}
+
+	kept := slices.Sorted(maps.Keys(rules.filter(memFS)))
+	if want := []string{"Dockerfile", "code.ts"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if got := ignoreRules(nil).filter(memFS); len(got) != len(memFS) {
+		t.Errorf("no rules kept %d of %d files", len(got), len(memFS))
+	}
+}
+
+// Files the .dockerignore excludes don't reach the build, nor the content
+// digest, so changing them doesn't rebuild.
+func TestBuildImageContextIgnoresExcluded(t *testing.T) {
+	rules, err := parseDockerignore("*.md\nfixtures\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := contextIgnore
+	contextIgnore = rules
+	t.Cleanup(func() { contextIgnore = previous })
+
+	memFS, err := createFS("sum", "export const sum = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := contextDigest(memFS, Metadata{})
+	memFS["README.md"] = &fstest.MapFile{Data: []byte("# Sum")}
+	memFS["fixtures/large.json"] = &fstest.MapFile{Data: []byte("[]")}
+	if contextDigest(memFS, Metadata{}) != digest {
+		t.Error("excluded files changed the content digest")
+	}
+
+	docker := newFakeDocker(t)
+	if err := buildImage(context.Background(), testConfig(t, nil), docker.client, "built", Metadata{}, memFS, io.Discard, false); err != nil {
+		t.Fatal(err)
+	}
+	files := docker.Builds()[0].Files
+	for _, name := range []string{"README.md", "fixtures/large.json"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("excluded %s is in the build context", name)
+		}
+	}
+	for _, name := range []string{"Dockerfile", "code.ts", "test.ts"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s is missing from the build context", name)
+		}
+	}
+}
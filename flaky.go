@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/moby/moby/client"
+)
+
+// repeatCount reads ?repeat=N, clamped to between 1 and limit.
+func repeatCount(r *http.Request, limit int) int {
+	repeat, err := strconv.Atoi(r.URL.Query().Get("repeat"))
+	if err != nil || repeat < 1 {
+		return 1
+	}
+	return min(repeat, limit)
+}
+
+// repeatRun runs the submission again until it has run repeat times in
+// total, one run after another, and records in first the cases whose outcome
+// varied. Repeating stops early once the user's runtime budget runs out.
+func repeatRun(ctx context.Context, cfg *Config, cli *client.Client, req RunRequest, first *RunResult, repeat int, budget *runtimeBudget) error {
+	results := []RunResult{*first}
+	for len(results) < repeat {
+		if budget != nil && budget.Remaining(req.User) == 0 {
+			break
+		}
+
+		execution, err := executeCodeTest(ctx, cfg, cli, req)
+		if budget != nil && execution != nil {
+			budget.Charge(req.User, execution.RunDuration)
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := buildResult(cfg, req.Task, execution)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+
+	first.Runs = len(results)
+	first.Flaky = flakyCases(results)
+	return nil
+}
+
+// flakyCases lists, in order of first appearance, the cases that passed in
+// some results and failed in others.
+func flakyCases(results []RunResult) []FlakyCase {
+	type caseKey struct{ suite, name string }
+	counts := map[caseKey]*FlakyCase{}
+	order := []caseKey{}
+
+	for _, result := range results {
+		for _, c := range result.Cases {
+			key := caseKey{c.Suite, c.Name}
+			counted, ok := counts[key]
+			if !ok {
+				counted = &FlakyCase{Name: c.Name, Suite: c.Suite}
+				counts[key] = counted
+				order = append(order, key)
+			}
+			switch c.Status {
+			case StatusPassed:
+				counted.Passed++
+			case StatusFailed, StatusError:
+				counted.Failed++
+			}
+		}
+	}
+
+	flaky := []FlakyCase{}
+	for _, key := range order {
+		counted := counts[key]
+		if counted.Passed == 0 || counted.Failed == 0 {
+			continue
+		}
+		counted.Score = 2 * float64(min(counted.Passed, counted.Failed)) / float64(counted.Passed+counted.Failed)
+		flaky = append(flaky, *counted)
+	}
+	return flaky
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestRepeatCount(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 1},
+		{"repeat=abc", 1},
+		{"repeat=0", 1},
+		{"repeat=-3", 1},
+		{"repeat=3", 3},
+		{"repeat=50", 5},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/test/sum?"+tt.query, nil)
+		if got := repeatCount(r, 5); got != tt.want {
+			t.Errorf("repeatCount(%q, 5) = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFlakyCases(t *testing.T) {
+	run := func(statuses ...string) RunResult {
+		result := newRunResult()
+		for i, status := range statuses {
+			result.addCase(TestCase{Name: []string{"adds", "subtracts", "later"}[i], Suite: "test.ts", Status: status}, 0)
+		}
+		return result
+	}
+	results := []RunResult{
+		run(StatusPassed, StatusPassed, StatusSkipped),
+		run(StatusFailed, StatusPassed, StatusPassed),
+		run(StatusPassed, StatusPassed, StatusSkipped),
+		run(StatusError, StatusPassed, StatusSkipped),
+		run(StatusPassed, StatusPassed, StatusSkipped),
+	}
+
+	flaky := flakyCases(results)
+	if len(flaky) != 1 {
+		t.Fatalf("got flaky cases %+v, want only adds", flaky)
+	}
+	want := FlakyCase{Name: "adds", Suite: "test.ts", Passed: 3, Failed: 2, Score: 0.8}
+	if flaky[0] != want {
+		t.Errorf("got %+v, want %+v", flaky[0], want)
+	}
+
+	if flaky := flakyCases(results[:1]); len(flaky) != 0 {
+		t.Errorf("a single run reported flaky cases %+v", flaky)
+	}
+}
+
+// flakyDocker fakes a runner whose "adds" case fails on every second run.
+func flakyDocker(t *testing.T, cfg *Config) *fakeDocker {
+	docker := newFakeDocker(t).withImage(cfg, "sum")
+	runs := 0
+	docker.onStart = func(*fakeContainer) {
+		docker.mu.Lock()
+		defer docker.mu.Unlock()
+
+		runs++
+		adds := `<testcase name="adds" classname="test.ts"/>`
+		if runs%2 == 0 {
+			adds = `<testcase name="adds" classname="test.ts"><failure message="expected 3"/></testcase>`
+		}
+		docker.files[path.Join(defaultWorkingDir, "report.xml")] = []byte(junitReport(
+			adds,
+			`<testcase name="subtracts" classname="test.ts"/>`,
+		))
+	}
+	return docker
+}
+
+func TestRepeatRunReportsFlakyCases(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := flakyDocker(t, cfg)
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+
+	execution, err := executeCodeTest(context.Background(), cfg, docker.client, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := buildResult(cfg, req.Task, execution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repeatRun(context.Background(), cfg, docker.client, req, &first, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Runs != 4 {
+		t.Errorf("got %d runs, want 4", first.Runs)
+	}
+	if got := len(docker.Containers()); got != 4 {
+		t.Errorf("started %d containers, want 4", got)
+	}
+	want := FlakyCase{Name: "adds", Suite: "test.ts", Passed: 2, Failed: 2, Score: 1}
+	if len(first.Flaky) != 1 || first.Flaky[0] != want {
+		t.Errorf("got flaky cases %+v, want only %+v", first.Flaky, want)
+	}
+}
+
+func TestRepeatRunStopsWhenBudgetRunsOut(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := flakyDocker(t, cfg)
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+
+	execution, err := executeCodeTest(context.Background(), cfg, docker.client, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := buildResult(cfg, req.Task, execution)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	budget := newRuntimeBudget(time.Nanosecond, time.Hour)
+	budget.Charge(req.User, time.Second)
+	if err := repeatRun(context.Background(), cfg, docker.client, req, &first, 4, budget); err != nil {
+		t.Fatal(err)
+	}
+	if first.Runs != 1 || len(docker.Containers()) != 1 {
+		t.Errorf("with no budget left got %d runs and %d containers, want 1 of each", first.Runs, len(docker.Containers()))
+	}
+}
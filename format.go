@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	formatXML  = "xml"
+	formatTAP  = "tap"
+	formatJSON = "json"
+	formatSSE  = "sse"
+	// formatNDJSON streams the same events as formatSSE, one JSON object
+	// per line; see ndjsonLine.
+	formatNDJSON = "ndjson"
+
+	tapContentType = "text/tap"
+)
+
+// responseFormat picks the run response format from the format query
+// parameter or the Accept header, defaulting to the raw JUnit XML report.
+// ?summary=1 implies JSON unless a format is given.
+func responseFormat(r *http.Request) string {
+	switch r.URL.Query().Get("format") {
+	case formatTAP:
+		return formatTAP
+	case formatJSON:
+		return formatJSON
+	case formatSSE:
+		return formatSSE
+	case formatNDJSON:
+		return formatNDJSON
+	case formatXML:
+		return formatXML
+	}
+	if queryBool(r, "summary") {
+		return formatJSON
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case tapContentType:
+			return formatTAP
+		case "application/json":
+			return formatJSON
+		case "text/event-stream":
+			return formatSSE
+		case ndjsonContentType:
+			return formatNDJSON
+		}
+	}
+
+	return formatXML
+}
+
+// marshalResponse encodes a JSON response body, indented when the client
+// asks for ?pretty=1 and compact otherwise.
+func marshalResponse(r *http.Request, v any) []byte {
+	if queryBool(r, "pretty") {
+		resp, _ := json.MarshalIndent(v, "", "  ")
+		return resp
+	}
+	resp, _ := json.Marshal(v)
+	return resp
+}
+
+// RunSummary is the JSON body returned instead of the full RunResult for
+// ?summary=1. It carries:
+//
+//   - schemaVersion: the resultSchemaVersion, shared with RunResult
+//   - status: "passed" when no case failed or errored and the run finished,
+//     "failed" otherwise
+//   - passed, failed, skipped, total: the RunResult counts
+//   - timedOut, memoryExceeded: set when the run was cut short
+//
+// Per-case detail, output, resources and annotations are left out.
+type RunSummary struct {
+	SchemaVersion  string `json:"schemaVersion"`
+	Status         string `json:"status"`
+	Passed         int    `json:"passed"`
+	Failed         int    `json:"failed"`
+	Skipped        int    `json:"skipped"`
+	Total          int    `json:"total"`
+	TimedOut       bool   `json:"timedOut,omitempty"`
+	MemoryExceeded bool   `json:"memoryExceeded,omitempty"`
+}
+
+func summarize(result RunResult) RunSummary {
+	summary := RunSummary{
+		SchemaVersion:  result.SchemaVersion,
+		Status:         StatusPassed,
+		Passed:         result.Passed,
+		Failed:         result.Failed,
+		Skipped:        result.Skipped,
+		Total:          result.Total,
+		TimedOut:       result.TimedOut,
+		MemoryExceeded: result.MemoryExceeded,
+	}
+	if result.Failed > 0 || result.TimedOut || result.MemoryExceeded {
+		summary.Status = StatusFailed
+	}
+	return summary
+}
+
+// marshalResult encodes a run result, reduced to its RunSummary when the
+// client asks for ?summary=1.
+func marshalResult(r *http.Request, result RunResult) []byte {
+	if queryBool(r, "summary") {
+		return marshalResponse(r, summarize(result))
+	}
+	return marshalResponse(r, result)
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+// runHandler runs a submission and responds with its result in the
+// requested format. A run that completes responds 200 even when tests fail,
+// unless the client asks for strict mode with ?strict=1 or an
+// X-Strict: 1 header: then any failed or errored case turns the status
+// into 422, with the same body. Strict mode doesn't apply to event streams,
+// whose status is sent before the tests run.
+//
+// With ?format=sse, or Accept: text/event-stream, the run is streamed as
+// server-sent events, and with ?format=ndjson, or Accept:
+// application/x-ndjson, as the same events in JSON lines over a chunked
+// response. Either way each test case is sent as the runner reports it,
+// unless the task's runner doesn't print per-test lines, in which case only
+// the final result is.
+//
+// With ?async=1 the run is queued instead: the response is 202 with the
+// queued job, whose result is polled from GET /results/{id}, or 503 when the
+// queue is full. An async request may name a callbackUrl, which is POSTed the
+// finished job.
+//
+// With ?repeat=N, up to MAX_REPEAT_RUNS, a JSON response also runs the
+// submission N times in a row and lists the cases whose outcome varied.
+//
+// Run responses carry an ETag derived from the submitted code. A request
+// whose If-None-Match names it gets 304 Not Modified, without running,
+// while the store holds a result for the same code and task that finished
+// within RESULT_CACHE_AGE; the client is expected to reuse the response it
+// already has. Otherwise the submission runs as usual.
+//
+// With ?summary=1 the JSON response is a RunSummary: counts and overall
+// status without per-case detail. With ?rawReport=1 a JSON result also
+// carries the raw report in reportRaw.
+//
+// With ?nocache=1, which needs the admin token, images the run needs are
+// built without the build cache.
+//
+// A submission may send a patch against the task's code.ts instead of the
+// code; one that doesn't apply cleanly gets 422 with patch_conflict.
+func runHandler(cfg *Config, cli *client.Client, builds *limiter, runs *limiter, budget *runtimeBudget, jobs *jobQueue, memory *memoryGuard, results *resultStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		test := r.PathValue("test")
+
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			writeError(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "Content-Type must be application/json")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			slog.Error("reading body", "request_id", requestID(r.Context()), "error", err)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "can't read request body")
+			return
+		}
+
+		defer r.Body.Close()
+
+		code := &Code{}
+
+		err = json.Unmarshal(body, code)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid request body: "+err.Error())
+			return
+		}
+
+		if !taskExists(test) {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't find test "+test)
+			return
+		}
+
+		meta, err := loadMetadata(test)
+		if err != nil {
+			slog.Error("loading metadata", "request_id", requestID(r.Context()), "task", test, "error", err)
+			writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+
+		noCache := queryBool(r, "nocache")
+		if noCache {
+			if !isAdmin(cfg, r) {
+				writeError(w, r, http.StatusForbidden, codeAdminRequired, "nocache requires the admin token")
+				return
+			}
+			slog.Info("no-cache build requested", "request_id", requestID(r.Context()), "task", test, "user", code.User)
+		}
+
+		if code.Patch != "" {
+			if code.Code != "" {
+				writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "send either code or patch, not both")
+				return
+			}
+			code.Code, err = resolvePatch(test, code.Patch)
+			if err != nil {
+				writeRunError(w, r, err)
+				return
+			}
+		}
+
+		hash := codeHash(code.Code)
+		etag := submissionETag(hash)
+		if cfg.ResultCacheAge != 0 && !asyncRequested(r) && !noCache && etagMatches(r, etag) && results.Recent(test, hash, cfg.ResultCacheAge) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if budgetExhausted(w, r, budget, code.User) {
+			return
+		}
+
+		if code.CallbackURL != "" {
+			if !asyncRequested(r) {
+				writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "callbackUrl requires async=1")
+				return
+			}
+			if err := jobs.ValidateCallback(r.Context(), code.CallbackURL); err != nil {
+				writeError(w, r, http.StatusBadRequest, codeCallbackNotAllowed, err.Error())
+				return
+			}
+		}
+
+		reserved := runMemory(cfg, code.Code)
+		if !memory.Reserve(reserved) {
+			writeError(w, r, http.StatusServiceUnavailable, codeOutOfMemory, "Server is out of memory for new runs")
+			return
+		}
+
+		if asyncRequested(r) {
+			job, ok := jobs.Enqueue(requestID(r.Context()), RunRequest{Task: test, User: code.User, Code: code.Code, NoCache: noCache}, meta, code.CallbackURL, reserved)
+			if !ok {
+				memory.Release(reserved)
+				writeError(w, r, http.StatusServiceUnavailable, codeQueueFull, "Run queue is full")
+				return
+			}
+
+			resp := marshalResponse(r, job)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "/results/"+job.ID)
+			w.WriteHeader(http.StatusAccepted)
+			w.Write(resp)
+			return
+		}
+		defer memory.Release(reserved)
+
+		releaseTasks, ok := acquireTaskSlots(w, r, []string{test}, []Metadata{meta})
+		if !ok {
+			return
+		}
+		defer releaseTasks()
+
+		req := RunRequest{Task: test, User: code.User, Code: code.Code, Builds: builds, Runs: runs, NoCache: noCache}
+		summary := newRunSummary(requestID(r.Context()), req)
+		defer summary.log()
+
+		format := responseFormat(r)
+		if format == formatSSE || format == formatNDJSON {
+			// Headers go out with the first event, so this is the
+			// budget before the run.
+			if budget != nil {
+				budget.setHeader(w, req.User)
+			}
+			var stream eventSender
+			if format == formatSSE {
+				stream = newSSEWriter(w)
+			} else {
+				stream = newNDJSONWriter(w)
+			}
+			streamRun(r, cfg, cli, req, meta, stream, summary, budget, results)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		execution, err := executeCodeTest(r.Context(), cfg, cli, req)
+		chargeBudget(w, budget, req.User, execution)
+		if err != nil {
+			summary.record(execution, nil, err)
+			results.Record(req, nil, err, newRunArtifacts(cfg, req, execution))
+			slog.Error("running test", "request_id", requestID(r.Context()), "task", req.Task, "error", err)
+			writeRunError(w, r, err)
+			return
+		}
+
+		result, err := buildResult(cfg, test, execution)
+		result.recordCode(cfg, req.Code)
+		summary.record(execution, &result, err)
+		results.Record(req, &result, err, newRunArtifacts(cfg, req, execution))
+
+		// Repeats only show in JSON, so other formats don't pay for them.
+		if repeat := repeatCount(r, cfg.MaxRepeatRuns); err == nil && repeat > 1 && format == formatJSON {
+			if cfg.WriteTimeout != 0 {
+				http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Duration(repeat) * cfg.WriteTimeout))
+			}
+			if err := repeatRun(r.Context(), cfg, cli, req, &result, repeat, budget); err != nil {
+				slog.Error("repeating test", "request_id", requestID(r.Context()), "task", req.Task, "error", err)
+				writeRunError(w, r, err)
+				return
+			}
+			if budget != nil {
+				budget.setHeader(w, req.User)
+			}
+		}
+
+		status := http.StatusOK
+		if err == nil && result.Failed > 0 && strictRequested(r) {
+			status = http.StatusUnprocessableEntity
+		}
+
+		if format != formatXML {
+			if err != nil {
+				slog.Error("parsing report", "request_id", requestID(r.Context()), "task", req.Task, "error", err)
+				writeRunError(w, r, err)
+				return
+			}
+
+			if format == formatTAP {
+				w.Header().Set("Content-Type", tapContentType)
+				w.WriteHeader(status)
+				w.Write(toTAP(result))
+				return
+			}
+
+			if queryBool(r, "rawReport") {
+				result.ReportRaw = execution.Report
+			}
+			resp := marshalResult(r, result)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write(resp)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		w.Write(execution.Report)
+	}
+}
+
+// strictRequested reports whether the client wants failing tests to fail the
+// request.
+func strictRequested(r *http.Request) bool {
+	if r.URL.Query().Get("strict") != "" {
+		return queryBool(r, "strict")
+	}
+	strict, _ := strconv.ParseBool(r.Header.Get("X-Strict"))
+	return strict
+}
+
+// queryBool reads a boolean query parameter such as ?async=1.
+func queryBool(r *http.Request, name string) bool {
+	value, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return value
+}
+
+// eventSender is a run stream: an sseWriter or an ndjsonWriter.
+type eventSender interface {
+	Send(event string, data any) error
+}
+
+// budgetExhausted responds 429 when user has no runtime budget left.
+func budgetExhausted(w http.ResponseWriter, r *http.Request, budget *runtimeBudget, user string) bool {
+	if budget == nil || budget.Remaining(user) > 0 {
+		return false
+	}
+	budget.setHeader(w, user)
+	writeError(w, r, http.StatusTooManyRequests, codeBudgetExhausted, "Runtime budget exhausted for "+user)
+	return true
+}
+
+// chargeBudget charges a run's container time to its user and sets the
+// remaining budget header. execution is nil when the run failed before it
+// started.
+func chargeBudget(w http.ResponseWriter, budget *runtimeBudget, user string, execution *Execution) {
+	if budget == nil {
+		return
+	}
+	if execution != nil {
+		budget.Charge(user, execution.RunDuration)
+	}
+	budget.setHeader(w, user)
+}
+
+// acquireTaskSlots takes a slot of each task's own concurrency limit. If any
+// is full it takes none and responds 429. The returned func releases the
+// slots.
+func acquireTaskSlots(w http.ResponseWriter, r *http.Request, tasks []string, metas []Metadata) (func(), bool) {
+	taken := []*limiter{}
+	release := func() {
+		for _, l := range taken {
+			l.Release()
+		}
+	}
+	for i, task := range tasks {
+		taskRuns := taskLimiter(task, metas[i])
+		if taskRuns == nil {
+			continue
+		}
+		if !taskRuns.TryAcquire() {
+			release()
+			writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many concurrent runs of "+task)
+			return nil, false
+		}
+		taken = append(taken, taskRuns)
+	}
+	return release, true
+}
+
+// streamRun sends "build" events while any image the run needs builds, a
+// "progress" event per test as the runner reports it, and then a final
+// "result" event, or an "error" event if the run fails. Tasks whose runner
+// doesn't stream progress only get the final event.
+func streamRun(r *http.Request, cfg *Config, cli *client.Client, req RunRequest, meta Metadata, stream eventSender, summary *runSummary, budget *runtimeBudget, results *resultStore) {
+	if meta.streamsProgress() {
+		req.Progress = func(event ProgressEvent) {
+			stream.Send("progress", event)
+		}
+	}
+	req.BuildProgress = func(event BuildEvent) {
+		stream.Send("build", event)
+	}
+
+	execution, err := executeCodeTest(r.Context(), cfg, cli, req)
+	if budget != nil && execution != nil {
+		budget.Charge(req.User, execution.RunDuration)
+	}
+	if err != nil {
+		summary.record(execution, nil, err)
+		results.Record(req, nil, err, newRunArtifacts(cfg, req, execution))
+		slog.Error("running test", "request_id", requestID(r.Context()), "task", req.Task, "error", err)
+		_, body := runErrorBody(err)
+		stream.Send("error", body)
+		return
+	}
+
+	result, err := buildResult(cfg, req.Task, execution)
+	result.recordCode(cfg, req.Code)
+	summary.record(execution, &result, err)
+	results.Record(req, &result, err, newRunArtifacts(cfg, req, execution))
+	if err != nil {
+		slog.Error("parsing report", "request_id", requestID(r.Context()), "task", req.Task, "error", err)
+		_, body := runErrorBody(err)
+		stream.Send("error", body)
+		return
+	}
+
+	if queryBool(r, "rawReport") {
+		result.ReportRaw = execution.Report
+	}
+	stream.Send("result", result)
+}
+
+func testHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		test := r.PathValue("test")
+		code, err := files.ReadFile(fmt.Sprintf("tests/%s/code.ts", test))
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't get base code for "+test)
+			return
+		}
+		desc, err := files.ReadFile(fmt.Sprintf("tests/%s/README.md", test))
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't get description for "+test)
+			return
+		}
+
+		testData := Test{
+			Name: test,
+			Base: string(code),
+			Desc: string(desc),
+		}
+
+		resp := marshalResponse(r, testData)
+
+		serveCached(w, r, cfg, "application/json", resp)
+	}
+}
+
+// testFileHandler serves a task's test.ts when the deployment allows it.
+func testFileHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		test := r.PathValue("test")
+		testFile, err := files.ReadFile(fmt.Sprintf("tests/%s/test.ts", test))
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't get test file for "+test)
+			return
+		}
+		if !cfg.ExposeTestFiles {
+			writeError(w, r, http.StatusForbidden, codeTestHidden, "Test files are hidden on this server")
+			return
+		}
+
+		serveCached(w, r, cfg, "application/typescript", testFile)
+	}
+}
+
+// casesHandler lists the test cases a task checks, without running them.
+func casesHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		test := r.PathValue("test")
+		if !taskExists(test) {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't find test "+test)
+			return
+		}
+
+		cases, err := loadTaskCases(test)
+		if err != nil {
+			slog.Error("listing cases", "request_id", requestID(r.Context()), "task", test, "error", err)
+			writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+
+		resp := marshalResponse(r, cases)
+
+		serveCached(w, r, cfg, "application/json", resp)
+	}
+}
+
+func metaHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		test := r.PathValue("test")
+		if !taskExists(test) {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't find test "+test)
+			return
+		}
+
+		meta, err := loadMetadata(test)
+		if err != nil {
+			slog.Error("loading metadata", "request_id", requestID(r.Context()), "task", test, "error", err)
+			writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+
+		resp := marshalResponse(r, meta)
+
+		serveCached(w, r, cfg, "application/json", resp)
+	}
+}
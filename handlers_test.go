@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve runs one request through handler, routed by pattern.
+func serve(t *testing.T, pattern string, handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	router := http.NewServeMux()
+	router.HandleFunc(pattern, handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// assertAPIError checks that the response is a JSON error with status and
+// code.
+func assertAPIError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Errorf("status is %d, want %d", w.Code, status)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type is %q, want application/json", contentType)
+	}
+	body := apiError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding error body %q: %v", w.Body, err)
+	}
+	if body.Code != code || body.Error == "" {
+		t.Errorf("error is %+v, want code %s with a message", body, code)
+	}
+}
+
+func TestCasesHandlerErrors(t *testing.T) {
+	cfg := testConfig(t, nil)
+
+	w := serve(t, "GET /test/{test}/cases", casesHandler(cfg), httptest.NewRequest("GET", "/test/missing/cases", nil))
+	assertAPIError(t, w, http.StatusNotFound, codeTestNotFound)
+
+	// fizzbuzzer has neither a cases.json nor a test.ts to list cases from.
+	w = serve(t, "GET /test/{test}/cases", casesHandler(cfg), httptest.NewRequest("GET", "/test/fizzbuzzer/cases", nil))
+	assertAPIError(t, w, http.StatusInternalServerError, codeInternal)
+}
+
+func TestMetaHandlerErrors(t *testing.T) {
+	w := serve(t, "GET /test/{test}/meta", metaHandler(testConfig(t, nil)), httptest.NewRequest("GET", "/test/missing/meta", nil))
+	assertAPIError(t, w, http.StatusNotFound, codeTestNotFound)
+}
+
+func TestAcquireTaskSlots(t *testing.T) {
+	metas := []Metadata{{MaxConcurrentRuns: 1}, {}, {MaxConcurrentRuns: 1}}
+	tasks := []string{"test-slots-a", "test-slots-b", "test-slots-c"}
+
+	release, ok := acquireTaskSlots(httptest.NewRecorder(), httptest.NewRequest("POST", "/run", nil), tasks, metas)
+	if !ok {
+		t.Fatal("free slots were refused")
+	}
+
+	// With c busy, a's slot is given back as well.
+	release()
+	busy := taskLimiter("test-slots-c", metas[2])
+	busy.TryAcquire()
+	w := httptest.NewRecorder()
+	if _, ok := acquireTaskSlots(w, httptest.NewRequest("POST", "/run", nil), tasks, metas); ok {
+		t.Fatal("a full task limit was passed")
+	}
+	assertAPIError(t, w, http.StatusTooManyRequests, codeRateLimited)
+	if running := taskLimiter("test-slots-a", metas[0]).Running(); running != 0 {
+		t.Errorf("a holds %d slots after the refusal, want 0", running)
+	}
+	busy.Release()
+}
+
+// nocache reaches the image build only for an admin, and rebuilds even a
+// fresh base image.
+func TestRunHandlerNoCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		token   string
+		status  int
+		builds  int
+		noCache bool
+	}{
+		{"cached", "", "", http.StatusOK, 0, false},
+		{"nocache", "?nocache=1", "secret", http.StatusOK, 1, true},
+		{"nocache without the token", "?nocache=1", "", http.StatusForbidden, 0, false},
+		{"nocache with the wrong token", "?nocache=1", "wrong", http.StatusForbidden, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, map[string]string{"ADMIN_TOKEN": "secret"})
+			docker := newFakeDocker(t).withImage(cfg, "sum").withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+			logs := captureLogs(t)
+
+			r := httptest.NewRequest("POST", "/test/sum/run"+tt.query, strings.NewReader(`{"user": "alice", "code": "export const sum = 1"}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := serve(t, "POST /test/{test}/run", runHandler(cfg, docker.client, nil, nil, nil, nil, nil, newResultStore(100, 100)), r)
+			if w.Code != tt.status {
+				t.Fatalf("status is %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusForbidden {
+				assertAPIError(t, w, http.StatusForbidden, codeAdminRequired)
+			}
+
+			builds := docker.Builds()
+			if len(builds) != tt.builds {
+				t.Fatalf("%d builds, want %d", len(builds), tt.builds)
+			}
+			for _, build := range builds {
+				if build.NoCache != tt.noCache {
+					t.Errorf("build NoCache is %v, want %v", build.NoCache, tt.noCache)
+				}
+			}
+
+			logged := false
+			for _, line := range logLines(t, logs) {
+				if line["msg"] == "no-cache build requested" {
+					logged = true
+				}
+			}
+			if logged != tt.noCache {
+				t.Errorf("no-cache request logged: %v, want %v", logged, tt.noCache)
+			}
+		})
+	}
+}
+
+func TestRunHandlerRawReport(t *testing.T) {
+	report := junitReport(`<testcase name="adds" classname="test.ts"/>`, `<testcase name="subtracts" classname="test.ts"><failure message="off by one"/></testcase>`)
+	tests := []struct {
+		query string
+		want  []byte
+	}{
+		{"?format=json", nil},
+		{"?format=json&rawReport=1", []byte(report)},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			cfg := testConfig(t, nil)
+			docker := newFakeDocker(t).withImage(cfg, "sum").withReport(report)
+
+			r := httptest.NewRequest("POST", "/test/sum/run"+test.query, strings.NewReader(`{"user": "alice", "code": "export const sum = 1"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := serve(t, "POST /test/{test}/run", runHandler(cfg, docker.client, nil, nil, nil, nil, nil, newResultStore(100, 100)), r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("run responded %d: %s", w.Code, w.Body)
+			}
+
+			body := map[string]any{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			encoded, ok := body["reportRaw"].(string)
+			if test.want == nil {
+				if ok {
+					t.Errorf("result has reportRaw %q without rawReport", encoded)
+				}
+				return
+			}
+			raw, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("decoding reportRaw %q: %v", encoded, err)
+			}
+			if !bytes.Equal(raw, test.want) {
+				t.Errorf("reportRaw decodes to %q, want the report %q", raw, test.want)
+			}
+			if body["total"] != float64(2) {
+				t.Errorf("parsed result has total %v alongside the raw report, want 2", body["total"])
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+var shuttingDown atomic.Bool
+
+// warmingUp is set while warmupOnStart runs.
+var warmingUp atomic.Bool
+
+// warmupRetryAfter is the Retry-After, in seconds, of runs refused while
+// the server warms up.
+const warmupRetryAfter = "10"
+
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+func writeHealth(w http.ResponseWriter, checks []healthCheck) {
+	report := healthReport{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			report.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	resp, _ := json.Marshal(report)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+func shutdownCheck() healthCheck {
+	check := healthCheck{Name: "shutdown", OK: !shuttingDown.Load()}
+	if !check.OK {
+		check.Detail = "server is shutting down"
+	}
+	return check
+}
+
+func warmupCheck() healthCheck {
+	check := healthCheck{Name: "warmup", OK: !warmingUp.Load()}
+	if !check.OK {
+		check.Detail = "server is warming up"
+	}
+	return check
+}
+
+// requireWarm refuses requests with 503 and a Retry-After while the server
+// warms up.
+func requireWarm(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if warmingUp.Load() {
+			w.Header().Set("Retry-After", warmupRetryAfter)
+			writeError(w, r, http.StatusServiceUnavailable, codeWarmingUp, "Server is warming up")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// livezHandler reports whether the process is alive. It only fails once the
+// server has started shutting down.
+func livezHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, []healthCheck{shutdownCheck()})
+	}
+}
+
+// readyzHandler reports whether the server can accept test runs right now.
+func readyzHandler(cli *client.Client, runs *limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		docker := healthCheck{Name: "docker", OK: true}
+		if _, err := cli.Ping(ctx); err != nil {
+			docker.OK = false
+			docker.Detail = err.Error()
+		}
+
+		slots := healthCheck{
+			Name:   "slots",
+			OK:     runs.Running() < runs.Capacity(),
+			Detail: fmt.Sprintf("%d of %d runs in use", runs.Running(), runs.Capacity()),
+		}
+
+		writeHealth(w, []healthCheck{shutdownCheck(), warmupCheck(), docker, slots})
+	}
+}
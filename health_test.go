@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func readyzStatus(t *testing.T, handler http.HandlerFunc) (int, map[string]bool) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/readyz", nil))
+	report := healthReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decoding readyz %q: %v", w.Body, err)
+	}
+	checks := map[string]bool{}
+	for _, check := range report.Checks {
+		checks[check.Name] = check.OK
+	}
+	return w.Code, checks
+}
+
+// Runs are refused, and the server isn't ready, until startup warmup has
+// built every base image.
+func TestWarmupOnStartGatesRuns(t *testing.T) {
+	t.Cleanup(func() { warmingUp.Store(false) })
+
+	cfg := testConfig(t, map[string]string{"WARMUP_ON_START": "true"})
+	docker := newFakeDocker(t)
+	runs := newLimiter(1)
+	readyz := readyzHandler(docker.client, runs)
+	run := requireWarm(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Holding the only build slot keeps warmup going until the test lets
+	// it finish.
+	builds := newLimiter(1)
+	if !builds.TryAcquire() {
+		t.Fatal("new limiter is full")
+	}
+	warmingUp.Store(true)
+	done := make(chan struct{})
+	go func() {
+		warmupOnStart(cfg, docker.client, builds, runs)
+		close(done)
+	}()
+
+	w := httptest.NewRecorder()
+	run(w, httptest.NewRequest("POST", "/test/sum/run", nil))
+	assertAPIError(t, w, http.StatusServiceUnavailable, codeWarmingUp)
+	if got := w.Header().Get("Retry-After"); got != warmupRetryAfter {
+		t.Errorf("Retry-After is %q, want %q", got, warmupRetryAfter)
+	}
+	if status, checks := readyzStatus(t, readyz); status != http.StatusServiceUnavailable || checks["warmup"] {
+		t.Errorf("readyz during warmup is %d with checks %v, want 503 with warmup failing", status, checks)
+	}
+
+	builds.Release()
+	<-done
+
+	w = httptest.NewRecorder()
+	run(w, httptest.NewRequest("POST", "/test/sum/run", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("run after warmup responded %d: %s", w.Code, w.Body)
+	}
+	if status, checks := readyzStatus(t, readyz); status != http.StatusOK || !checks["warmup"] {
+		t.Errorf("readyz after warmup is %d with checks %v, want 200", status, checks)
+	}
+	if len(docker.Builds()) == 0 {
+		t.Error("warmup built no base images")
+	}
+}
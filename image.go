@@ -0,0 +1,357 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/client"
+)
+
+// dockerfiles are the shared Dockerfiles every build relies on.
+var dockerfiles = []string{"image/Dockerfile", "image/Dockerfile.composite"}
+
+// checkDockerfiles fails if a shared Dockerfile is missing from fsys, the
+// embedded files, so a broken embed pattern stops the server at startup
+// instead of failing every build.
+func checkDockerfiles(fsys fs.FS) error {
+	for _, name := range dockerfiles {
+		if _, err := fs.ReadFile(fsys, name); err != nil {
+			return fmt.Errorf("embedded %s is missing: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func createFS(task string, code string) (fstest.MapFS, error) {
+	memFS := fstest.MapFS{
+		"code.ts": &fstest.MapFile{Data: []byte(code), Mode: 0644},
+	}
+
+	dockerfile, err := taskDockerfile(files, task)
+	if err != nil {
+		return nil, err
+	}
+
+	testFile, err := files.ReadFile(fmt.Sprintf("tests/%s/test.ts", task))
+	if err != nil {
+		return nil, fmt.Errorf("reading test file: %w", err)
+	}
+
+	memFS["Dockerfile"] = &fstest.MapFile{Data: dockerfile, Mode: 0644}
+	memFS["test.ts"] = &fstest.MapFile{Data: testFile, Mode: 0644}
+	addCABundle(memFS)
+
+	return memFS, nil
+}
+
+var errTooManyFiles = errors.New("build context has too many files")
+
+// tarImageContext archives files, failing with errTooManyFiles once more than
+// maxFiles entries have been walked.
+func tarImageContext(files fs.FS, maxFiles int) (io.Reader, error) {
+	buffer := new(bytes.Buffer)
+	tarwriter := tar.NewWriter(buffer)
+	count := 0
+
+	err := fs.WalkDir(files, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if file == "." {
+			return nil
+		}
+
+		count++
+		if count > maxFiles {
+			return fmt.Errorf("%w: limit is %d", errTooManyFiles, maxFiles)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = file
+		hdr.ModTime = time.Now()
+
+		if err := tarwriter.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		f, err := files.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tarwriter, f); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tarwriter.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer, nil
+}
+
+func baseImageName(cfg *Config, task string) string {
+	if cfg.ImagePrefix == "" {
+		return "gitblame-base/" + task
+	}
+	return fmt.Sprintf("%s/gitblame-base/%s", cfg.ImagePrefix, task)
+}
+
+var baseImageLocks sync.Map
+
+// contextDigest hashes everything that goes into a build: the context's
+// files that .dockerignore keeps, including the Dockerfile and test, and the
+// build args and target.
+func contextDigest(memFS fstest.MapFS, meta Metadata) string {
+	memFS = contextIgnore.filter(memFS)
+	hash := sha256.New()
+	for _, name := range slices.Sorted(maps.Keys(memFS)) {
+		fmt.Fprintf(hash, "file %q %d\n", name, len(memFS[name].Data))
+		hash.Write(memFS[name].Data)
+	}
+	for _, name := range slices.Sorted(maps.Keys(meta.BuildArgs)) {
+		fmt.Fprintf(hash, "arg %q %q\n", name, meta.BuildArgs[name])
+	}
+	fmt.Fprintf(hash, "target %q\n", meta.BuildTarget)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// staleImage returns why an existing base image must be rebuilt: its
+// content label doesn't match digest, or it is older than MAX_IMAGE_AGE. It
+// returns "" for an image that is still fresh.
+func staleImage(cfg *Config, inspect image.InspectResponse, digest string) string {
+	if inspect.Config == nil || inspect.Config.Labels[contentLabel] != digest {
+		return "task content changed"
+	}
+	if cfg.MaxImageAge > 0 {
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		if err != nil {
+			return "unknown creation time"
+		}
+		if age := time.Since(created); age > cfg.MaxImageAge {
+			return fmt.Sprintf("built %s ago", age.Round(time.Second))
+		}
+	}
+	return ""
+}
+
+var (
+	errBuildFailed = errors.New("image build failed")
+	errNoImage     = errors.New("build produced no image")
+)
+
+// buildImage builds memFS into imageName, copying the daemon's build output
+// to output. With noCache no layer is reused from the build cache.
+func buildImage(ctx context.Context, cfg *Config, cli *client.Client, imageName string, meta Metadata, memFS fstest.MapFS, output io.Writer, noCache bool) error {
+	if meta.BuildTarget != "" {
+		if err := requireAPI(featureBuildTarget); err != nil {
+			return err
+		}
+	}
+	if dockerfile, ok := memFS["Dockerfile"]; ok {
+		if err := checkBaseImages(cfg, dockerfile.Data, meta); err != nil {
+			return err
+		}
+	}
+	networkMode := meta.buildNetwork(cfg.BuildNetworkMode)
+	if requireAPI(featureBuildNetwork) != nil {
+		networkMode = ""
+	}
+
+	imageContext, err := tarImageContext(contextIgnore.filter(memFS), cfg.MaxContextFiles)
+	if err != nil {
+		return fmt.Errorf("creating image tar: %w", err)
+	}
+
+	labels := ownerLabels(cfg)
+	labels[contentLabel] = contextDigest(memFS, meta)
+
+	resp, err := cli.ImageBuild(ctx, imageContext, client.ImageBuildOptions{
+		Tags:        []string{imageName},
+		Dockerfile:  "/Dockerfile",
+		Remove:      false,
+		Labels:      labels,
+		Target:      meta.BuildTarget,
+		BuildArgs:   meta.buildArgs(),
+		NetworkMode: networkMode,
+		NoCache:     noCache,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBuildFailed, err)
+	}
+	defer resp.Body.Close()
+
+	// The build only completes once its output stream has been drained.
+	if err := readBuildOutput(resp.Body, output); err != nil {
+		return err
+	}
+
+	// A build can succeed without tagging anything, which would otherwise
+	// only show as a confusing failure to create the container.
+	if _, err := cli.ImageInspect(ctx, imageName); err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("%w: %w: %s", errBuildFailed, errNoImage, imageName)
+		}
+		return fmt.Errorf("inspecting built image: %w", err)
+	}
+	return nil
+}
+
+// buildMessage is a line of the daemon's JSON build output: either a chunk
+// of the build's text, or the status of a layer being pulled.
+type buildMessage struct {
+	Stream   string `json:"stream"`
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Progress string `json:"progress"`
+	Error    string `json:"error"`
+}
+
+// BuildEvent reports a build step's output, or a pull's progress, while the
+// image builds.
+type BuildEvent struct {
+	Message  string `json:"message,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Progress string `json:"progress,omitempty"`
+}
+
+// buildReporter is implemented by build outputs that also want each
+// message as a BuildEvent.
+type buildReporter interface {
+	BuildProgress(BuildEvent)
+}
+
+// progressOutput writes build output to a log and reports it to a callback.
+type progressOutput struct {
+	io.Writer
+	report func(BuildEvent)
+}
+
+func (o progressOutput) BuildProgress(event BuildEvent) {
+	o.report(event)
+}
+
+// readBuildOutput copies the build's text to output and returns a
+// buildFailure, with any compiler diagnostics, if the build reported an
+// error. An output that is a buildReporter is sent every message.
+func readBuildOutput(body io.Reader, output io.Writer) error {
+	reporter, _ := output.(buildReporter)
+	text := newCappedBuffer(maxArtifactLog)
+	decoder := json.NewDecoder(body)
+	for {
+		message := buildMessage{}
+		err := decoder.Decode(&message)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: reading build output: %w", errBuildFailed, err)
+		}
+
+		io.WriteString(output, message.Stream)
+		text.WriteString(message.Stream)
+		if reporter != nil {
+			event := BuildEvent{Message: strings.TrimSpace(message.Stream), ID: message.ID, Status: message.Status, Progress: message.Progress}
+			if event != (BuildEvent{}) {
+				reporter.BuildProgress(event)
+			}
+		}
+		if message.Error != "" {
+			io.WriteString(output, message.Error+"\n")
+			return &buildFailure{message: message.Error, diagnostics: parseDiagnostics(text.String())}
+		}
+	}
+}
+
+// ensureBaseImage builds the task's base image from its packaged starter code
+// unless a fresh one already exists, reporting whether it did. Build output
+// goes to output, and builds take a slot from builds. With noCache the image
+// is rebuilt from scratch even if it is fresh. Submissions are later copied
+// over the starter code.
+func ensureBaseImage(ctx context.Context, cfg *Config, cli *client.Client, task string, meta Metadata, output io.Writer, builds *limiter, noCache bool) (string, bool, error) {
+	imageName := baseImageName(cfg, task)
+
+	lock, _ := baseImageLocks.LoadOrStore(task, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	baseCode, err := files.ReadFile(fmt.Sprintf("tests/%s/code.ts", task))
+	if err != nil {
+		return "", false, fmt.Errorf("reading base code: %w", err)
+	}
+
+	memFS, err := createFS(task, string(baseCode))
+	if err != nil {
+		return "", false, err
+	}
+
+	inspect, err := cli.ImageInspect(ctx, imageName)
+	if noCache {
+		slog.Info("rebuilding image without cache", "image", imageName)
+	} else if err == nil {
+		stale := staleImage(cfg, inspect, contextDigest(memFS, meta))
+		if stale == "" {
+			return imageName, true, nil
+		}
+		slog.Info("rebuilding stale image", "image", imageName, "reason", stale)
+	} else if !cerrdefs.IsNotFound(err) {
+		return "", false, fmt.Errorf("inspecting base image: %w", err)
+	}
+
+	err = buildWithSlot(ctx, builds, func() error {
+		slog.Info("building image", "image", imageName)
+		return buildImage(ctx, cfg, cli, imageName, meta, memFS, output, noCache)
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	return imageName, false, nil
+}
+
+// buildWithSlot runs build while holding a slot from builds.
+func buildWithSlot(ctx context.Context, builds *limiter, build func() error) error {
+	if err := builds.Acquire(ctx); err != nil {
+		return fmt.Errorf("%w: waiting for a build slot: %w", errNoSlot, err)
+	}
+	defer builds.Release()
+
+	return build()
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/moby/moby/api/types/image"
+)
+
+// tarNames lists the entries of a tar archive.
+func tarNames(t *testing.T, archive io.Reader) []string {
+	t.Helper()
+
+	names := []string{}
+	reader := tar.NewReader(archive)
+	for {
+		hdr, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func manyFiles(n int) fstest.MapFS {
+	memFS := fstest.MapFS{}
+	for i := range n {
+		memFS[fmt.Sprintf("file%03d.ts", i)] = &fstest.MapFile{Data: []byte("x"), Mode: 0644}
+	}
+	return memFS
+}
+
+func TestTarImageContextFileLimit(t *testing.T) {
+	archive, err := tarImageContext(manyFiles(5), 5)
+	if err != nil {
+		t.Fatalf("5 files with a limit of 5: %v", err)
+	}
+	if names := tarNames(t, archive); len(names) != 5 {
+		t.Errorf("archive has %d entries, want 5", len(names))
+	}
+
+	_, err = tarImageContext(manyFiles(6), 5)
+	if !errors.Is(err, errTooManyFiles) {
+		t.Fatalf("6 files with a limit of 5: err = %v, want errTooManyFiles", err)
+	}
+	if status, code := classifyError(err); status != http.StatusRequestEntityTooLarge || code != codeContextTooLarge {
+		t.Errorf("classified as %d %s, want 413 %s", status, code, codeContextTooLarge)
+	}
+}
+
+func TestTarImageContextCountsDirectories(t *testing.T) {
+	memFS := fstest.MapFS{
+		"a/b/c/code.ts": &fstest.MapFile{Data: []byte("x"), Mode: 0644},
+	}
+
+	// a, a/b, a/b/c and the file itself.
+	if _, err := tarImageContext(memFS, 3); !errors.Is(err, errTooManyFiles) {
+		t.Errorf("err = %v, want errTooManyFiles", err)
+	}
+	if _, err := tarImageContext(memFS, 4); err != nil {
+		t.Errorf("err = %v with room for every entry", err)
+	}
+}
+
+func TestBuildImagePassesBuildArgs(t *testing.T) {
+	docker := newFakeDocker(t)
+	cfg := testConfig(t, nil)
+	meta := Metadata{BuildArgs: map[string]string{"DENO_VERSION": "2.1.4"}}
+
+	memFS, err := createFS("sum", "export const sum = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := buildImage(context.Background(), cfg, docker.client, "built", meta, memFS, io.Discard, false); err != nil {
+		t.Fatalf("building: %v", err)
+	}
+
+	builds := docker.Builds()
+	if len(builds) != 1 {
+		t.Fatalf("%d builds ran, want 1", len(builds))
+	}
+	if value := builds[0].BuildArgs["DENO_VERSION"]; value == nil || *value != "2.1.4" {
+		t.Errorf("build got DENO_VERSION %v, want 2.1.4", value)
+	}
+	// The arg is part of the image's content, so changing it rebuilds.
+	if builds[0].Labels[contentLabel] != contextDigest(memFS, meta) || contextDigest(memFS, meta) == contextDigest(memFS, Metadata{}) {
+		t.Errorf("content label %q doesn't cover the build args", builds[0].Labels[contentLabel])
+	}
+}
+
+func TestBaseImageNamePrefix(t *testing.T) {
+	if name := baseImageName(testConfig(t, nil), "sum"); name != "gitblame-base/sum" {
+		t.Errorf("base image is %s, want gitblame-base/sum", name)
+	}
+	if name := baseImageName(testConfig(t, map[string]string{"IMAGE_PREFIX": "staging"}), "sum"); name != "staging/gitblame-base/sum" {
+		t.Errorf("prefixed base image is %s, want staging/gitblame-base/sum", name)
+	}
+}
+
+func TestCheckDockerfiles(t *testing.T) {
+	if err := checkDockerfiles(files); err != nil {
+		t.Fatalf("embedded files: %v", err)
+	}
+
+	missing := fstest.MapFS{
+		"image/Dockerfile": &fstest.MapFile{Data: []byte("FROM deno\n")},
+	}
+	err := checkDockerfiles(missing)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("without Dockerfile.composite got %v, want fs.ErrNotExist", err)
+	}
+	if !strings.Contains(err.Error(), "image/Dockerfile.composite") {
+		t.Errorf("error %q doesn't name the missing file", err)
+	}
+}
+
+func TestCreateFSUnknownTask(t *testing.T) {
+	if _, err := createFS("no-such-task", "export {}"); err == nil {
+		t.Fatal("createFS for a task with no test file succeeded")
+	}
+
+	memFS, err := createFS("sum", "export {}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Dockerfile", "code.ts", "test.ts"} {
+		if memFS[name] == nil {
+			t.Errorf("build context for sum is missing %s", name)
+		}
+	}
+}
+
+func TestStaleImage(t *testing.T) {
+	aged := func(labels map[string]string, age time.Duration) image.InspectResponse {
+		inspect := fakeImage(labels)
+		inspect.Created = time.Now().Add(-age).UTC().Format(time.RFC3339Nano)
+		return inspect
+	}
+	current := map[string]string{contentLabel: "digest"}
+	tests := []struct {
+		name    string
+		maxAge  string
+		inspect image.InspectResponse
+		stale   bool
+	}{
+		{"fresh", "0", aged(current, 1000*time.Hour), false},
+		{"within max age", "24h", aged(current, time.Hour), false},
+		{"older than max age", "24h", aged(current, 25*time.Hour), true},
+		{"content changed", "0", aged(map[string]string{contentLabel: "other"}, 0), true},
+		{"no content label", "0", aged(nil, 0), true},
+		{"no config", "0", image.InspectResponse{}, true},
+		{"unknown creation time", "24h", func() image.InspectResponse {
+			inspect := fakeImage(current)
+			inspect.Created = ""
+			return inspect
+		}(), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, map[string]string{"MAX_IMAGE_AGE": test.maxAge})
+			reason := staleImage(cfg, test.inspect, "digest")
+			if (reason != "") != test.stale {
+				t.Errorf("staleImage = %q, want stale %v", reason, test.stale)
+			}
+		})
+	}
+}
+
+func TestEnsureBaseImageRebuildsStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxAge  string
+		prepare func(d *fakeDocker, cfg *Config)
+		rebuild bool
+	}{
+		{"fresh", "0", func(d *fakeDocker, cfg *Config) { d.withImage(cfg, "sum") }, false},
+		{"missing", "0", func(d *fakeDocker, cfg *Config) {}, true},
+		{"test changed", "0", func(d *fakeDocker, cfg *Config) {
+			d.images[baseImageName(cfg, "sum")] = fakeImage(map[string]string{contentLabel: "built from an older test.ts"})
+		}, true},
+		{"too old", "1h", func(d *fakeDocker, cfg *Config) {
+			d.withImage(cfg, "sum")
+			inspect := d.images[baseImageName(cfg, "sum")]
+			inspect.Created = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339Nano)
+			d.images[baseImageName(cfg, "sum")] = inspect
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, map[string]string{"MAX_IMAGE_AGE": test.maxAge})
+			docker := newFakeDocker(t)
+			test.prepare(docker, cfg)
+			meta, err := loadMetadata("sum")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			name, cacheHit, err := ensureBaseImage(context.Background(), cfg, docker.client, "sum", meta, io.Discard, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != baseImageName(cfg, "sum") || cacheHit == test.rebuild {
+				t.Errorf("got %s with cache hit %v, want %s with cache hit %v", name, cacheHit, baseImageName(cfg, "sum"), !test.rebuild)
+			}
+			builds := docker.Builds()
+			if !test.rebuild {
+				if len(builds) != 0 {
+					t.Errorf("fresh image was rebuilt %d times", len(builds))
+				}
+				return
+			}
+			if len(builds) != 1 {
+				t.Fatalf("got %d builds, want 1", len(builds))
+			}
+			memFS, err := createFS("sum", string(builds[0].Files["code.ts"]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := builds[0].Labels[contentLabel], contextDigest(memFS, meta); got != want {
+				t.Errorf("rebuilt image's content label is %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// A build that succeeds without tagging an image fails there, rather than
+// when the run tries to create a container from it.
+func TestBuildImageProducesNoImage(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.buildNoImage = true
+	cfg := testConfig(t, nil)
+
+	memFS, err := createFS("sum", "export const sum = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = buildImage(context.Background(), cfg, docker.client, "built", Metadata{}, memFS, io.Discard, false)
+	if !errors.Is(err, errNoImage) || !errors.Is(err, errBuildFailed) {
+		t.Fatalf("build without an image returned %v, want %v", err, errNoImage)
+	}
+	if status, code := classifyError(err); status != http.StatusUnprocessableEntity || code != codeBuildFailed {
+		t.Errorf("error classified as %d %s, want 422 %s", status, code, codeBuildFailed)
+	}
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	if _, err := executeCodeTest(context.Background(), cfg, docker.client, req); !errors.Is(err, errNoImage) {
+		t.Errorf("run whose base image build produced nothing returned %v, want %v", err, errNoImage)
+	}
+	if containers := docker.Containers(); len(containers) != 0 {
+		t.Errorf("%d containers created from a missing image", len(containers))
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobError   = "error"
+)
+
+// Job is an asynchronously submitted run, as returned by GET /results/{id}.
+type Job struct {
+	ID   string `json:"id"`
+	Task string `json:"task"`
+	User string `json:"user"`
+	// CodeHash is the submission's codeHash.
+	CodeHash string `json:"codeHash"`
+	// Status is one of "queued", "running", "done" or "error".
+	Status   string     `json:"status"`
+	Result   *RunResult `json:"result,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// artifacts are kept for the job's bundle when KEEP_ARTIFACTS is set.
+	artifacts *runArtifacts
+}
+
+// resultStore keeps jobs in memory, dropping the oldest once it holds
+// capacity of them. Synchronous runs are recorded as finished jobs too.
+type resultStore struct {
+	mu       sync.Mutex
+	capacity int
+	jobs     map[string]*Job
+	order    []string
+	// version counts changes, so derived data can tell when it's stale.
+	version uint64
+	// chains holds each user's newest submissions, at most capacity of
+	// them, linked as jobs finish. They outlive the jobs they record, but
+	// only chainUsers users keep one: chainOrder lists them least recently
+	// extended first, and the first is dropped to make room.
+	chains     map[string][]ChainLink
+	chainUsers int
+	chainOrder []string
+}
+
+func newResultStore(capacity int, chainUsers int) *resultStore {
+	return &resultStore{capacity: capacity, jobs: map[string]*Job{}, chains: map[string][]ChainLink{}, chainUsers: chainUsers}
+}
+
+// link appends a job that just finished to its user's chain. The caller
+// holds s.mu.
+func (s *resultStore) link(job *Job) {
+	chain := s.chains[job.User]
+	var prev *ChainLink
+	if len(chain) > 0 {
+		prev = &chain[len(chain)-1]
+	}
+	chain = append(chain, newChainLink(prev, job))
+	if len(chain) > s.capacity {
+		chain = slices.Clone(chain[len(chain)-s.capacity:])
+	}
+	s.chains[job.User] = chain
+
+	if i := slices.Index(s.chainOrder, job.User); i >= 0 {
+		s.chainOrder = slices.Delete(s.chainOrder, i, i+1)
+	}
+	s.chainOrder = append(s.chainOrder, job.User)
+	for len(s.chainOrder) > s.chainUsers {
+		delete(s.chains, s.chainOrder[0])
+		s.chainOrder = s.chainOrder[1:]
+	}
+}
+
+// Chain returns a copy of the user's submission chain, oldest first.
+func (s *resultStore) Chain(user string) []ChainLink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ChainLink{}, s.chains[user]...)
+}
+
+func (s *resultStore) Put(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; !ok {
+		s.order = append(s.order, job.ID)
+	}
+	s.jobs[job.ID] = job
+	s.version++
+	if job.Finished != nil {
+		s.link(job)
+	}
+
+	for len(s.order) > s.capacity {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Get returns a copy of the job, so callers can read it while it runs.
+func (s *resultStore) Get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (s *resultStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	s.version++
+	s.order = slices.DeleteFunc(s.order, func(other string) bool { return other == id })
+}
+
+func (s *resultStore) update(id string, change func(*Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		finished := job.Finished != nil
+		change(job)
+		s.version++
+		if !finished && job.Finished != nil {
+			s.link(job)
+		}
+	}
+}
+
+// Record stores the outcome of a synchronous run as a finished job, with
+// its artifacts if they were kept.
+func (s *resultStore) Record(req RunRequest, result *RunResult, runErr error, artifacts *runArtifacts) {
+	finished := time.Now().UTC()
+	job := &Job{ID: newRequestID(), Task: req.Task, User: req.User, CodeHash: codeHash(req.Code), Status: jobDone, Created: finished, Finished: &finished}
+	if runErr != nil {
+		job.Status = jobError
+		job.Error = runErr.Error()
+	} else if result != nil {
+		stored := *result
+		job.Result = &stored
+	}
+	job.artifacts = artifacts
+	s.Put(job)
+}
+
+// Recent reports whether a run of the task with the given code hash finished
+// with a result within maxAge.
+func (s *resultStore) Recent(task string, hash string, maxAge time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	for i := len(s.order) - 1; i >= 0; i-- {
+		job := s.jobs[s.order[i]]
+		if job.Task == task && job.CodeHash == hash && job.Result != nil && job.Finished.After(cutoff) {
+			return true
+		}
+	}
+	return false
+}
+
+// Version returns the store's change count.
+func (s *resultStore) Version() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version
+}
+
+// Task returns copies of the task's jobs, oldest first, along with the
+// store's version at the time.
+func (s *resultStore) Task(task string) ([]Job, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []Job{}
+	for _, id := range s.order {
+		if job := s.jobs[id]; job.Task == task {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs, s.version
+}
+
+// ByCodeHash returns copies of the jobs whose submission had the given
+// codeHash, oldest first, whoever submitted them and for whichever task.
+func (s *resultStore) ByCodeHash(hash string) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []Job{}
+	for _, id := range s.order {
+		if job := s.jobs[id]; job.CodeHash == hash {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs
+}
+
+type queuedRun struct {
+	jobID       string
+	requestID   string
+	req         RunRequest
+	meta        Metadata
+	callbackURL string
+	// reserved is the memory held for the run until it finishes.
+	reserved int64
+}
+
+// jobQueue holds async runs until a worker picks them up.
+type jobQueue struct {
+	pending  chan queuedRun
+	store    *resultStore
+	webhooks *webhookSender
+	memory   *memoryGuard
+}
+
+func newJobQueue(size int, store *resultStore, webhooks *webhookSender, memory *memoryGuard) *jobQueue {
+	return &jobQueue{pending: make(chan queuedRun, size), store: store, webhooks: webhooks, memory: memory}
+}
+
+// Len returns how many jobs are waiting for a worker.
+func (q *jobQueue) Len() int {
+	return len(q.pending)
+}
+
+// Capacity returns how many jobs may wait before Enqueue refuses more.
+func (q *jobQueue) Capacity() int {
+	return cap(q.pending)
+}
+
+// ValidateCallback checks a client's callback URL before its run is queued.
+func (q *jobQueue) ValidateCallback(ctx context.Context, callbackURL string) error {
+	return q.webhooks.Validate(ctx, callbackURL)
+}
+
+// Enqueue records a queued job for req and returns it, or false when the
+// queue is full. The finished job is POSTed to callbackURL, if set. The
+// reserved memory is released once the job finishes.
+func (q *jobQueue) Enqueue(requestID string, req RunRequest, meta Metadata, callbackURL string, reserved int64) (Job, bool) {
+	job := &Job{ID: newRequestID(), Task: req.Task, User: req.User, CodeHash: codeHash(req.Code), Status: jobQueued, Created: time.Now().UTC()}
+	q.store.Put(job)
+
+	select {
+	case q.pending <- queuedRun{jobID: job.ID, requestID: requestID, req: req, meta: meta, callbackURL: callbackURL, reserved: reserved}:
+		return *job, true
+	default:
+		q.store.Delete(job.ID)
+		return Job{}, false
+	}
+}
+
+// Start runs workers that execute queued jobs until ctx is done. Jobs take
+// the same build and run slots as synchronous requests.
+func (q *jobQueue) Start(ctx context.Context, cfg *Config, cli *client.Client, builds *limiter, runs *limiter, budget *runtimeBudget, workers int) {
+	for range workers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case item := <-q.pending:
+					q.run(ctx, cfg, cli, builds, runs, budget, item)
+				}
+			}
+		}()
+	}
+}
+
+func (q *jobQueue) run(ctx context.Context, cfg *Config, cli *client.Client, builds *limiter, runs *limiter, budget *runtimeBudget, item queuedRun) {
+	defer q.memory.Release(item.reserved)
+	if item.callbackURL != "" {
+		defer func() {
+			job, _ := q.store.Get(item.jobID)
+			go func() {
+				if err := q.webhooks.Deliver(ctx, item.callbackURL, job); err != nil {
+					slog.Error("delivering job", "job", item.jobID, "callback", item.callbackURL, "error", err)
+				}
+			}()
+		}()
+	}
+
+	fail := func(err error) {
+		slog.Error("running job", "job", item.jobID, "error", err)
+		q.store.update(item.jobID, func(job *Job) {
+			finished := time.Now().UTC()
+			job.Status = jobError
+			job.Error = err.Error()
+			job.Finished = &finished
+		})
+	}
+
+	if taskRuns := taskLimiter(item.req.Task, item.meta); taskRuns != nil {
+		if err := taskRuns.Acquire(ctx); err != nil {
+			fail(err)
+			return
+		}
+		defer taskRuns.Release()
+	}
+	item.req.Builds, item.req.Runs = builds, runs
+
+	q.store.update(item.jobID, func(job *Job) { job.Status = jobRunning })
+
+	summary := newRunSummary(item.requestID, item.req)
+	defer summary.log()
+
+	execution, err := executeCodeTest(contextWithRequestID(ctx, item.requestID), cfg, cli, item.req)
+	if budget != nil && execution != nil {
+		budget.Charge(item.req.User, execution.RunDuration)
+	}
+	artifacts := newRunArtifacts(cfg, item.req, execution)
+	q.store.update(item.jobID, func(job *Job) { job.artifacts = artifacts })
+	if err != nil {
+		summary.record(execution, nil, err)
+		fail(err)
+		return
+	}
+
+	result, err := buildResult(cfg, item.req.Task, execution)
+	result.recordCode(cfg, item.req.Code)
+	summary.record(execution, &result, err)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	q.store.update(item.jobID, func(job *Job) {
+		finished := time.Now().UTC()
+		job.Status = jobDone
+		job.Result = &result
+		job.Finished = &finished
+	})
+}
+
+// asyncRequested reports whether the client asked to run in the background.
+func asyncRequested(r *http.Request) bool {
+	return queryBool(r, "async")
+}
+
+func resultHandler(store *resultStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		job, ok := store.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, r, http.StatusNotFound, codeResultNotFound, "Can't find result "+r.PathValue("id"))
+			return
+		}
+
+		resp := marshalResponse(r, job)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
+
+var codeHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// codeResultsHandler serves GET /admin/code/{hash}/results: every stored
+// run of the submission with that codeHash, across users and tasks, oldest
+// first, with each result's taskVersion telling task revisions apart. It is
+// meant for spotting shared solutions, so it sits behind the admin token.
+func codeResultsHandler(store *resultStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.ToLower(r.PathValue("hash"))
+		if !codeHashPattern.MatchString(hash) {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "code hash must be a hex SHA-256")
+			return
+		}
+
+		resp := marshalResponse(r, store.ByCodeHash(hash))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// asyncServer serves the run and result endpoints with runs queued on
+// queue.
+func asyncServer(t *testing.T, cfg *Config, docker *fakeDocker, queue *jobQueue) *httptest.Server {
+	t.Helper()
+
+	router := http.NewServeMux()
+	router.HandleFunc("POST /test/{test}/run", runHandler(cfg, docker.client, nil, nil, nil, queue, nil, queue.store))
+	router.HandleFunc("GET /results/{id}", resultHandler(queue.store))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// submit POSTs code to the task's run endpoint with the given query.
+func submit(t *testing.T, server *httptest.Server, task string, query string, code string) *http.Response {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"user": "alice", "code": code})
+	resp, err := http.Post(server.URL+"/test/"+task+"/run?"+query, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decodeBody decodes a JSON response into v.
+func decodeBody(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+}
+
+func getJob(t *testing.T, server *httptest.Server, id string) Job {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + "/results/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /results/%s responded %d", id, resp.StatusCode)
+	}
+	job := Job{}
+	decodeBody(t, resp, &job)
+	return job
+}
+
+func TestAsyncRun(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t).withImage(cfg, "sum").withReport(junitReport(`<testcase name="adds" classname="sum"/>`))
+	queue := newJobQueue(1, newResultStore(10, 10), newWebhookSender(cfg), nil)
+	server := asyncServer(t, cfg, docker, queue)
+
+	resp := submit(t, server, "sum", "async=1", "export const sum = 1")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("async run responded %d, want 202", resp.StatusCode)
+	}
+	queued := Job{}
+	decodeBody(t, resp, &queued)
+	if queued.ID == "" || queued.Status != jobQueued || resp.Header.Get("Location") != "/results/"+queued.ID {
+		t.Fatalf("async run queued %+v at %q", queued, resp.Header.Get("Location"))
+	}
+
+	// No worker runs yet, so the job stays pending and fills the queue.
+	if job := getJob(t, server, queued.ID); job.Status != jobQueued || job.Result != nil {
+		t.Errorf("pending job is %+v, want it queued without a result", job)
+	}
+	full := submit(t, server, "sum", "async=1", "export const sum = 2")
+	if full.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("run past the queue size responded %d, want 503", full.StatusCode)
+	}
+	apiErr := apiError{}
+	decodeBody(t, full, &apiErr)
+	if apiErr.Code != codeQueueFull {
+		t.Errorf("full queue's error code is %q, want %q", apiErr.Code, codeQueueFull)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx, cfg, docker.client, nil, nil, nil, 1)
+
+	deadline := time.Now().Add(10 * time.Second)
+	job := getJob(t, server, queued.ID)
+	for job.Status == jobQueued || job.Status == jobRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s after 10s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		job = getJob(t, server, queued.ID)
+	}
+	if job.Status != jobDone || job.Result == nil || job.Result.Passed != 1 || job.Finished == nil {
+		t.Errorf("complete job is %+v, want done with the passing result", job)
+	}
+
+	missing, err := http.Get(server.URL + "/results/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job responded %d, want 404", missing.StatusCode)
+	}
+}
+
+func TestCodeResultsHandler(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ADMIN_TOKEN": "secret"})
+	store := newResultStore(100, 100)
+	handler := requireAdmin(cfg, codeResultsHandler(store))
+
+	// The same solution, submitted by two users to two task versions, among
+	// other submissions.
+	shared := "export const sum = (a: number, b: number) => a + b"
+	seeds := []struct {
+		user, code, version string
+	}{
+		{"alice", shared, "v1"},
+		{"bob", "export const sum = () => 0", "v1"},
+		{"carol", shared, "v1"},
+		{"alice", shared, "v2"},
+	}
+	for _, seed := range seeds {
+		result := newRunResult()
+		result.TaskVersion = seed.version
+		result.recordCode(cfg, seed.code)
+		store.Record(RunRequest{Task: "sum", User: seed.user, Code: seed.code}, &result, nil, nil)
+	}
+
+	get := func(hash string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/admin/code/"+hash+"/results", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(t, "GET /admin/code/{hash}/results", handler, r)
+	}
+
+	hash := codeHash(shared)
+	if w := get(hash, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without the token status is %d, want 401", w.Code)
+	}
+	assertAPIError(t, get("not-a-hash", "secret"), http.StatusBadRequest, codeInvalidRequest)
+
+	for _, requested := range []string{hash, strings.ToUpper(hash)} {
+		w := get(requested, "secret")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status is %d, want 200: %s", w.Code, w.Body)
+		}
+		jobs := []Job{}
+		if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, job := range jobs {
+			if job.CodeHash != hash || job.Result == nil {
+				t.Errorf("listed job %+v, want a result for %s", job, hash)
+				continue
+			}
+			got = append(got, job.User+"@"+job.Result.TaskVersion)
+		}
+		if want := []string{"alice@v1", "carol@v1", "alice@v2"}; !slices.Equal(got, want) {
+			t.Errorf("runs of the shared solution are %v, want %v", got, want)
+		}
+	}
+
+	w := get(codeHash("never submitted"), "secret")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("unknown hash responded %d %q, want 200 []", w.Code, w.Body)
+	}
+}
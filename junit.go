@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name string `xml:"name,attr"`
+	// The counts some runners put on the suite, which may cover cases the
+	// report doesn't list.
+	Tests      string          `xml:"tests,attr,omitempty"`
+	Failures   string          `xml:"failures,attr,omitempty"`
+	Errors     string          `xml:"errors,attr,omitempty"`
+	Skipped    string          `xml:"skipped,attr,omitempty"`
+	Assertions string          `xml:"assertions,attr,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string        `xml:"name,attr"`
+	Classname  string        `xml:"classname,attr"`
+	Time       string        `xml:"time,attr,omitempty"`
+	Assertions string        `xml:"assertions,attr,omitempty"`
+	Failure    *junitFailure `xml:"failure"`
+	Error      *junitFailure `xml:"error"`
+	Skipped    *junitFailure `xml:"skipped"`
+	// encoding/xml folds CDATA sections into the element's text.
+	SystemOut string `xml:"system-out,omitempty"`
+	SystemErr string `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+var errInvalidReport = errors.New("invalid JUnit report")
+
+// parseJUnitSuites reads a JUnit XML report whose root is either
+// <testsuites> or a single <testsuite>.
+func parseJUnitSuites(report []byte) ([]junitTestSuite, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(report))
+	var root xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%w: reading report: %w", errInvalidReport, err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+
+	switch root.Name.Local {
+	case "testsuites":
+		doc := junitTestSuites{}
+		if err := decoder.DecodeElement(&doc, &root); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidReport, err)
+		}
+		return doc.Suites, nil
+	case "testsuite":
+		suite := junitTestSuite{}
+		if err := decoder.DecodeElement(&suite, &root); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidReport, err)
+		}
+		return []junitTestSuite{suite}, nil
+	default:
+		return nil, fmt.Errorf("%w: unexpected root <%s>", errInvalidReport, root.Name.Local)
+	}
+}
+
+// mergeJUnitSuites concatenates suites from several reports, renaming
+// suites whose name was already seen so each stays distinguishable.
+func mergeJUnitSuites(reports ...[]junitTestSuite) []junitTestSuite {
+	merged := []junitTestSuite{}
+	seen := map[string]int{}
+
+	for _, suites := range reports {
+		for _, suite := range suites {
+			seen[suite.Name]++
+			if count := seen[suite.Name]; count > 1 {
+				suite.Name = fmt.Sprintf("%s (%d)", suite.Name, count)
+			}
+			merged = append(merged, suite)
+		}
+	}
+
+	return merged
+}
+
+// parseJUnitTime reads a JUnit time attribute, in seconds. Some runners
+// format it for the locale, with a decimal comma or thousands separators.
+func parseJUnitTime(value string) float64 {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.Contains(value, ".") && strings.Contains(value, ","):
+		value = strings.ReplaceAll(value, ",", "")
+	case strings.Contains(value, ","):
+		value = strings.ReplaceAll(value, ",", ".")
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0
+	}
+	return seconds
+}
+
+// parseJUnit converts a JUnit report into a result listing at most maxCases
+// cases, or all of them when maxCases is zero. The counts always cover every
+// case in the report.
+func parseJUnit(report []byte, maxCases int) (RunResult, error) {
+	suites, err := parseJUnitSuites(report)
+	if err != nil {
+		return newRunResult(), err
+	}
+
+	return resultFromSuites(suites, maxCases), nil
+}
+
+func resultFromSuites(suites []junitTestSuite, maxCases int) RunResult {
+	result := newRunResult()
+
+	for _, suite := range suites {
+		listed := result
+		assertions := 0
+		for _, c := range suite.Cases {
+			assertions += junitCount(c.Assertions)
+			testCase := TestCase{
+				Name:      c.Name,
+				Suite:     c.Classname,
+				Status:    StatusPassed,
+				Duration:  parseJUnitTime(c.Time),
+				SystemOut: c.SystemOut,
+				SystemErr: c.SystemErr,
+			}
+			if testCase.Suite == "" {
+				testCase.Suite = suite.Name
+			}
+
+			switch {
+			case c.Failure != nil:
+				testCase.Status = StatusFailed
+				testCase.Message = c.Failure.Message
+				testCase.Details = c.Failure.Body
+			case c.Error != nil:
+				testCase.Status = StatusError
+				testCase.Message = c.Error.Message
+				testCase.Details = c.Error.Body
+			case c.Skipped != nil:
+				testCase.Status = StatusSkipped
+				testCase.Message = c.Skipped.Message
+			}
+
+			result.addCase(testCase, maxCases)
+		}
+
+		if suite.Assertions != "" {
+			assertions = junitCount(suite.Assertions)
+		}
+		result.Assertions += assertions
+		reconcileSuiteCounts(&result, listed, suite)
+	}
+
+	return result
+}
+
+// junitCount reads a count attribute, treating anything but a
+// non-negative integer as zero.
+func junitCount(value string) int {
+	count, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || count < 0 {
+		return 0
+	}
+	return count
+}
+
+// reconcileSuiteCounts adds the cases a suite's tests attribute reports
+// beyond those it lists, as the suite's failures, errors and skipped
+// attributes break them down; the rest count as passed. before is the
+// result as it was before the suite's cases were added.
+func reconcileSuiteCounts(result *RunResult, before RunResult, suite junitTestSuite) {
+	extra := junitCount(suite.Tests) - (result.Total - before.Total)
+	if extra <= 0 {
+		return
+	}
+
+	failed := junitCount(suite.Failures) + junitCount(suite.Errors) - (result.Failed - before.Failed)
+	failed = min(max(failed, 0), extra)
+	skipped := junitCount(suite.Skipped) - (result.Skipped - before.Skipped)
+	skipped = min(max(skipped, 0), extra-failed)
+
+	result.Total += extra
+	result.Failed += failed
+	result.Skipped += skipped
+	result.Passed += extra - failed - skipped
+}
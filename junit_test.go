@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// manyCases returns n passing testcase elements.
+func manyCases(n int) []string {
+	cases := make([]string, n)
+	for i := range n {
+		cases[i] = fmt.Sprintf(`<testcase name="case %d" classname="sum"/>`, i)
+	}
+	return cases
+}
+
+func TestParseJUnitCaseCap(t *testing.T) {
+	report := []byte(junitReport(append(manyCases(4), `<testcase name="last" classname="sum"><failure message="boom"/></testcase>`)...))
+
+	result, err := parseJUnit(report, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Cases) != 3 || !result.Truncated {
+		t.Errorf("listed %d cases, truncated %v; want 3 and truncated", len(result.Cases), result.Truncated)
+	}
+	// The counts cover the cases past the cap, including the failure.
+	if result.Total != 5 || result.Passed != 4 || result.Failed != 1 {
+		t.Errorf("counts are %d passed, %d failed of %d; want 4, 1 of 5", result.Passed, result.Failed, result.Total)
+	}
+
+	result, err = parseJUnit(report, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Cases) != 5 || result.Truncated {
+		t.Errorf("without a cap listed %d cases, truncated %v; want all 5", len(result.Cases), result.Truncated)
+	}
+}
+
+func TestParseJUnitSkipped(t *testing.T) {
+	report := []byte(junitReport(
+		`<testcase name="adds" classname="sum"/>`,
+		`<testcase name="adds floats" classname="sum"><skipped message="not supported yet"/></testcase>`,
+		`<testcase name="adds bigints" classname="sum"><skipped/></testcase>`,
+		`<testcase name="adds negatives" classname="sum"><failure message="expected -3"/></testcase>`,
+	))
+
+	result, err := parseJUnit(report, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Passed != 1 || result.Failed != 1 || result.Skipped != 2 || result.Total != 4 {
+		t.Errorf("counts are %d passed, %d failed, %d skipped of %d; want 1, 1, 2 of 4", result.Passed, result.Failed, result.Skipped, result.Total)
+	}
+	if result.SchemaVersion != "2.0.0" {
+		t.Errorf("schema version is %s, want 2.0.0 for the skipped count", result.SchemaVersion)
+	}
+
+	skipped := result.Cases[1]
+	if skipped.Status != StatusSkipped || skipped.Message != "not supported yet" {
+		t.Errorf("skipped case is %+v, want status skipped with its reason", skipped)
+	}
+	if result.Cases[2].Status != StatusSkipped || result.Cases[2].Message != "" {
+		t.Errorf("skipped case without a reason is %+v", result.Cases[2])
+	}
+	if summary := summarize(result); summary.Status != StatusFailed || summary.Skipped != 2 {
+		t.Errorf("summary is %+v", summary)
+	}
+}
+
+func TestParseJUnitTime(t *testing.T) {
+	tests := map[string]float64{
+		"0.25":     0.25,
+		" 1.5 ":    1.5,
+		"2":        2,
+		"0,125":    0.125,
+		"1,234.5":  1234.5,
+		"":         0,
+		"-1":       0,
+		"NaN":      0,
+		"Inf":      0,
+		"a second": 0,
+	}
+	for value, want := range tests {
+		if got := parseJUnitTime(value); got != want {
+			t.Errorf("parseJUnitTime(%q) = %g, want %g", value, got, want)
+		}
+	}
+}
+
+func TestParseJUnitDurations(t *testing.T) {
+	report := []byte(junitReport(
+		`<testcase name="fast" classname="sum" time="0.25"/>`,
+		`<testcase name="slow" classname="sum" time="1,5"/>`,
+		`<testcase name="untimed" classname="sum"/>`,
+		`<testcase name="dropped" classname="sum" time="2"/>`,
+	))
+
+	result, err := parseJUnit(report, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{0.25, 1.5, 0} {
+		if got := result.Cases[i].Duration; got != want {
+			t.Errorf("case %s took %g, want %g", result.Cases[i].Name, got, want)
+		}
+	}
+	// The case past the cap still counts towards the total duration.
+	if result.Duration != 3.75 {
+		t.Errorf("total duration is %g, want 3.75", result.Duration)
+	}
+}
+
+func TestParseJUnitReconcilesSuiteCounts(t *testing.T) {
+	const (
+		pass = `<testcase name="adds"/>`
+		fail = `<testcase name="subtracts"><failure message="expected 1"/></testcase>`
+	)
+	suite := func(attrs string, cases ...string) string {
+		return `<testsuite name="test.ts" ` + attrs + `>` + strings.Join(cases, "") + `</testsuite>`
+	}
+	tests := []struct {
+		name   string
+		suites []string
+		// want is total, passed, failed and skipped.
+		want [4]int
+	}{
+		{"attributes match the cases", []string{suite(`tests="2" failures="1"`, pass, fail)}, [4]int{2, 1, 1, 0}},
+		{"unlisted passes", []string{suite(`tests="4"`, pass, fail)}, [4]int{4, 3, 1, 0}},
+		{"unlisted failures", []string{suite(`tests="5" failures="2"`, pass, pass)}, [4]int{5, 3, 2, 0}},
+		{"unlisted errors and skips", []string{suite(`tests="4" errors="1" skipped="1"`, pass, fail)}, [4]int{4, 2, 1, 1}},
+		{"fewer tests than listed", []string{suite(`tests="1" failures="0"`, pass, pass, fail)}, [4]int{3, 2, 1, 0}},
+		{"more failures than unlisted cases", []string{suite(`tests="3" failures="5"`, pass, pass)}, [4]int{3, 2, 1, 0}},
+		{"unparseable attributes", []string{suite(`tests="many" failures="-1"`, pass)}, [4]int{1, 1, 0, 0}},
+		{"suite without cases", []string{suite(`tests="2" failures="2"`)}, [4]int{2, 0, 2, 0}},
+		{"each suite on its own", []string{
+			suite(`tests="3" failures="1"`, pass),
+			suite(``, pass, fail),
+		}, [4]int{5, 3, 2, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := `<testsuites>` + strings.Join(tt.suites, "") + `</testsuites>`
+			result, err := parseJUnit([]byte(report), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := [4]int{result.Total, result.Passed, result.Failed, result.Skipped}
+			if got != tt.want {
+				t.Errorf("total, passed, failed, skipped = %v, want %v", got, tt.want)
+			}
+			if result.Truncated {
+				t.Error("reconciled result is marked truncated")
+			}
+		})
+	}
+}
+
+func TestParseJUnitAssertions(t *testing.T) {
+	report := `<testsuites>` +
+		`<testsuite name="cases"><testcase name="a" assertions="2"/><testcase name="b" assertions="3"/></testsuite>` +
+		`<testsuite name="suite" assertions="7"><testcase name="c" assertions="1"/></testsuite>` +
+		`</testsuites>`
+	result, err := parseJUnit([]byte(report), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A suite's own attribute wins over its cases'.
+	if result.Assertions != 12 {
+		t.Errorf("assertions = %d, want 12", result.Assertions)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// limiter caps the number of concurrent runs or builds. Callers beyond the
+// cap wait for a slot until their context is done. A nil limiter never
+// blocks.
+type limiter struct {
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+func newLimiter(capacity int) *limiter {
+	return &limiter{slots: make(chan struct{}, capacity)}
+}
+
+var errNoSlot = errors.New("no slot free")
+
+func (l *limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes a slot only if one is free right now.
+func (l *limiter) TryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *limiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+func (l *limiter) Running() int {
+	return len(l.slots)
+}
+
+func (l *limiter) Capacity() int {
+	return cap(l.slots)
+}
+
+func (l *limiter) Waiting() int {
+	return int(l.waiting.Load())
+}
+
+var taskLimiters sync.Map
+
+// taskLimiter returns the task's own limiter, created on first use, or nil
+// when the task has no limit of its own.
+func taskLimiter(task string, meta Metadata) *limiter {
+	if meta.MaxConcurrentRuns <= 0 {
+		return nil
+	}
+
+	l, _ := taskLimiters.LoadOrStore(task, newLimiter(meta.MaxConcurrentRuns))
+	return l.(*limiter)
+}
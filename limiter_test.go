@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestTaskLimiterBelowGlobalLimit(t *testing.T) {
+	global := newLimiter(3)
+	heavy := taskLimiter("test-heavy", Metadata{MaxConcurrentRuns: 1})
+	if heavy == nil || heavy.Capacity() != 1 {
+		t.Fatalf("task limiter is %v, want one of capacity 1", heavy)
+	}
+	if again := taskLimiter("test-heavy", Metadata{MaxConcurrentRuns: 1}); again != heavy {
+		t.Error("second lookup created a new task limiter")
+	}
+	if light := taskLimiter("test-light", Metadata{}); light != nil {
+		t.Errorf("task without a limit has limiter %v", light)
+	}
+
+	// The handler takes the task's slot before the global one.
+	acquire := func(task *limiter) bool {
+		if !task.TryAcquire() {
+			return false
+		}
+		if !global.TryAcquire() {
+			task.Release()
+			return false
+		}
+		return true
+	}
+	if !acquire(heavy) {
+		t.Fatal("first heavy run was refused")
+	}
+	if acquire(heavy) {
+		t.Fatal("second heavy run was admitted past the task limit")
+	}
+	if global.Running() != 1 {
+		t.Errorf("%d global slots are taken, want 1", global.Running())
+	}
+	for i := range 2 {
+		if !acquire(nil) {
+			t.Fatalf("run %d of a task without a limit was refused with global slots free", i+1)
+		}
+	}
+	if acquire(nil) {
+		t.Error("run was admitted past the global limit")
+	}
+
+	heavy.Release()
+	global.Release()
+	if !acquire(heavy) {
+		t.Error("heavy run was refused after the first one finished")
+	}
+}
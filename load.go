@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	queueLengthHeader  = "X-Queue-Length"
+	runningCountHeader = "X-Running-Count"
+)
+
+// serverLoad is how busy the server is, for clients that throttle
+// themselves.
+type serverLoad struct {
+	// Queued counts async jobs waiting for a worker and requests waiting
+	// for a run slot.
+	Queued        int `json:"queued"`
+	QueueCapacity int `json:"queueCapacity"`
+	// Running counts the runs holding a slot.
+	Running     int `json:"running"`
+	RunCapacity int `json:"runCapacity"`
+}
+
+func currentLoad(runs *limiter, jobs *jobQueue) serverLoad {
+	return serverLoad{
+		Queued:        jobs.Len() + runs.Waiting(),
+		QueueCapacity: jobs.Capacity(),
+		Running:       runs.Running(),
+		RunCapacity:   runs.Capacity(),
+	}
+}
+
+// withLoadHeaders adds the server's load, as it was when the request
+// arrived, to every response.
+func withLoadHeaders(runs *limiter, jobs *jobQueue, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		load := currentLoad(runs, jobs)
+		w.Header().Set(queueLengthHeader, strconv.Itoa(load.Queued))
+		w.Header().Set(runningCountHeader, strconv.Itoa(load.Running))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadHandler serves GET /load.
+func loadHandler(runs *limiter, jobs *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(marshalResponse(r, currentLoad(runs, jobs)))
+	}
+}
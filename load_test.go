@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadHeaders(t *testing.T) {
+	runs := newLimiter(2)
+	jobs := newJobQueue(3, newResultStore(10, 10), nil, nil)
+	handler := withLoadHeaders(runs, jobs, loadHandler(runs, jobs))
+
+	get := func() (*httptest.ResponseRecorder, serverLoad) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/load", nil))
+		load := serverLoad{}
+		if err := json.Unmarshal(w.Body.Bytes(), &load); err != nil {
+			t.Fatalf("decoding load %q: %v", w.Body, err)
+		}
+		return w, load
+	}
+
+	w, load := get()
+	if w.Header().Get(queueLengthHeader) != "0" || w.Header().Get(runningCountHeader) != "0" {
+		t.Errorf("idle server sent %s %q and %s %q, want 0 and 0", queueLengthHeader, w.Header().Get(queueLengthHeader), runningCountHeader, w.Header().Get(runningCountHeader))
+	}
+	if load != (serverLoad{QueueCapacity: 3, RunCapacity: 2}) {
+		t.Errorf("idle load is %+v", load)
+	}
+
+	// Fill both run slots, leave a request waiting for one, and queue two
+	// jobs with no worker to take them.
+	for range 2 {
+		if !runs.TryAcquire() {
+			t.Fatal("run slot taken")
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	waiter := make(chan error)
+	go func() { waiter <- runs.Acquire(ctx) }()
+	defer func() {
+		cancel()
+		<-waiter
+		runs.Release()
+		runs.Release()
+	}()
+	for runs.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for range 2 {
+		if _, ok := jobs.Enqueue("", RunRequest{Task: "sum", User: "alice"}, Metadata{}, "", 0); !ok {
+			t.Fatal("queue is full")
+		}
+	}
+
+	w, load = get()
+	if w.Header().Get(queueLengthHeader) != "3" || w.Header().Get(runningCountHeader) != "2" {
+		t.Errorf("busy server sent %s %q and %s %q, want 3 and 2", queueLengthHeader, w.Header().Get(queueLengthHeader), runningCountHeader, w.Header().Get(runningCountHeader))
+	}
+	if load != (serverLoad{Queued: 3, QueueCapacity: 3, Running: 2, RunCapacity: 2}) {
+		t.Errorf("busy load is %+v", load)
+	}
+	if w.Header().Get("Content-Type") != "application/json" || w.Code != http.StatusOK {
+		t.Errorf("GET /load responded %d with Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
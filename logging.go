@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogHandler returns the slog handler for the configured LOG_FORMAT,
+// writing to w.
+func newLogHandler(cfg *Config, w io.Writer) slog.Handler {
+	options := &slog.HandlerOptions{AddSource: cfg.LogAddSource}
+	if cfg.LogFormat == logFormatJSON {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+// setupLogging installs the configured handler as the default logger. The
+// standard log package, which net/http uses for its errors, writes through
+// it as well.
+func setupLogging(cfg *Config, w io.Writer) {
+	slog.SetDefault(slog.New(newLogHandler(cfg, w)))
+}
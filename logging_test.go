@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		json   bool
+		source bool
+	}{
+		{"default", nil, false, false},
+		{"text", map[string]string{"LOG_FORMAT": "text"}, false, false},
+		{"json", map[string]string{"LOG_FORMAT": "json"}, true, false},
+		{"text with source", map[string]string{"LOG_ADD_SOURCE": "true"}, false, true},
+		{"json with source", map[string]string{"LOG_FORMAT": "json", "LOG_ADD_SOURCE": "true"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			slog.New(newLogHandler(testConfig(t, tt.env), out)).Info("building image", "image", "gitblame-base/sum")
+			line := out.String()
+
+			entry := map[string]any{}
+			isJSON := json.Unmarshal([]byte(line), &entry) == nil
+			if isJSON != tt.json {
+				t.Fatalf("line %q: JSON is %v, want %v", line, isJSON, tt.json)
+			}
+			if tt.json {
+				if entry["msg"] != "building image" || entry["image"] != "gitblame-base/sum" {
+					t.Errorf("entry is %v, want the message and image attribute", entry)
+				}
+				if _, ok := entry["source"]; ok != tt.source {
+					t.Errorf("entry %v: source is present %v, want %v", entry, ok, tt.source)
+				}
+				return
+			}
+			if !strings.Contains(line, `msg="building image" image=gitblame-base/sum`) {
+				t.Errorf("text line is %q", line)
+			}
+			if got := strings.Contains(line, "source=") && strings.Contains(line, "logging_test.go:"); got != tt.source {
+				t.Errorf("text line %q: source is present %v, want %v", line, got, tt.source)
+			}
+		})
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	out := &bytes.Buffer{}
+	setupLogging(testConfig(t, map[string]string{"LOG_FORMAT": "json"}), out)
+
+	// The server's own log calls and net/http's, through the standard log
+	// package, both come out in the configured format.
+	if err := verifyTestFile("sum", []byte("tampered")); err == nil {
+		t.Fatal("tampered test file verified")
+	}
+	log.Print("http: TLS handshake error")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines %q, want 2", len(lines), lines)
+	}
+	entries := make([]map[string]any, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+			t.Fatalf("line %q isn't JSON: %v", line, err)
+		}
+	}
+	if entries[0]["level"] != "ERROR" || entries[0]["task"] != "sum" || entries[0]["expected"] != testChecksums["sum"] {
+		t.Errorf("checksum mismatch logged %v", entries[0])
+	}
+	if entries[1]["msg"] != "http: TLS handshake error" {
+		t.Errorf("standard log line logged %v", entries[1])
+	}
+}
@@ -1,28 +1,31 @@
 package main
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
 	"embed"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"io/fs"
+	"log/slog"
 	"net/http"
-	"testing/fstest"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/client"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-//go:embed image/* tests/**/test.ts tests/**/README.md tests/**/code.ts
+//go:embed image/* seccomp tests
 var files embed.FS
 
 type Code struct {
 	User string `json:"user"`
 	Code string `json:"code"`
+	// Patch, instead of Code, is a unified diff against the task's packaged
+	// code.ts that the server applies to get the submission.
+	Patch string `json:"patch,omitempty"`
+	// CallbackURL, for async runs, receives the finished job.
+	CallbackURL string `json:"callbackUrl,omitempty"`
 }
 
 type Test struct {
@@ -31,208 +34,146 @@ type Test struct {
 	Desc string `json:"desc"`
 }
 
-func createFS(task string, code string) fstest.MapFS {
-	memFS := fstest.MapFS{
-		"code.ts": &fstest.MapFile{Data: []byte(code), Mode: 0644},
-	}
-
-	dockerfile, err := files.ReadFile("image/Dockerfile")
+func main() {
+	cfg, err := loadConfig(os.Getenv)
 	if err != nil {
 		panic(err)
 	}
+	setupLogging(cfg, os.Stderr)
+	if cfg.RunLogDriver == "none" {
+		slog.Warn("RUN_LOG_DRIVER=none, so runs can't stream progress, compare output or report runtime errors")
+	}
 
-	testFile, err := files.ReadFile(fmt.Sprintf("tests/%s/test.ts", task))
+	shutdownTracing, err := setupTracing(context.Background(), cfg)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("setting up tracing: %w", err))
 	}
 
-	memFS["Dockerfile"] = &fstest.MapFile{Data: dockerfile, Mode: 0644}
-	memFS["test.ts"] = &fstest.MapFile{Data: testFile, Mode: 0644}
-
-	return memFS
-}
-
-func tarImageContext(files fs.FS) (io.Reader, error) {
-	buffer := new(bytes.Buffer)
-	tarwriter := tar.NewWriter(buffer)
-
-	err := fs.WalkDir(files, ".", func(file string, entry fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		info, err := entry.Info()
-		if err != nil {
-			return err
-		}
-
-		hdr, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
-		}
-		hdr.Name = file
-		hdr.ModTime = time.Now()
-
-		if err := tarwriter.WriteHeader(hdr); err != nil {
-			return err
-		}
-
-		if entry.IsDir() {
-			return nil
-		}
-
-		f, err := files.Open(file)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		if _, err := io.Copy(tarwriter, f); err != nil {
-			return err
-		}
-
-		return nil
-	})
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
-		return nil, err
+		panic(fmt.Errorf("opening client: %w", err))
 	}
 
-	if err := tarwriter.Close(); err != nil {
-		return nil, err
+	if err := checkDockerAPI(context.Background(), cli); err != nil {
+		panic(fmt.Errorf("checking Docker API: %w", err))
 	}
 
-	return buffer, nil
-}
-
-func executeCodeTest(code string, task string, user string) []byte {
-	ctx := context.Background()
-
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		panic(fmt.Errorf("opening client %e", err))
+	if cfg.CleanupOnStart {
+		if err := cleanupOrphans(context.Background(), cfg, cli); err != nil {
+			slog.Error("cleaning up orphaned resources", "error", err)
+		}
 	}
 
-	imageName := fmt.Sprintf("%s-%s-test", user, task)
-	fmt.Printf("building %s", imageName)
-	imageContext, err := tarImageContext(createFS(task, code))
-	if err != nil {
-		panic(fmt.Errorf("creating image rar %e", err))
+	if err := checkDockerfiles(files); err != nil {
+		panic(err)
 	}
-
-	_, err = cli.ImageBuild(ctx, imageContext, client.ImageBuildOptions{Tags: []string{imageName}, Dockerfile: "/Dockerfile", Remove: false})
-	if err != nil {
-		panic(fmt.Errorf("building image %e", err))
+	if err := loadContextIgnore(); err != nil {
+		panic(err)
 	}
 
-	containerOutput, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: imageName,
-	}, nil, nil, nil, "")
-	if err != nil {
-		fmt.Printf("error creating container %e", err)
+	if err := loadTestChecksums(cfg.TestChecksums); err != nil {
+		panic(fmt.Errorf("loading test checksums: %w", err))
+	}
+	if err := loadTaskVersions(); err != nil {
+		panic(fmt.Errorf("computing task versions: %w", err))
 	}
 
-	defer func() {
-		err := cli.ContainerRemove(ctx, containerOutput.ID, client.ContainerRemoveOptions{})
-		if err != nil {
-			fmt.Printf("error deleting container %s", containerOutput.ID)
-		}
-	}()
+	if err := loadSeccompProfile(cfg.SeccompProfile); err != nil {
+		panic(err)
+	}
 
-	err = cli.ContainerStart(ctx, containerOutput.ID, client.ContainerStartOptions{})
-	if err != nil {
-		fmt.Printf("error starting container %e", err)
+	if err := loadCABundle(cfg.CABundle); err != nil {
+		panic(err)
 	}
 
-	waitChannel, errorChannel := cli.ContainerWait(ctx, containerOutput.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errorChannel:
-		{
-			fmt.Printf("error running container %e", err)
+	if problems := checkTasks(); len(problems) > 0 {
+		for _, problem := range problems {
+			slog.Warn("invalid embedded task", "error", problem)
+		}
+		if cfg.StrictTasks {
+			panic(fmt.Errorf("invalid embedded tasks: %w", errors.Join(problems...)))
 		}
-	case <-waitChannel:
 	}
 
-	report, _, err := cli.CopyFromContainer(ctx, containerOutput.ID, "/test/report.xml")
-	if err != nil {
-		fmt.Printf("error getting report %e", err)
+	if err := registerPostProcessors(cfg.PostProcessors); err != nil {
+		panic(err)
 	}
 
-	defer report.Close()
-
-	tarReader := tar.NewReader(report)
-	_, err = tarReader.Next()
+	parseSlots = newLimiter(cfg.ReportParseWorkers)
+	builds := newLimiter(cfg.MaxConcurrentBuilds)
+	runs := newLimiter(cfg.MaxConcurrentRuns)
 
-	if err != nil {
-		fmt.Printf("error untarring report %e", err)
+	// Strict mode has to wait for the smoke test results; otherwise the
+	// server starts serving while they run, refusing runs until then.
+	if cfg.SmokeTestOnStart && cfg.StrictTasks {
+		if failures := runSmokeTests(context.Background(), cfg, cli, builds, runs); len(failures) > 0 {
+			panic(fmt.Errorf("smoke tests failed: %w", errors.Join(failures...)))
+		}
+	}
+	if cfg.WarmupOnStart || (cfg.SmokeTestOnStart && !cfg.StrictTasks) {
+		warmingUp.Store(true)
+		go warmupOnStart(cfg, cli, builds, runs)
 	}
 
-	logBuffer := bytes.Buffer{}
-
-	io.Copy(&logBuffer, tarReader)
-
-	return logBuffer.Bytes()
-}
+	budget := newRuntimeBudget(cfg.RuntimeBudget, cfg.RuntimeBudgetWindow)
+	results := newResultStore(cfg.ResultStoreSize, cfg.ChainUsers)
+	memory := newMemoryGuard(cfg.MemoryBudget)
+	jobs := newJobQueue(cfg.AsyncQueueSize, results, newWebhookSender(cfg), memory)
+	jobs.Start(context.Background(), cfg, cli, builds, runs, budget, cfg.MaxConcurrentRuns)
 
-func main() {
 	router := http.ServeMux{}
 
+	router.HandleFunc("GET /livez", livezHandler())
+	router.HandleFunc("GET /readyz", readyzHandler(cli, runs))
+	router.HandleFunc("GET /load", loadHandler(runs, jobs))
+
 	router.HandleFunc("OPTIONS /", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization, X-Request-ID, X-Strict, If-None-Match")
 		w.WriteHeader(http.StatusOK)
 	})
 
-	router.HandleFunc("POST /test/{test}/run", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		test := r.PathValue("test")
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			fmt.Printf("Error reading body: %e", err)
-			return
-		}
-
-		defer r.Body.Close()
-
-		code := &Code{}
-
-		err = json.Unmarshal(body, code)
-		if err != nil {
-			fmt.Printf("Error reading body: %e", err)
-			return
-		}
-
-		output := executeCodeTest(code.Code, test, code.User)
-
-		w.Header().Set("Content-Type", "application/xml")
-		w.WriteHeader(200)
-		w.Write(output)
-	})
-
-	router.HandleFunc("GET /test/{test}", func(w http.ResponseWriter, r *http.Request) {
-		test := r.PathValue("test")
-		code, err := files.ReadFile(fmt.Sprintf("tests/%s/code.ts", test))
-		if err != nil {
-			w.WriteHeader(404)
-			w.Write([]byte("Can't get base code for " + test))
-			return
+	router.HandleFunc("POST /test/{test}/run", requireWarm(runHandler(cfg, cli, builds, runs, budget, jobs, memory, results)))
+	router.HandleFunc("GET /results/{id}", resultHandler(results))
+	router.HandleFunc("GET /metrics", metricsHandler())
+	router.HandleFunc("GET /results/{id}/bundle", requireAdmin(cfg, bundleHandler(results)))
+	router.HandleFunc("POST /run", requireWarm(compositeHandler(cfg, cli, builds, runs, budget, memory, results)))
+
+	router.HandleFunc("GET /test/{test}", testHandler(cfg))
+	router.HandleFunc("GET /test/{test}/meta", metaHandler(cfg))
+	router.HandleFunc("GET /test/{test}/testfile", testFileHandler(cfg))
+	router.HandleFunc("GET /test/{test}/cases", casesHandler(cfg))
+
+	router.HandleFunc("POST /admin/warmup", requireAdmin(cfg, warmupHandler(cfg, cli, builds)))
+	router.HandleFunc("GET /admin/running", requireAdmin(cfg, runningHandler(activeRuns)))
+	router.HandleFunc("GET /admin/config", requireAdmin(cfg, configHandler(cfg)))
+	router.HandleFunc("GET /admin/test/{test}/dockerfile", requireAdmin(cfg, dockerfileHandler()))
+	router.HandleFunc("GET /stats/{test}", requireAdmin(cfg, statsHandler(results)))
+	router.HandleFunc("GET /admin/code/{hash}/results", requireAdmin(cfg, codeResultsHandler(results)))
+	router.HandleFunc("GET /admin/users/{user}/chain", requireAdmin(cfg, chainHandler(results)))
+
+	server := newServer(cfg, otelhttp.NewHandler(withRequestID(withLoadHeaders(runs, jobs, withDeadline(cfg, &router))), "http"))
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		shuttingDown.Store(true)
+		slog.Info("shutting down, waiting for runs to finish", "timeout", cfg.ShutdownTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("shutting down", "error", err)
 		}
-		desc, err := files.ReadFile(fmt.Sprintf("tests/%s/README.md", test))
-		if err != nil {
-			w.WriteHeader(404)
-			w.Write([]byte("Can't get description for " + test))
-			return
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("flushing traces", "error", err)
 		}
+	}()
 
-		testData := Test{
-			Name: test,
-			Base: string(code),
-			Desc: string(desc),
-		}
-
-		resp, _ := json.Marshal(testData)
-
-		w.Write(resp)
-	})
-
-	http.ListenAndServe(":8086", &router)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server stopped", "error", err)
+	}
 }
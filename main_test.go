@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// TestMain records the packaged tests' checksums, as main does at startup.
+func TestMain(m *testing.M) {
+	if err := loadTestChecksums(nil); err != nil {
+		fmt.Fprintf(os.Stderr, "loading test checksums: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// testConfig loads the configuration from env alone, with every other
+// setting at its default.
+func testConfig(t testing.TB, env map[string]string) *Config {
+	t.Helper()
+
+	cfg, err := loadConfig(func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	return cfg
+}
@@ -0,0 +1,50 @@
+package main
+
+import "sync/atomic"
+
+// memoryGuard tracks the bytes the server has committed to buffer for runs
+// in flight, such as build contexts and reports, and refuses reservations
+// that would exceed its limit. It complements the run limiter, which bounds
+// the number of runs but not their size.
+type memoryGuard struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// newMemoryGuard returns nil, which accepts every reservation, when limit
+// is zero.
+func newMemoryGuard(limit int64) *memoryGuard {
+	if limit <= 0 {
+		return nil
+	}
+	return &memoryGuard{limit: limit}
+}
+
+// Reserve commits n bytes if they fit in the limit.
+func (g *memoryGuard) Reserve(n int64) bool {
+	if g == nil {
+		return true
+	}
+
+	for {
+		used := g.used.Load()
+		if used+n > g.limit {
+			return false
+		}
+		if g.used.CompareAndSwap(used, used+n) {
+			return true
+		}
+	}
+}
+
+func (g *memoryGuard) Release(n int64) {
+	if g != nil {
+		g.used.Add(-n)
+	}
+}
+
+// runMemory estimates what a run buffers: the submission, its copy in the
+// build context, and the largest report it may return.
+func runMemory(cfg *Config, code string) int64 {
+	return 2*int64(len(code)) + cfg.MaxReportBytes
+}
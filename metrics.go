@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// counterVec is a Prometheus counter with a single label, written in the
+// text exposition format by metricsHandler.
+type counterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func newCounterVec(name string, help string, label string) *counterVec {
+	counter := &counterVec{name: name, help: help, label: label, values: map[string]int64{}}
+	registeredMetrics = append(registeredMetrics, counter)
+	return counter
+}
+
+func (c *counterVec) Add(value string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[value] += int64(n)
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, value := range slices.Sorted(maps.Keys(c.values)) {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, value, c.values[value])
+	}
+}
+
+var registeredMetrics []*counterVec
+
+var buildCacheSteps = newCounterVec("gitblame_build_cache_steps_total", "Image build steps by whether they were served from the layer cache.", "result")
+
+var runRetries = newCounterVec("gitblame_run_retries_total", "Runs tried again after an infrastructure failure, by error code.", "code")
+
+// metricsHandler serves GET /metrics for Prometheus.
+func metricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, metric := range registeredMetrics {
+			metric.write(w)
+		}
+	}
+}
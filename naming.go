@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const maxNameComponent = 64
+
+// sanitizeName reduces s to characters valid in a Docker image name.
+func sanitizeName(s string) string {
+	builder := strings.Builder{}
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune('-')
+		}
+	}
+
+	name := strings.Trim(builder.String(), "-")
+	if len(name) > maxNameComponent {
+		name = strings.TrimRight(name[:maxNameComponent], "-")
+	}
+	if name == "" {
+		name = "x"
+	}
+
+	return name
+}
+
+// userImageName returns the image tag for a user's build of a task. The
+// sanitized parts keep the tag readable; the suffix hashes the raw user and
+// task, so distinct inputs never share a tag even when they sanitize to the
+// same value. cfg.ImagePrefix, joined by a dash, namespaces the tag.
+func userImageName(cfg *Config, user string, task string) string {
+	sum := sha256.Sum256([]byte(user + "\x00" + task))
+	name := fmt.Sprintf("%s-%s-test-%s", sanitizeName(user), sanitizeName(task), hex.EncodeToString(sum[:6]))
+	if cfg.ImagePrefix == "" {
+		return name
+	}
+	return cfg.ImagePrefix + "-" + name
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// imageTag matches a lowercase Docker repository name.
+var imageTag = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$`)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice", "alice"},
+		{"Alice.Smith", "alice-smith"},
+		{"--bob--", "bob"},
+		{"名前", "x"},
+		{"", "x"},
+		{strings.Repeat("a", 70), strings.Repeat("a", maxNameComponent)},
+	}
+	for _, test := range tests {
+		if got := sanitizeName(test.in); got != test.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestUserImageNameCollisions(t *testing.T) {
+	cfg := testConfig(t, nil)
+
+	tests := []struct {
+		name       string
+		user, task string
+		otherUser  string
+		otherTask  string
+	}{
+		{"punctuation", "alice.smith", "sum", "alice_smith", "sum"},
+		{"case", "Bob", "sum", "bob", "sum"},
+		{"split", "a-b", "c", "a", "b-c"},
+		{"stripped", "名前", "sum", "!!", "sum"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first := userImageName(cfg, test.user, test.task)
+			second := userImageName(cfg, test.otherUser, test.otherTask)
+			// Only the hash suffix may tell them apart.
+			readable := func(tag string) string { return tag[:strings.LastIndex(tag, "-")] }
+			if readable(first) != readable(second) {
+				t.Fatalf("%s and %s don't sanitize identically", first, second)
+			}
+			if first == second {
+				t.Errorf("%q/%q and %q/%q share tag %s", test.user, test.task, test.otherUser, test.otherTask, first)
+			}
+			for _, tag := range []string{first, second} {
+				if !imageTag.MatchString(tag) {
+					t.Errorf("tag %q isn't a valid image name", tag)
+				}
+			}
+		})
+	}
+}
+
+func TestUserImageNameStable(t *testing.T) {
+	cfg := testConfig(t, nil)
+
+	if first, second := userImageName(cfg, "alice", "sum"), userImageName(cfg, "alice", "sum"); first != second {
+		t.Errorf("the same inputs got tags %s and %s", first, second)
+	}
+}
+
+func TestUserImageNamePrefix(t *testing.T) {
+	plain := userImageName(testConfig(t, nil), "alice", "sum")
+	prefixed := userImageName(testConfig(t, map[string]string{"IMAGE_PREFIX": "staging"}), "alice", "sum")
+
+	if prefixed != "staging-"+plain {
+		t.Errorf("prefixed tag is %s, want staging-%s", prefixed, plain)
+	}
+	if !imageTag.MatchString(prefixed) {
+		t.Errorf("prefixed tag %s isn't a valid image name", prefixed)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonLine is a line of an NDJSON run stream. Event is "build",
+// "progress", "result" or "error", and Data is what the event stream sends
+// as the event's data: a BuildEvent, a ProgressEvent, the RunResult or the
+// error body. For example:
+//
+//	{"event":"progress","data":{"name":"adds","status":"passed","duration":"2ms"}}
+//	{"event":"result","data":{"schemaVersion":"2.0.0","passed":1,...}}
+type ndjsonLine struct {
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+// ndjsonWriter streams a run as JSON lines over a chunked response, for
+// clients that can't consume an event stream.
+type ndjsonWriter struct {
+	mu         sync.Mutex
+	w          http.ResponseWriter
+	controller *http.ResponseController
+}
+
+// newNDJSONWriter starts an NDJSON stream. Like an event stream it outlives
+// the server's write timeout, so its deadline is cleared.
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	return &ndjsonWriter{w: w, controller: controller}
+}
+
+func (s *ndjsonWriter) Send(event string, data any) error {
+	payload, err := json.Marshal(ndjsonLine{Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(append(payload, '\n')); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
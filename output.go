@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const outputCaseName = "output matches expected"
+
+func normalizeOutput(output string) string {
+	return strings.TrimRight(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+}
+
+// compareOutput diffs the container's stdout against the task's packaged
+// expected.txt, returning an empty diff when they match.
+func compareOutput(task string, stdout []byte) (string, error) {
+	expected, err := files.ReadFile(fmt.Sprintf("tests/%s/expected.txt", task))
+	if err != nil {
+		return "", fmt.Errorf("reading expected output: %w", err)
+	}
+
+	want := normalizeOutput(string(expected))
+	got := normalizeOutput(string(stdout))
+	if want == got {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(want + "\n"),
+		B:        difflib.SplitLines(got + "\n"),
+		FromFile: "expected",
+		ToFile:   "actual",
+		Context:  3,
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInvalidPatch  = errors.New("invalid patch")
+	errPatchConflict = errors.New("patch does not apply")
+)
+
+// patchableFiles are the files a submitted patch may change: the
+// submission is the task's code.ts and nothing else.
+var patchableFiles = []string{"code.ts"}
+
+// patchLine is a line of a hunk: op is ' ' for context, '-' for a removed
+// line and '+' for an added one. noEOL marks a line that ends its file
+// without a trailing newline.
+type patchLine struct {
+	op    byte
+	text  string
+	noEOL bool
+}
+
+type patchHunk struct {
+	oldStart, oldLines int
+	newStart, newLines int
+	lines              []patchLine
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// resolvePatch applies patch, a unified diff against the task's packaged
+// code.ts, and returns the patched code.
+func resolvePatch(task string, patch string) (string, error) {
+	base, err := files.ReadFile(fmt.Sprintf("tests/%s/code.ts", task))
+	if err != nil {
+		return "", fmt.Errorf("reading base code: %w", err)
+	}
+
+	hunks, err := parsePatch(patch)
+	if err != nil {
+		return "", err
+	}
+	return applyPatch(string(base), hunks)
+}
+
+// parsePatch reads the hunks of a unified diff, as produced by diff -u or
+// git diff, that touches only patchableFiles. Lines before the first file
+// header, such as git's "diff --git" and "index" lines, are ignored.
+func parsePatch(patch string) ([]patchHunk, error) {
+	lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+	hunks := []patchHunk{}
+	files := 0
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "Binary files "), strings.HasPrefix(line, "GIT binary patch"):
+			return nil, fmt.Errorf("%w: binary patches aren't supported", errInvalidPatch)
+		case strings.HasPrefix(line, "--- "):
+			if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+				return nil, fmt.Errorf("%w: line %d: \"---\" header without \"+++\"", errInvalidPatch, i+1)
+			}
+			for _, header := range []string{line[4:], lines[i+1][4:]} {
+				if err := checkPatchFile(header); err != nil {
+					return nil, err
+				}
+			}
+			files++
+			if files > 1 {
+				return nil, fmt.Errorf("%w: code.ts is patched more than once", errInvalidPatch)
+			}
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if files == 0 {
+				return nil, fmt.Errorf("%w: line %d: hunk before a file header", errInvalidPatch, i+1)
+			}
+			hunk, end, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			if len(hunks) > 0 {
+				previous := hunks[len(hunks)-1]
+				if hunk.oldStart < previous.oldStart+previous.oldLines {
+					return nil, fmt.Errorf("%w: line %d: hunks overlap or are out of order", errInvalidPatch, i+1)
+				}
+			}
+			hunks = append(hunks, hunk)
+			i = end
+		}
+	}
+
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("%w: no hunks", errInvalidPatch)
+	}
+	return hunks, nil
+}
+
+// checkPatchFile fails unless a "---" or "+++" header names one of
+// patchableFiles, with or without git's a/ and b/ prefixes. /dev/null, for
+// creating or deleting a file, is refused too.
+func checkPatchFile(header string) error {
+	name, _, _ := strings.Cut(header, "\t")
+	name = strings.TrimSpace(name)
+	for _, prefix := range []string{"a/", "b/"} {
+		if trimmed, ok := strings.CutPrefix(name, prefix); ok {
+			name = trimmed
+			break
+		}
+	}
+	for _, allowed := range patchableFiles {
+		if name == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: it changes %q; only %s may be patched", errInvalidPatch, name, strings.Join(patchableFiles, ", "))
+}
+
+// parseHunk reads the hunk whose header is lines[start], returning it and
+// the index of its last line.
+func parseHunk(lines []string, start int) (patchHunk, int, error) {
+	match := hunkHeader.FindStringSubmatch(lines[start])
+	if match == nil {
+		return patchHunk{}, 0, fmt.Errorf("%w: line %d: malformed hunk header", errInvalidPatch, start+1)
+	}
+	hunk := patchHunk{
+		oldStart: atoiDefault(match[1], 0),
+		oldLines: atoiDefault(match[2], 1),
+		newStart: atoiDefault(match[3], 0),
+		newLines: atoiDefault(match[4], 1),
+	}
+
+	oldSeen, newSeen := 0, 0
+	i := start
+	for oldSeen < hunk.oldLines || newSeen < hunk.newLines {
+		i++
+		if i >= len(lines) {
+			return patchHunk{}, 0, fmt.Errorf("%w: line %d: hunk is shorter than its header says", errInvalidPatch, start+1)
+		}
+		line := lines[i]
+		// Some editors strip the space off a blank context line.
+		if line == "" {
+			line = " "
+		}
+		op := line[0]
+		switch op {
+		case ' ':
+			oldSeen++
+			newSeen++
+		case '-':
+			oldSeen++
+		case '+':
+			newSeen++
+		case '\\':
+			if len(hunk.lines) == 0 {
+				return patchHunk{}, 0, fmt.Errorf("%w: line %d: misplaced \"\\\" line", errInvalidPatch, i+1)
+			}
+			hunk.lines[len(hunk.lines)-1].noEOL = true
+			continue
+		default:
+			return patchHunk{}, 0, fmt.Errorf("%w: line %d: unexpected line in hunk", errInvalidPatch, i+1)
+		}
+		if oldSeen > hunk.oldLines || newSeen > hunk.newLines {
+			return patchHunk{}, 0, fmt.Errorf("%w: line %d: hunk is longer than its header says", errInvalidPatch, start+1)
+		}
+		hunk.lines = append(hunk.lines, patchLine{op: op, text: line[1:]})
+	}
+	// A last line without a trailing newline is followed by a marker.
+	if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\\") {
+		i++
+		hunk.lines[len(hunk.lines)-1].noEOL = true
+	}
+
+	return hunk, i, nil
+}
+
+func atoiDefault(value string, fallback int) int {
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// applyPatch applies hunks to base. Every context and removed line must
+// match base exactly at the line the hunk names: there is no fuzz, so a
+// patch made against a different version of the code is refused.
+func applyPatch(base string, hunks []patchHunk) (string, error) {
+	baseLines, baseEOL := splitLines(base)
+	result := []string{}
+	resultEOL := baseEOL
+	next := 0
+
+	for n, hunk := range hunks {
+		// A hunk that adds to an empty file, or only inserts, names the
+		// line before it.
+		start := hunk.oldStart - 1
+		if hunk.oldLines == 0 {
+			start = hunk.oldStart
+		}
+		if start < next || start > len(baseLines) {
+			return "", fmt.Errorf("%w: hunk %d starts at line %d, past the end of code.ts", errPatchConflict, n+1, hunk.oldStart)
+		}
+		result = append(result, baseLines[next:start]...)
+		next = start
+
+		for _, line := range hunk.lines {
+			if line.op == '+' {
+				result = append(result, line.text)
+				continue
+			}
+			if next >= len(baseLines) || baseLines[next] != line.text {
+				return "", fmt.Errorf("%w: hunk %d doesn't match code.ts at line %d", errPatchConflict, n+1, next+1)
+			}
+			if next == len(baseLines)-1 && line.noEOL == baseEOL {
+				return "", fmt.Errorf("%w: hunk %d disagrees about the newline ending code.ts", errPatchConflict, n+1)
+			}
+			if line.op == ' ' {
+				result = append(result, line.text)
+			}
+			next++
+		}
+
+		if next == len(baseLines) {
+			resultEOL = true
+			for i := len(hunk.lines) - 1; i >= 0; i-- {
+				if hunk.lines[i].op != '-' {
+					resultEOL = !hunk.lines[i].noEOL
+					break
+				}
+			}
+		}
+	}
+	result = append(result, baseLines[next:]...)
+
+	if len(result) == 0 {
+		return "", nil
+	}
+	code := strings.Join(result, "\n")
+	if resultEOL {
+		code += "\n"
+	}
+	return code, nil
+}
+
+// splitLines splits text into lines, reporting whether the last one ended
+// with a newline.
+func splitLines(text string) ([]string, bool) {
+	if text == "" {
+		return nil, true
+	}
+	if trimmed, ok := strings.CutSuffix(text, "\n"); ok {
+		return strings.Split(trimmed, "\n"), true
+	}
+	return strings.Split(text, "\n"), false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const patchBase = "export function sum(a: number, b: number): number {\n\treturn 0\n}\n"
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{"clean", patchBase,
+			"--- a/code.ts\n+++ b/code.ts\n@@ -1,3 +1,3 @@\n export function sum(a: number, b: number): number {\n-\treturn 0\n+\treturn a + b\n }\n",
+			"export function sum(a: number, b: number): number {\n\treturn a + b\n}\n", nil},
+		{"git headers", patchBase,
+			"diff --git a/code.ts b/code.ts\nindex 1111111..2222222 100644\n--- a/code.ts\n+++ b/code.ts\n@@ -2 +2 @@\n-\treturn 0\n+\treturn a + b\n",
+			"export function sum(a: number, b: number): number {\n\treturn a + b\n}\n", nil},
+		{"two hunks", "a\nb\nc\nd\ne\nf\n",
+			"--- code.ts\n+++ code.ts\n@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -5,2 +5,3 @@\n e\n+E\n f\n",
+			"A\nb\nc\nd\ne\nE\nf\n", nil},
+		{"pure insertion", "a\nb\n",
+			"--- code.ts\n+++ code.ts\n@@ -1,0 +2 @@\n+inserted\n",
+			"a\ninserted\nb\n", nil},
+		{"blank context without its space", "a\n\nb\n",
+			"--- code.ts\n+++ code.ts\n@@ -1,3 +1,3 @@\n a\n\n-b\n+B\n",
+			"a\n\nB\n", nil},
+		{"drops the final newline", "a\nb\n",
+			"--- code.ts\n+++ code.ts\n@@ -2 +2 @@\n-b\n+b\n\\ No newline at end of file\n",
+			"a\nb", nil},
+		{"adds the final newline", "a\nb",
+			"--- code.ts\n+++ code.ts\n@@ -2 +2 @@\n-b\n\\ No newline at end of file\n+b\n",
+			"a\nb\n", nil},
+		{"empties the file", "a\n",
+			"--- code.ts\n+++ code.ts\n@@ -1 +0,0 @@\n-a\n",
+			"", nil},
+		{"context mismatch", patchBase,
+			"--- code.ts\n+++ code.ts\n@@ -1,3 +1,3 @@\n export function sum(a: number, b: number): number {\n-\treturn 1\n+\treturn a + b\n }\n",
+			"", errPatchConflict},
+		{"offset hunk", "// header\n" + patchBase,
+			"--- code.ts\n+++ code.ts\n@@ -1,3 +1,3 @@\n export function sum(a: number, b: number): number {\n-\treturn 0\n+\treturn a + b\n }\n",
+			"", errPatchConflict},
+		{"past the end", "a\n",
+			"--- code.ts\n+++ code.ts\n@@ -5 +5 @@\n-a\n+b\n",
+			"", errPatchConflict},
+		{"newline disagreement", "a\nb",
+			"--- code.ts\n+++ code.ts\n@@ -2 +2 @@\n-b\n+c\n",
+			"", errPatchConflict},
+		{"malformed hunk header", patchBase,
+			"--- code.ts\n+++ code.ts\n@@ -1,3 +1,3\n-\treturn 0\n",
+			"", errInvalidPatch},
+		{"hunk shorter than its header", patchBase,
+			"--- code.ts\n+++ code.ts\n@@ -1,3 +1,3 @@\n export function sum(a: number, b: number): number {\n",
+			"", errInvalidPatch},
+		{"hunk longer than its header", patchBase,
+			"--- code.ts\n+++ code.ts\n@@ -2 +2 @@\n-\treturn 0\n-}\n+\treturn a + b\n",
+			"", errInvalidPatch},
+		{"unexpected line", patchBase,
+			"--- code.ts\n+++ code.ts\n@@ -2 +2 @@\n*\treturn 0\n",
+			"", errInvalidPatch},
+		{"hunk before a header", patchBase,
+			"@@ -2 +2 @@\n-\treturn 0\n+\treturn a + b\n",
+			"", errInvalidPatch},
+		{"missing +++", patchBase,
+			"--- code.ts\n@@ -2 +2 @@\n-\treturn 0\n+\treturn a + b\n",
+			"", errInvalidPatch},
+		{"overlapping hunks", "a\nb\nc\n",
+			"--- code.ts\n+++ code.ts\n@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -2 +2 @@\n-b\n+B\n",
+			"", errInvalidPatch},
+		{"another file", patchBase,
+			"--- a/test.ts\n+++ b/test.ts\n@@ -1 +1 @@\n-x\n+y\n",
+			"", errInvalidPatch},
+		{"new file", "",
+			"--- /dev/null\n+++ b/code.ts\n@@ -0,0 +1 @@\n+x\n",
+			"", errInvalidPatch},
+		{"code.ts twice", patchBase,
+			"--- code.ts\n+++ code.ts\n@@ -2 +2 @@\n-\treturn 0\n+\treturn 1\n--- code.ts\n+++ code.ts\n@@ -3 +3 @@\n-}\n+}\n",
+			"", errInvalidPatch},
+		{"binary", patchBase,
+			"diff --git a/code.ts b/code.ts\nBinary files a/code.ts and b/code.ts differ\n",
+			"", errInvalidPatch},
+		{"no hunks", patchBase, "--- code.ts\n+++ code.ts\n", "", errInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks, err := parsePatch(tt.patch)
+			var got string
+			if err == nil {
+				got, err = applyPatch(tt.base, hunks)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("patched = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePatch(t *testing.T) {
+	patch := "--- a/code.ts\n+++ b/code.ts\n@@ -1,3 +1,3 @@\n export function sum(a: number, b: number): number {\n-\treturn 0\n+\treturn a + b\n }\n"
+	got, err := resolvePatch("sum", patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "export function sum(a: number, b: number): number {\n\treturn a + b\n}\n"; got != want {
+		t.Errorf("resolvePatch = %q, want %q", got, want)
+	}
+
+	if _, err := resolvePatch("missing", patch); err == nil || errors.Is(err, errPatchConflict) {
+		t.Errorf("resolvePatch for an unknown task = %v, want a read error", err)
+	}
+}
+
+func TestRunHandlerPatch(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t).withImage(cfg, "sum").withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+	handler := runHandler(cfg, docker.client, nil, nil, nil, nil, nil, newResultStore(100, 100))
+
+	post := func(body map[string]string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", "/test/sum/run", bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+		return serve(t, "POST /test/{test}/run", handler, r)
+	}
+
+	clean := "--- a/code.ts\n+++ b/code.ts\n@@ -2 +2 @@\n-\treturn 0\n+\treturn a + b\n"
+	if w := post(map[string]string{"user": "alice", "patch": clean}); w.Code != http.StatusOK {
+		t.Errorf("clean patch responded %d: %s", w.Code, w.Body)
+	}
+
+	stale := "--- a/code.ts\n+++ b/code.ts\n@@ -2 +2 @@\n-\treturn 1\n+\treturn a + b\n"
+	assertAPIError(t, post(map[string]string{"user": "alice", "patch": stale}), http.StatusUnprocessableEntity, codePatchConflict)
+	assertAPIError(t, post(map[string]string{"user": "alice", "patch": "@@ -2 +2 @@\n"}), http.StatusBadRequest, codeInvalidRequest)
+	assertAPIError(t, post(map[string]string{"user": "alice", "code": "x", "patch": clean}), http.StatusBadRequest, codeInvalidRequest)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// PostProcessor annotates a parsed result before it is returned, for example
+// to grade it. Processors run in registration order.
+type PostProcessor interface {
+	Name() string
+	Process(task string, result *RunResult) error
+}
+
+var availableProcessors = map[string]func() PostProcessor{
+	"noop":   func() PostProcessor { return noopProcessor{} },
+	"scorer": func() PostProcessor { return scoreProcessor{} },
+}
+
+var postProcessors []PostProcessor
+
+func registerPostProcessor(processor PostProcessor) {
+	postProcessors = append(postProcessors, processor)
+}
+
+// registerPostProcessors registers the processors named in a comma separated
+// list, in order.
+func registerPostProcessors(names string) error {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		newProcessor, ok := availableProcessors[name]
+		if !ok {
+			return fmt.Errorf("unknown post-processor %q", name)
+		}
+		registerPostProcessor(newProcessor())
+	}
+
+	return nil
+}
+
+// applyPostProcessors runs every registered processor. A failing processor is
+// logged and skipped so the others still run.
+func applyPostProcessors(task string, result *RunResult) {
+	for _, processor := range postProcessors {
+		if err := processor.Process(task, result); err != nil {
+			slog.Error("post-processor failed", "processor", processor.Name(), "task", task, "error", err)
+		}
+	}
+}
+
+func (r *RunResult) annotate(key string, value any) {
+	if r.Annotations == nil {
+		r.Annotations = map[string]any{}
+	}
+	r.Annotations[key] = value
+}
+
+type noopProcessor struct{}
+
+func (noopProcessor) Name() string { return "noop" }
+
+func (noopProcessor) Process(string, *RunResult) error { return nil }
+
+// scoreProcessor awards the task's points in proportion to the passing cases.
+type scoreProcessor struct{}
+
+func (scoreProcessor) Name() string { return "scorer" }
+
+func (scoreProcessor) Process(task string, result *RunResult) error {
+	meta, err := loadMetadata(task)
+	if err != nil {
+		return err
+	}
+
+	score := 0.0
+	if result.Total > 0 {
+		score = float64(meta.Points) * float64(result.Passed) / float64(result.Total)
+	}
+
+	result.annotate("score", score)
+	result.annotate("maxScore", meta.Points)
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+)
+
+// ProgressEvent reports a single test result as soon as the runner prints it.
+type ProgressEvent struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Duration string `json:"duration,omitempty"`
+}
+
+var (
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+	// progressLine matches the runner's per-test line, as printed by deno
+	// test's default reporter:
+	//
+	//	<name> ... ok (12ms)
+	//	<name> ... FAILED (3ms)
+	//	<name> ... ignored (0ms)
+	progressLine = regexp.MustCompile(`^(.+?) \.\.\. (ok|FAILED|ignored)(?: \(([^)]*)\))?$`)
+)
+
+var progressStatuses = map[string]string{
+	"ok":      StatusPassed,
+	"FAILED":  StatusFailed,
+	"ignored": StatusSkipped,
+}
+
+func parseProgressLine(line string) (ProgressEvent, bool) {
+	line = strings.TrimSpace(ansiEscape.ReplaceAllString(line, ""))
+
+	match := progressLine.FindStringSubmatch(line)
+	if match == nil {
+		return ProgressEvent{}, false
+	}
+
+	return ProgressEvent{Name: match[1], Status: progressStatuses[match[2]], Duration: match[3]}, true
+}
+
+// followProgress tails the container's stdout until it exits, emitting an
+// event for every line in the progress format and ignoring all others.
+func followProgress(ctx context.Context, cli *client.Client, containerID string, emit func(ProgressEvent)) error {
+	logs, err := cli.ContainerLogs(ctx, containerID, client.ContainerLogsOptions{ShowStdout: true, Follow: true})
+	if err != nil {
+		return fmt.Errorf("following container output: %w", err)
+	}
+	defer logs.Close()
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(writer, io.Discard, logs)
+		writer.CloseWithError(err)
+	}()
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if event, ok := parseProgressLine(scanner.Text()); ok {
+			emit(event)
+		}
+	}
+
+	return scanner.Err()
+}
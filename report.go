@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
+)
+
+var errReportTooLarge = errors.New("report too large")
+
+// readLimited reads r to the end, failing with errReportTooLarge instead of
+// buffering more than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: limit is %d bytes", errReportTooLarge, limit)
+	}
+	return data, nil
+}
+
+var errTruncatedReport = errors.New("report truncated")
+
+// readTarEntry reads the current entry of a tar stream, failing if the
+// stream ends before the size its header promised, as it does when the
+// container goes away mid-copy.
+func readTarEntry(tarReader *tar.Reader, hdr *tar.Header, limit int64) ([]byte, error) {
+	if hdr.Size > limit {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d bytes", errReportTooLarge, hdr.Size, limit)
+	}
+
+	data, err := readLimited(tarReader, limit)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: stream ended before %d bytes", errTruncatedReport, hdr.Size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != hdr.Size {
+		return nil, fmt.Errorf("%w: read %d of %d bytes", errTruncatedReport, len(data), hdr.Size)
+	}
+	return data, nil
+}
+
+func readReport(ctx context.Context, cli *client.Client, containerID string, reportPath string, maxSize int64) ([]byte, error) {
+	report, _, err := cli.CopyFromContainer(ctx, containerID, reportPath)
+	if err != nil {
+		return nil, fmt.Errorf("getting report: %w", err)
+	}
+	defer report.Close()
+
+	tarReader := tar.NewReader(report)
+	hdr, err := tarReader.Next()
+	if err != nil {
+		return nil, fmt.Errorf("untarring report: %w", err)
+	}
+
+	data, err := readTarEntry(tarReader, hdr, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading report: %w", err)
+	}
+
+	return data, nil
+}
+
+// pollReport tries to copy the report out of a running container. It
+// reports the report complete once it parses and hasn't changed since the
+// previous poll, so that a runner still writing it isn't cut short; it
+// returns what it read for the next poll to compare against.
+func pollReport(ctx context.Context, cfg *Config, cli *client.Client, meta Metadata, containerID string, previous []byte) ([]byte, bool) {
+	report, err := readReport(ctx, cli, containerID, meta.reportPath(), cfg.MaxReportBytes)
+	if err != nil {
+		return nil, false
+	}
+	if previous == nil || !bytes.Equal(report, previous) {
+		return report, false
+	}
+	if _, err := parseReport(meta.ReportFormat, meta.reportPath(), report, cfg.MaxReportCases); err != nil {
+		return report, false
+	}
+	return report, true
+}
+
+// readReportDir copies every .xml report under dir out of the container and
+// merges them into a single JUnit document. maxSize bounds the reports'
+// combined size.
+func readReportDir(ctx context.Context, cli *client.Client, containerID string, dir string, maxSize int64) ([]byte, error) {
+	reports, _, err := cli.CopyFromContainer(ctx, containerID, dir)
+	if err != nil {
+		return nil, fmt.Errorf("getting reports: %w", err)
+	}
+	defer reports.Close()
+
+	parsed := [][]junitTestSuite{}
+	remaining := maxSize
+	tarReader := tar.NewReader(reports)
+	for {
+		hdr, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("untarring reports: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !strings.EqualFold(path.Ext(hdr.Name), ".xml") {
+			continue
+		}
+
+		data, err := readTarEntry(tarReader, hdr, remaining)
+		if err != nil {
+			return nil, fmt.Errorf("reading report %s: %w", hdr.Name, err)
+		}
+		remaining -= int64(len(data))
+
+		suites, err := parseJUnitSuites(data)
+		if err != nil {
+			return nil, fmt.Errorf("report %s: %w", hdr.Name, err)
+		}
+		parsed = append(parsed, suites)
+	}
+
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("no reports found in %s: %w", dir, cerrdefs.ErrNotFound)
+	}
+
+	return mergeReports(parsed)
+}
+
+// mergeReports encodes the suites of several reports as one JUnit document.
+func mergeReports(parsed [][]junitTestSuite) ([]byte, error) {
+	merged, err := xml.Marshal(junitTestSuites{Suites: mergeJUnitSuites(parsed...)})
+	if err != nil {
+		return nil, fmt.Errorf("encoding merged report: %w", err)
+	}
+
+	return append([]byte(xml.Header), merged...), nil
+}
+
+// successReport is the report recorded for tasks that don't require one when
+// the run exits zero without writing it.
+func successReport(task string) ([]byte, error) {
+	report, err := xml.Marshal(junitTestSuites{Suites: []junitTestSuite{{
+		Name:  task,
+		Cases: []junitTestCase{{Name: "exits successfully", Classname: task}},
+	}}})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), report...), nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+func TestReadReportOversized(t *testing.T) {
+	docker := newFakeDocker(t).withReport(strings.Repeat("x", 4096))
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, map[string]string{"MAX_REPORT_BYTES": "1024"})
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	_, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	if !errors.Is(err, errReportTooLarge) {
+		t.Fatalf("oversized report gave %v, want errReportTooLarge", err)
+	}
+	if !strings.Contains(err.Error(), "limit is 1024 bytes") {
+		t.Errorf("error %q doesn't name the limit", err)
+	}
+}
+
+// A stream that doesn't say how big it is is cut off at the limit rather
+// than read to the end.
+func TestReadLimitedOversizedStream(t *testing.T) {
+	stream := &countingReader{remaining: 1 << 30}
+	_, err := readLimited(stream, 1024)
+	if !errors.Is(err, errReportTooLarge) {
+		t.Fatalf("oversized stream gave %v, want errReportTooLarge", err)
+	}
+	if stream.read > 1025 {
+		t.Errorf("read %d bytes of the stream, want at most the limit and one more", stream.read)
+	}
+}
+
+// countingReader yields remaining zero bytes and counts how many were read.
+type countingReader struct {
+	remaining int64
+	read      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n := int64(len(p))
+	if n > r.remaining {
+		n = r.remaining
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	r.remaining -= n
+	r.read += n
+	clear(p[:n])
+	return int(n), nil
+}
+
+func TestReadLimited(t *testing.T) {
+	tests := []struct {
+		size    int
+		limit   int64
+		tooLong bool
+	}{
+		{0, 1024, false},
+		{1023, 1024, false},
+		{1024, 1024, false},
+		{1025, 1024, true},
+		{4096, 1024, true},
+	}
+	for _, test := range tests {
+		data, err := readLimited(strings.NewReader(strings.Repeat("x", test.size)), test.limit)
+		if test.tooLong {
+			if !errors.Is(err, errReportTooLarge) {
+				t.Errorf("%d bytes at limit %d gave %v, want errReportTooLarge", test.size, test.limit, err)
+			}
+			continue
+		}
+		if err != nil || len(data) != test.size {
+			t.Errorf("%d bytes at limit %d read %d bytes, %v", test.size, test.limit, len(data), err)
+		}
+	}
+}
+
+// tarEntry returns a tar stream holding one file of data, cut to keep
+// bytes if keep is at least zero.
+func tarEntry(t *testing.T, data []byte, keep int) []byte {
+	t.Helper()
+
+	archive := bytes.Buffer{}
+	writer := tar.NewWriter(&archive)
+	if err := writer.WriteHeader(&tar.Header{Name: "report.xml", Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(data)
+	writer.Close()
+	if keep >= 0 {
+		return archive.Bytes()[:keep]
+	}
+	return archive.Bytes()
+}
+
+func TestReadTarEntry(t *testing.T) {
+	report := []byte(strings.Repeat("<testcase/>", 100))
+	tests := []struct {
+		name  string
+		tar   []byte
+		limit int64
+		want  error
+	}{
+		{"complete", tarEntry(t, report, -1), 4096, nil},
+		{"at the limit", tarEntry(t, report, -1), int64(len(report)), nil},
+		{"header over the limit", tarEntry(t, report, -1), int64(len(report)) - 1, errReportTooLarge},
+		{"cut mid-file", tarEntry(t, report, 512+len(report)/2), 4096, errTruncatedReport},
+		{"cut after the header", tarEntry(t, report, 512), 4096, errTruncatedReport},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := tar.NewReader(bytes.NewReader(test.tar))
+			hdr, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := readTarEntry(reader, hdr, test.limit)
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Errorf("got %v, want %v", err, test.want)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(data, report) {
+				t.Errorf("read %d bytes, %v; want the whole report", len(data), err)
+			}
+		})
+	}
+}
+
+// A container removed mid-copy cuts the tar stream short, which has to fail
+// the run rather than grade a partial report.
+func TestRunImageTruncatedReport(t *testing.T) {
+	docker := newFakeDocker(t).withReport(junitReport(manyCases(50)...))
+	docker.truncateReport = true
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, nil)
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	_, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	if !errors.Is(err, errTruncatedReport) {
+		t.Errorf("truncated report gave %v, want errTruncatedReport", err)
+	}
+}
+
+func TestAddCaseTruncation(t *testing.T) {
+	tests := []struct {
+		cases, maxCases int
+		listed          int
+		truncated       bool
+	}{
+		{5, 0, 5, false},
+		{5, 10, 5, false},
+		{5, 5, 5, false},
+		{6, 5, 5, true},
+		{100, 1, 1, true},
+	}
+	for _, test := range tests {
+		result := newRunResult()
+		for i := range test.cases {
+			result.addCase(TestCase{Name: strings.Repeat("x", i+1), Status: StatusPassed, Duration: 1}, test.maxCases)
+		}
+		if len(result.Cases) != test.listed || result.Truncated != test.truncated {
+			t.Errorf("%d cases capped at %d listed %d, truncated %v; want %d, %v", test.cases, test.maxCases, len(result.Cases), result.Truncated, test.listed, test.truncated)
+		}
+		if result.Total != test.cases || result.Passed != test.cases || result.Duration != float64(test.cases) {
+			t.Errorf("%d cases capped at %d count %d of %d over %gs", test.cases, test.maxCases, result.Passed, result.Total, result.Duration)
+		}
+	}
+}
+
+func TestBuildResultCaseCap(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"MAX_REPORT_CASES": "2"})
+
+	result, err := buildResult(cfg, "sum", &Execution{Report: []byte(junitReport(manyCases(5)...)), ReportFormat: reportJUnit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Cases) != 2 || !result.Truncated || result.Total != 5 {
+		t.Errorf("listed %d of %d cases, truncated %v; want 2 of 5, truncated", len(result.Cases), result.Total, result.Truncated)
+	}
+}
+
+func TestPollReport(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, nil)
+	ctx := context.Background()
+	created, err := docker.client.ContainerCreate(ctx, &container.Config{Image: "base"}, &container.HostConfig{}, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportPath := path.Join(defaultWorkingDir, "report.xml")
+	setReport := func(report string) {
+		docker.mu.Lock()
+		defer docker.mu.Unlock()
+		docker.files[reportPath] = []byte(report)
+	}
+
+	if report, complete := pollReport(ctx, cfg, docker.client, Metadata{}, created.ID, nil); report != nil || complete {
+		t.Errorf("poll before the report exists got %q, complete %v", report, complete)
+	}
+
+	// A report still being written isn't taken, even once it stops
+	// changing, until it parses.
+	setReport("<testsuites><testsuite")
+	previous, complete := pollReport(ctx, cfg, docker.client, Metadata{}, created.ID, nil)
+	if complete {
+		t.Error("first sight of a report taken as complete")
+	}
+	if previous, complete = pollReport(ctx, cfg, docker.client, Metadata{}, created.ID, previous); complete {
+		t.Error("unparsable report taken as complete")
+	}
+
+	full := junitReport(`<testcase name="adds" classname="test.ts"/>`)
+	setReport(full)
+	if previous, complete = pollReport(ctx, cfg, docker.client, Metadata{}, created.ID, previous); complete {
+		t.Error("report that changed since the last poll taken as complete")
+	}
+	report, complete := pollReport(ctx, cfg, docker.client, Metadata{}, created.ID, previous)
+	if !complete || string(report) != full {
+		t.Errorf("unchanged, parsable report got %q, complete %v", report, complete)
+	}
+}
+
+// A runner that lingers after writing its report is killed once the report
+// is complete, instead of holding the run until it times out.
+func TestRunImagePollsReport(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.images["base"] = fakeImage(nil)
+	docker.runFor = time.Minute
+	full := junitReport(`<testcase name="adds" classname="test.ts"/>`, `<testcase name="subtracts" classname="test.ts"/>`)
+	docker.onStart = func(c *fakeContainer) {
+		docker.mu.Lock()
+		docker.files[path.Join(defaultWorkingDir, "report.xml")] = []byte("<testsuites>")
+		docker.mu.Unlock()
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			docker.mu.Lock()
+			docker.files[path.Join(defaultWorkingDir, "report.xml")] = []byte(full)
+			docker.mu.Unlock()
+		}()
+	}
+	cfg := testConfig(t, map[string]string{"REPORT_POLL_INTERVAL": "10ms"})
+
+	started := time.Now()
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	execution, err := runImage(context.Background(), cfg, docker.client, req, Metadata{PollReport: true}, "base", &Execution{ExitCode: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("run took %s, as if it waited for the runner to exit", elapsed)
+	}
+	if !execution.ReportPolled || string(execution.Report) != full {
+		t.Errorf("run polled %v and got report %q, want the full report", execution.ReportPolled, execution.Report)
+	}
+	if execution.ExitReason != "killed after writing its report" {
+		t.Errorf("exit reason is %q", execution.ExitReason)
+	}
+	if signals := docker.Container().Signals; !slices.Equal(signals, []string{"SIGKILL"}) {
+		t.Errorf("container got signals %v, want SIGKILL", signals)
+	}
+	if !docker.Called("GET /containers/c1/archive") {
+		t.Error("report wasn't polled")
+	}
+
+	result, err := buildResult(cfg, req.Task, execution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Passed != 2 || result.Failed != 0 {
+		t.Errorf("result is %d passed, %d failed, want 2 and 0", result.Passed, result.Failed)
+	}
+}
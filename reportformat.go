@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	reportJUnit = "junit"
+	reportTAP   = "tap"
+	reportJSON  = "json"
+)
+
+// reportParser converts a report into a result listing at most maxCases
+// cases, or all of them when maxCases is zero.
+type reportParser func(report []byte, maxCases int) (RunResult, error)
+
+// reportParsers are the report formats tasks may produce, keyed by the name
+// used in a task's reportFormat.
+var reportParsers = map[string]reportParser{
+	reportJUnit: parseJUnit,
+	reportTAP:   parseTAP,
+	reportJSON:  parseJSONReport,
+}
+
+// detectReportFormat guesses a report's format from its file extension,
+// falling back to its first non-blank bytes. Anything unrecognised is
+// treated as JUnit, the packaged runner's format.
+func detectReportFormat(name string, report []byte) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".xml":
+		return reportJUnit
+	case ".tap":
+		return reportTAP
+	case ".json":
+		return reportJSON
+	}
+
+	content := bytes.TrimSpace(report)
+	switch {
+	case bytes.HasPrefix(content, []byte("{")), bytes.HasPrefix(content, []byte("[")):
+		return reportJSON
+	case bytes.HasPrefix(content, []byte("TAP version")), bytes.HasPrefix(content, []byte("1..")),
+		bytes.HasPrefix(content, []byte("ok")), bytes.HasPrefix(content, []byte("not ok")):
+		return reportTAP
+	}
+	return reportJUnit
+}
+
+// parseSlots caps how many reports are parsed at once, however many runs
+// finish together. It is set at startup from REPORT_PARSE_WORKERS; nil
+// doesn't limit.
+var parseSlots *limiter
+
+// parseReport parses a report in the given format, detecting it from name
+// and content when format is empty. It waits for one of parseSlots.
+func parseReport(format string, name string, report []byte, maxCases int) (RunResult, error) {
+	parseSlots.Acquire(context.Background())
+	defer parseSlots.Release()
+
+	if format == "" {
+		format = detectReportFormat(name, report)
+	}
+	parser, ok := reportParsers[format]
+	if !ok {
+		return newRunResult(), fmt.Errorf("%w: unknown report format %q", errInvalidReport, format)
+	}
+	return parser(report, maxCases)
+}
+
+// jsonReport is the JSON report format: either this object or a bare array
+// of its cases. Each case's status is one of the TestCase statuses, and the
+// counts are derived from the cases.
+type jsonReport struct {
+	Cases []TestCase `json:"cases"`
+}
+
+func parseJSONReport(report []byte, maxCases int) (RunResult, error) {
+	result := newRunResult()
+
+	doc := jsonReport{}
+	var err error
+	if content := bytes.TrimSpace(report); bytes.HasPrefix(content, []byte("[")) {
+		err = json.Unmarshal(content, &doc.Cases)
+	} else {
+		err = json.Unmarshal(content, &doc)
+	}
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", errInvalidReport, err)
+	}
+
+	for _, testCase := range doc.Cases {
+		switch testCase.Status {
+		case StatusPassed, StatusFailed, StatusError, StatusSkipped:
+		default:
+			return result, fmt.Errorf("%w: case %q has unknown status %q", errInvalidReport, testCase.Name, testCase.Status)
+		}
+		result.addCase(testCase, maxCases)
+	}
+	return result, nil
+}
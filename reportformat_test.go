@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectReportFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		report string
+		want   string
+	}{
+		{"xml extension", "/test/report.xml", "ok 1 - looks like TAP", reportJUnit},
+		{"tap extension", "/test/report.TAP", "<testsuites/>", reportTAP},
+		{"json extension", "/test/report.json", "", reportJSON},
+		{"json object", "/test/report", "\n  {\"cases\": []}", reportJSON},
+		{"json array", "/test/report", "[]", reportJSON},
+		{"tap version", "/test/report", "TAP version 13\n1..0\n", reportTAP},
+		{"tap plan", "/test/report", "1..2\nok 1\nok 2\n", reportTAP},
+		{"tap failure first", "/test/report", "not ok 1 - adds\n", reportTAP},
+		{"junit", "/test/report", "<?xml version=\"1.0\"?><testsuites/>", reportJUnit},
+		{"unrecognised", "/test/report", "garbage", reportJUnit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectReportFormat(tt.file, []byte(tt.report)); got != tt.want {
+				t.Errorf("detectReportFormat(%q, %q) = %q, want %q", tt.file, tt.report, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReportFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		report string
+	}{
+		{"junit", "", junitReport(
+			`<testcase name="adds" classname="test.ts"/>`,
+			`<testcase name="subtracts" classname="test.ts"><failure message="expected 1"/></testcase>`,
+			`<testcase name="later" classname="test.ts"><skipped/></testcase>`,
+		)},
+		{"tap", reportTAP, "TAP version 13\n1..3\nok 1 - adds\nnot ok 2 - subtracts\nok 3 - later # SKIP\n"},
+		{"json", reportJSON, `{"cases": [
+			{"name": "adds", "status": "passed"},
+			{"name": "subtracts", "status": "failed", "message": "expected 1"},
+			{"name": "later", "status": "skipped"}
+		]}`},
+		{"json array", "", `[
+			{"name": "adds", "status": "passed"},
+			{"name": "subtracts", "status": "error"},
+			{"name": "later", "status": "skipped"}
+		]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseReport(tt.format, "/test/report", []byte(tt.report), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != 3 || result.Passed != 1 || result.Failed != 1 || result.Skipped != 1 {
+				t.Errorf("got total %d, passed %d, failed %d, skipped %d; want 3, 1, 1, 1",
+					result.Total, result.Passed, result.Failed, result.Skipped)
+			}
+			names := []string{}
+			for _, c := range result.Cases {
+				names = append(names, c.Name)
+			}
+			if len(names) != 3 || names[0] != "adds" || names[1] != "subtracts" || names[2] != "later" {
+				t.Errorf("got cases %q, want adds, subtracts, later", names)
+			}
+		})
+	}
+}
+
+func TestParseReportInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		report string
+	}{
+		{"unknown format", "yaml", "cases: []"},
+		{"malformed json", reportJSON, `{"cases": [`},
+		{"unknown json status", reportJSON, `[{"name": "adds", "status": "flaky"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseReport(tt.format, "/test/report", []byte(tt.report), 0)
+			if !errors.Is(err, errInvalidReport) {
+				t.Errorf("got %v, want errInvalidReport", err)
+			}
+		})
+	}
+}
+
+func TestParseTAPDirectivesAndDiagnostics(t *testing.T) {
+	report := "TAP version 13\n" +
+		"1..5\n" +
+		"ok 1 - handles \\# in names\n" +
+		"not ok 2 - unfinished # TODO not written yet\n" +
+		"ok 3 - flaky # skip needs network\n" +
+		"not ok 4 - subtracts\n" +
+		"  ---\n" +
+		"  status: error\n" +
+		"  message: \"boom\"\n" +
+		"  ...\n" +
+		"    ok 1 - an indented subtest is ignored\n" +
+		"Bail out! out of memory\n" +
+		"ok 5 - never reached\n"
+
+	result, err := parseTAP([]byte(report), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TestCase{
+		{Name: "handles # in names", Status: StatusPassed},
+		{Name: "unfinished", Status: StatusSkipped, Message: "not written yet"},
+		{Name: "flaky", Status: StatusSkipped, Message: "needs network"},
+		{Name: "subtracts", Status: StatusError, Message: "boom"},
+		{Name: "bail out", Status: StatusError, Message: "out of memory"},
+	}
+	if len(result.Cases) != len(want) {
+		t.Fatalf("got %d cases %+v, want %d", len(result.Cases), result.Cases, len(want))
+	}
+	for i, c := range result.Cases {
+		if c.Name != want[i].Name || c.Status != want[i].Status || c.Message != want[i].Message {
+			t.Errorf("case %d = %+v, want %+v", i, c, want[i])
+		}
+	}
+	if result.Passed != 1 || result.Skipped != 2 || result.Failed != 2 {
+		t.Errorf("got passed %d, skipped %d, failed %d; want 1, 2, 2", result.Passed, result.Skipped, result.Failed)
+	}
+}
+
+func TestTAPRoundTrip(t *testing.T) {
+	result := newRunResult()
+	for _, c := range []TestCase{
+		{Name: "adds", Status: StatusPassed},
+		{Name: "uses # signs", Status: StatusFailed, Message: "expected 1\ngot 2", Details: "at test.ts:3"},
+		{Name: "later", Status: StatusSkipped, Message: "not yet"},
+	} {
+		result.addCase(c, 0)
+	}
+
+	parsed, err := parseTAP(toTAP(result), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Cases) != len(result.Cases) {
+		t.Fatalf("round trip gave %d cases, want %d", len(parsed.Cases), len(result.Cases))
+	}
+	for i, c := range parsed.Cases {
+		want := result.Cases[i]
+		if c.Name != want.Name || c.Status != want.Status || c.Message != want.Message || c.Details != want.Details {
+			t.Errorf("case %d round-tripped to %+v, want %+v", i, c, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/mount"
+)
+
+const (
+	reportCopy  = "copy"
+	reportMount = "mount"
+)
+
+// reportVolume is a host directory bind-mounted into a test container so its
+// report can be read from the host once the run ends, for daemons whose
+// CopyFromContainer is unreliable. The host path must be on the daemon's
+// host, so REPORT_MOUNT_DIR matters when the server runs elsewhere.
+type reportVolume struct {
+	root   string
+	source string
+	target string
+	dir    bool
+}
+
+// newReportVolume creates the host side of the task's report mount: a
+// directory for a ReportDir, or an empty file for a ReportPath, writable by
+// whatever user the test runs as.
+func newReportVolume(cfg *Config, meta Metadata) (*reportVolume, error) {
+	root, err := os.MkdirTemp(cfg.ReportMountDir, "gitblame-report-")
+	if err != nil {
+		return nil, fmt.Errorf("creating report dir: %w", err)
+	}
+	volume := &reportVolume{root: root, source: root, target: meta.reportDir(), dir: true}
+	if err := os.Chmod(root, 0777); err != nil {
+		volume.Remove()
+		return nil, fmt.Errorf("creating report dir: %w", err)
+	}
+
+	if volume.target == "" {
+		volume.source = filepath.Join(root, "report"+path.Ext(meta.reportPath()))
+		volume.target = meta.reportPath()
+		volume.dir = false
+		if err := os.WriteFile(volume.source, nil, 0666); err == nil {
+			err = os.Chmod(volume.source, 0666)
+		}
+		if err != nil {
+			volume.Remove()
+			return nil, fmt.Errorf("creating report file: %w", err)
+		}
+	}
+
+	return volume, nil
+}
+
+func (v *reportVolume) Mount() mount.Mount {
+	return mount.Mount{Type: mount.TypeBind, Source: v.source, Target: v.target}
+}
+
+// Read returns the report the run wrote, merging a directory's JUnit
+// reports like readReportDir. An empty report file counts as missing.
+func (v *reportVolume) Read(maxSize int64) ([]byte, error) {
+	if !v.dir {
+		file, err := os.Open(v.source)
+		if err != nil {
+			return nil, fmt.Errorf("opening report: %w", err)
+		}
+		defer file.Close()
+
+		data, err := readLimited(file, maxSize)
+		if err != nil {
+			return nil, fmt.Errorf("reading report: %w", err)
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("getting report: %w", cerrdefs.ErrNotFound)
+		}
+		return data, nil
+	}
+
+	parsed := [][]junitTestSuite{}
+	remaining := maxSize
+	err := filepath.WalkDir(v.source, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || !strings.EqualFold(filepath.Ext(file), ".xml") {
+			return nil
+		}
+
+		reportFile, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer reportFile.Close()
+		data, err := readLimited(reportFile, remaining)
+		if err != nil {
+			return fmt.Errorf("reading report %s: %w", file, err)
+		}
+		remaining -= int64(len(data))
+
+		suites, err := parseJUnitSuites(data)
+		if err != nil {
+			return fmt.Errorf("report %s: %w", file, err)
+		}
+		parsed = append(parsed, suites)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("no reports found in %s: %w", v.target, cerrdefs.ErrNotFound)
+	}
+
+	return mergeReports(parsed)
+}
+
+// Remove deletes the host side of the mount. Files the container created
+// as another user may resist; that is logged rather than failing the run.
+func (v *reportVolume) Remove() {
+	if err := os.RemoveAll(v.root); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("removing report dir", "dir", v.root, "error", err)
+	}
+}
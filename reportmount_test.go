@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cerrdefs "github.com/containerd/errdefs"
+)
+
+func TestReportStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		meta     Metadata
+		files    map[string]string
+	}{
+		{"copy file", reportCopy, Metadata{}, map[string]string{
+			"/test/report.xml": junitReport(`<testcase name="adds"/>`, `<testcase name="subtracts"/>`),
+		}},
+		{"mount file", reportMount, Metadata{}, map[string]string{
+			"/test/report.xml": junitReport(`<testcase name="adds"/>`, `<testcase name="subtracts"/>`),
+		}},
+		{"copy dir", reportCopy, Metadata{ReportDir: "reports"}, map[string]string{
+			"/test/reports/a.xml": junitReport(`<testcase name="adds"/>`),
+			"/test/reports/b.xml": junitReport(`<testcase name="subtracts"/>`),
+		}},
+		{"mount dir", reportMount, Metadata{ReportDir: "reports"}, map[string]string{
+			"/test/reports/a.xml":        junitReport(`<testcase name="adds"/>`),
+			"/test/reports/nested/b.xml": junitReport(`<testcase name="subtracts"/>`),
+			"/test/reports/notes.txt":    "not a report",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docker := newFakeDocker(t)
+			for name, data := range tt.files {
+				docker.files[name] = []byte(data)
+			}
+			docker.images["base"] = fakeImage(nil)
+			cfg := testConfig(t, map[string]string{"REPORT_STRATEGY": tt.strategy, "REPORT_MOUNT_DIR": t.TempDir()})
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			execution, err := runImage(context.Background(), cfg, docker.client, req, tt.meta, "base", &Execution{ExitCode: -1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := parseJUnit(execution.Report, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != 2 || result.Passed != 2 {
+				t.Errorf("got %d of %d passed, want 2 of 2", result.Passed, result.Total)
+			}
+
+			c := docker.Container()
+			copied := docker.Called("GET /containers/" + c.ID + "/archive")
+			if tt.strategy == reportCopy {
+				if !copied || len(c.HostConfig.Mounts) != 0 {
+					t.Errorf("copy strategy: copied %v with mounts %+v, want a copy and no mounts", copied, c.HostConfig.Mounts)
+				}
+				return
+			}
+			if copied {
+				t.Error("mount strategy copied the report out of the container")
+			}
+			if len(c.HostConfig.Mounts) != 1 || c.HostConfig.Mounts[0].Target != cmp.Or(tt.meta.reportDir(), tt.meta.reportPath()) {
+				t.Fatalf("mounts are %+v, want one at the report path", c.HostConfig.Mounts)
+			}
+			if _, err := os.Stat(c.HostConfig.Mounts[0].Source); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("report mount %s is left after the run: %v", c.HostConfig.Mounts[0].Source, err)
+			}
+		})
+	}
+}
+
+func TestReportVolumeMissingReport(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"REPORT_MOUNT_DIR": t.TempDir()})
+	for _, meta := range []Metadata{{}, {ReportDir: "reports"}} {
+		volume, err := newReportVolume(cfg, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The file is created empty, so the mount exists before the run.
+		if info, err := os.Stat(volume.source); err != nil || info.IsDir() != volume.dir {
+			t.Errorf("mount source %s: %v, want it created", volume.source, err)
+		}
+		if _, err := volume.Read(1 << 20); !cerrdefs.IsNotFound(err) {
+			t.Errorf("reading an unwritten report gave %v, want not found", err)
+		}
+		volume.Remove()
+		if _, err := os.Stat(volume.root); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s is left after Remove", volume.root)
+		}
+	}
+}
+
+func TestReportVolumeReadLimit(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"REPORT_MOUNT_DIR": t.TempDir()})
+	volume, err := newReportVolume(cfg, Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer volume.Remove()
+
+	report := junitReport(`<testcase name="adds"/>`)
+	if err := os.WriteFile(volume.source, []byte(report), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volume.Read(int64(len(report) - 1)); !errors.Is(err, errReportTooLarge) {
+		t.Errorf("reading a report over the limit gave %v, want errReportTooLarge", err)
+	}
+	if data, err := volume.Read(int64(len(report))); err != nil || string(data) != report {
+		t.Errorf("reading a report at the limit gave %q, %v", data, err)
+	}
+	if filepath.Dir(volume.root) != cfg.ReportMountDir {
+		t.Errorf("volume is in %s, want REPORT_MOUNT_DIR %s", filepath.Dir(volume.root), cfg.ReportMountDir)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the suffixes parseByteSize accepts, as Docker does.
+var byteUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30,
+}
+
+// parseByteSize parses a size such as "512m" or "2g". Suffixes are binary
+// multiples and case-insensitive; a bare number is in bytes.
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	digits := strings.TrimRight(value, "bkmg")
+	unit, ok := byteUnits[value[len(digits):]]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * unit, nil
+}
+
+// minMemoryLimit is the smallest memory limit the daemon accepts.
+const minMemoryLimit = 6 << 20
+
+// runResources returns the memory limit in bytes and the CPU limit in
+// billionths of a CPU for the task's test containers: the task's own limits
+// or else the server's defaults, clamped to the server's caps. Zero is
+// unlimited, which a cap also clamps.
+func runResources(cfg *Config, meta Metadata) (int64, int64) {
+	memory := cfg.RunMemoryLimit
+	if meta.MemoryLimit != "" {
+		// validate has already parsed it.
+		memory, _ = parseByteSize(meta.MemoryLimit)
+	}
+	if cfg.RunMaxMemoryLimit > 0 && (memory == 0 || memory > cfg.RunMaxMemoryLimit) {
+		memory = cfg.RunMaxMemoryLimit
+	}
+
+	cpus := cfg.RunCPULimit
+	if meta.CPULimit > 0 {
+		cpus = meta.CPULimit
+	}
+	if cfg.RunMaxCPULimit > 0 && (cpus == 0 || cpus > cfg.RunMaxCPULimit) {
+		cpus = cfg.RunMaxCPULimit
+	}
+
+	return memory, int64(cpus * 1e9)
+}
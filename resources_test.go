@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		valid bool
+	}{
+		{"0", 0, true},
+		{"1048576", 1 << 20, true},
+		{"512b", 512, true},
+		{"64k", 64 << 10, true},
+		{"512m", 512 << 20, true},
+		{"512MB", 512 << 20, true},
+		{"2g", 2 << 30, true},
+		{" 1G ", 1 << 30, true},
+		{"", 0, false},
+		{"m", 0, false},
+		{"1.5g", 0, false},
+		{"-1m", 0, false},
+		{"1t", 0, false},
+		{"1mm", 0, false},
+		{"9999999999g", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseByteSize(test.value)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("parseByteSize(%q) = %d, want an error", test.value, got)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("parseByteSize(%q) = %d, %v, want %d", test.value, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestRunResources(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		meta       Metadata
+		wantMemory int64
+		wantCPUs   int64
+	}{
+		{"unlimited", nil, Metadata{}, 0, 0},
+		{"server defaults", map[string]string{"RUN_MEMORY_LIMIT": "256m", "RUN_CPU_LIMIT": "1"}, Metadata{}, 256 << 20, 1e9},
+		{"task overrides", map[string]string{"RUN_MEMORY_LIMIT": "256m", "RUN_CPU_LIMIT": "1"}, Metadata{MemoryLimit: "1g", CPULimit: 2}, 1 << 30, 2e9},
+		{"task within the caps", map[string]string{"RUN_MAX_MEMORY_LIMIT": "2g", "RUN_MAX_CPU_LIMIT": "4"}, Metadata{MemoryLimit: "1g", CPULimit: 0.5}, 1 << 30, 5e8},
+		{"task over the caps", map[string]string{"RUN_MAX_MEMORY_LIMIT": "512m", "RUN_MAX_CPU_LIMIT": "1.5"}, Metadata{MemoryLimit: "1g", CPULimit: 2}, 512 << 20, 15e8},
+		{"defaults over the caps", map[string]string{"RUN_MEMORY_LIMIT": "1g", "RUN_MAX_MEMORY_LIMIT": "512m", "RUN_CPU_LIMIT": "2", "RUN_MAX_CPU_LIMIT": "1"}, Metadata{}, 512 << 20, 1e9},
+		{"caps limit unlimited", map[string]string{"RUN_MAX_MEMORY_LIMIT": "512m", "RUN_MAX_CPU_LIMIT": "1"}, Metadata{}, 512 << 20, 1e9},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memory, nanoCPUs := runResources(testConfig(t, test.env), test.meta)
+			if memory != test.wantMemory || nanoCPUs != test.wantCPUs {
+				t.Errorf("limits are %d bytes and %d nano-CPUs, want %d and %d", memory, nanoCPUs, test.wantMemory, test.wantCPUs)
+			}
+		})
+	}
+}
+
+func TestHostConfigAppliesResources(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"RUN_MAX_MEMORY_LIMIT": "512m"})
+	resources := hostConfig(cfg, Metadata{MemoryLimit: "1g", CPULimit: 0.5}, nil).Resources
+	if resources.Memory != 512<<20 || resources.NanoCPUs != 5e8 {
+		t.Errorf("container gets %d bytes and %d nano-CPUs, want %d and %d", resources.Memory, resources.NanoCPUs, 512<<20, int64(5e8))
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	tests := []struct {
+		name  string
+		meta  Metadata
+		valid bool
+	}{
+		{"unset", Metadata{}, true},
+		{"limits", Metadata{MemoryLimit: "1g", CPULimit: 1.5}, true},
+		{"memory below the daemon's minimum", Metadata{MemoryLimit: "4m"}, false},
+		{"unparsable memory", Metadata{MemoryLimit: "lots"}, false},
+		{"negative CPUs", Metadata{CPULimit: -1}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.meta.validate()
+			if test.valid && err != nil {
+				t.Errorf("valid resources rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("invalid resources accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resultSchemaVersion versions the JSON shape of RunResult. Adding fields
+// bumps the minor version; renaming, removing or changing the meaning of a
+// field bumps the major version.
+//
+// 2.0.0 classifies skipped cases: they count in Skipped and Total but no
+// longer in Passed, and a case's status may be "skipped". Clients that read
+// Passed as "didn't fail" must add Skipped back. Total and the other counts
+// also follow a JUnit suite's tests, failures, errors and skipped attributes
+// when they report more cases than the suite lists, so Total may exceed
+// len(Cases) even when Truncated is false.
+const resultSchemaVersion = "2.0.0"
+
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+)
+
+// TestCase is the outcome of a single test.
+type TestCase struct {
+	// ID identifies the case across runs of the same task: its suite and
+	// name, whitespace-normalized and joined by "::". A name that repeats
+	// within a suite gets "#2", "#3" and so on in report order.
+	ID string `json:"id"`
+	// Name is the test's name as reported by the runner.
+	Name string `json:"name"`
+	// Suite is the JUnit classname, or the suite name when it has none.
+	Suite string `json:"suite"`
+	// Status is one of "passed", "failed", "error" or "skipped".
+	Status string `json:"status"`
+	// Message is the failure or error message, or the skip reason, if any.
+	Message string `json:"message,omitempty"`
+	// Details is the failure body, typically a stack trace or diff.
+	Details string `json:"details,omitempty"`
+	// Duration is how long the test took in seconds, or zero if the runner
+	// didn't say.
+	Duration float64 `json:"duration,omitempty"`
+
+	// SystemOut and SystemErr hold the output the runner attributed to this
+	// test.
+	SystemOut string `json:"systemOut,omitempty"`
+	SystemErr string `json:"systemErr,omitempty"`
+}
+
+// RunResult is the JSON result of a test run.
+type RunResult struct {
+	// SchemaVersion is the resultSchemaVersion the result was encoded with.
+	SchemaVersion string `json:"schemaVersion"`
+
+	// Passed, Failed, Skipped and Total count the cases. Errors count as
+	// failed; skipped cases count towards Total only. When a suite's tests
+	// attribute reports more cases than it lists, its failures, errors and
+	// skipped attributes account for the difference.
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	Total   int `json:"total"`
+	// Assertions sums the assertion counts the runner reported, if any.
+	Assertions int `json:"assertions,omitempty"`
+	// Duration sums the cases' durations in seconds, including cases
+	// dropped by truncation.
+	Duration float64 `json:"duration,omitempty"`
+	// Cases lists the tests in report order. It is never null.
+	Cases []TestCase `json:"cases"`
+	// Truncated is set when the report had more cases than the server
+	// returns; the counts still cover all of them.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// TimedOut marks a partial result recovered from a run that was stopped
+	// at the timeout.
+	TimedOut bool `json:"timedOut,omitempty"`
+	// MemoryExceeded marks a partial result from a run that was killed for
+	// nearing its memory limit.
+	MemoryExceeded bool `json:"memoryExceeded,omitempty"`
+	// ExitReason says why the test container stopped, such as "exited with
+	// code 1" or "killed by SIGSEGV (signal 11)".
+	ExitReason string `json:"exitReason,omitempty"`
+	// RuntimeError is the uncaught exception the run's stderr shows, if any.
+	RuntimeError *RuntimeError `json:"runtimeError,omitempty"`
+
+	// Runs is how many times the submission was run, when ?repeat asked
+	// for more than one, and Flaky lists the cases whose outcome varied
+	// between those runs. The rest of the result is from the first run.
+	Runs  int         `json:"runs,omitempty"`
+	Flaky []FlakyCase `json:"flaky,omitempty"`
+
+	// TaskVersion identifies the version of the task's tests, Dockerfile
+	// and metadata the run was graded against; it changes whenever they do.
+	TaskVersion string `json:"taskVersion,omitempty"`
+
+	// CodeHash is the SHA-256 of the code that was evaluated, hex-encoded.
+	// Code is that code, when the server is configured to echo it and it
+	// fits ECHO_CODE_MAX_BYTES.
+	CodeHash string `json:"codeHash,omitempty"`
+	Code     string `json:"code,omitempty"`
+
+	// Resources is the CPU and memory the run consumed.
+	Resources *ResourceUsage `json:"resources,omitempty"`
+
+	// Debug holds details about how the run was carried out.
+	Debug *RunDebug `json:"debug,omitempty"`
+
+	// Annotations holds values added by post-processors.
+	Annotations map[string]any `json:"annotations,omitempty"`
+
+	// ReportRaw is the report the result was parsed from, base64-encoded
+	// in JSON, when the client asks for it with ?rawReport=1. A task with a
+	// report dir gets its merged JUnit.
+	ReportRaw []byte `json:"reportRaw,omitempty"`
+
+	// Output is the container's stdout and OutputDiff its unified diff
+	// against the expected output, for output-matching tasks.
+	Output     string `json:"output,omitempty"`
+	OutputDiff string `json:"outputDiff,omitempty"`
+}
+
+// FlakyCase is a case that both passed and failed across repeated runs.
+type FlakyCase struct {
+	Name  string `json:"name"`
+	Suite string `json:"suite"`
+	// Passed and Failed count the runs with each outcome; errors count as
+	// failed and skipped runs aren't counted.
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+	// Score is 2*min(Passed, Failed)/(Passed+Failed): near 0 for a case that
+	// rarely flips and 1 for one that passes exactly half the time.
+	Score float64 `json:"score"`
+}
+
+// RunDebug is the debug section of a result.
+type RunDebug struct {
+	// BuildCache is set when the run built an image.
+	BuildCache *BuildCache `json:"buildCache,omitempty"`
+}
+
+func newRunResult() RunResult {
+	return RunResult{SchemaVersion: resultSchemaVersion, Cases: []TestCase{}}
+}
+
+// recordCode records what was evaluated: always its hash, and the code
+// itself when ECHO_CODE is set and it is small enough.
+func (r *RunResult) recordCode(cfg *Config, code string) {
+	r.CodeHash = codeHash(code)
+	if cfg.EchoCode && len(code) <= cfg.EchoCodeMaxBytes {
+		r.Code = code
+	}
+}
+
+// assignCaseIDs sets the ID of every listed case.
+func (r *RunResult) assignCaseIDs() {
+	seen := map[string]int{}
+	for i := range r.Cases {
+		id := strings.Join(strings.Fields(r.Cases[i].Suite), " ") + "::" + strings.Join(strings.Fields(r.Cases[i].Name), " ")
+		seen[id]++
+		if n := seen[id]; n > 1 {
+			id = fmt.Sprintf("%s#%d", id, n)
+		}
+		r.Cases[i].ID = id
+	}
+}
+
+// addCase counts testCase towards the result's totals and lists it, unless
+// maxCases are already listed; zero lists every case.
+func (r *RunResult) addCase(testCase TestCase, maxCases int) {
+	switch testCase.Status {
+	case StatusPassed:
+		r.Passed++
+	case StatusSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	r.Total++
+	r.Duration += testCase.Duration
+	if maxCases > 0 && len(r.Cases) >= maxCases {
+		r.Truncated = true
+		return
+	}
+	r.Cases = append(r.Cases, testCase)
+}
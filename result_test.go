@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files under testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading golden file: %v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encoding doesn't match %s (run with -update if the change is intended)\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+// goldenResult has every field of RunResult set, so that renaming or
+// dropping one shows in the golden file.
+func goldenResult(t *testing.T) RunResult {
+	t.Helper()
+
+	report := junitReport(
+		`<testcase name="adds" classname="sum" time="0.25"/>`,
+		`<testcase name="adds negatives" classname="sum" time="0.5"><failure message="expected -3">AssertionError: expected -3, got 3</failure><system-out>adding</system-out><system-err>oops</system-err></testcase>`,
+		`<testcase name="adds floats" classname="sum"><skipped message="not yet"/></testcase>`,
+	)
+	result, err := parseReport(reportJUnit, "report.xml", []byte(report), 0)
+	if err != nil {
+		t.Fatalf("parsing report: %v", err)
+	}
+
+	result.assignCaseIDs()
+	result.Assertions = 7
+	result.Truncated = true
+	result.TimedOut = true
+	result.MemoryExceeded = true
+	result.ExitReason = "exited with code 1"
+	result.RuntimeError = &RuntimeError{Type: "TypeError", Message: "x is undefined", Frame: &StackFrame{Function: "sum", File: "code.ts", Line: 3, Column: 10}}
+	result.Runs = 4
+	result.Flaky = []FlakyCase{{Name: "adds", Suite: "sum", Passed: 2, Failed: 2, Score: 1}}
+	result.TaskVersion = "0123456789ab"
+	result.CodeHash = codeHash("export const sum = 1")
+	result.Code = "export const sum = 1"
+	result.Resources = &ResourceUsage{CPUTime: 1500000, PeakMemory: 64 << 20, Samples: 3}
+	result.Debug = &RunDebug{BuildCache: &BuildCache{Cached: 3, Built: 1, HitRatio: 0.75}}
+	result.Annotations = map[string]any{"grader": "strict"}
+	result.ReportRaw = []byte("<testsuites/>")
+	result.Output = "3\n"
+	result.OutputDiff = "-4\n+3\n"
+	return result
+}
+
+func TestResultSchemaGolden(t *testing.T) {
+	got, err := json.MarshalIndent(goldenResult(t), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "result.json", append(got, '\n'))
+}
+
+func TestResultSummarySchemaGolden(t *testing.T) {
+	got, err := json.MarshalIndent(summarize(goldenResult(t)), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "summary.json", append(got, '\n'))
+}
+
+// An empty result still encodes its version and an empty case list.
+func TestResultSchemaEmpty(t *testing.T) {
+	got, err := json.Marshal(newRunResult())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"schemaVersion":"` + resultSchemaVersion + `","passed":0,"failed":0,"skipped":0,"total":0,"cases":[]}`
+	if string(got) != want {
+		t.Errorf("empty result encodes as %s, want %s", got, want)
+	}
+}
+
+func TestRecordCode(t *testing.T) {
+	code := "export const sum = (a: number, b: number) => a + b\n"
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"hash only", nil, ""},
+		{"echoed", map[string]string{"ECHO_CODE": "true"}, code},
+		{"too large to echo", map[string]string{"ECHO_CODE": "true", "ECHO_CODE_MAX_BYTES": "10"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := newRunResult()
+			result.recordCode(testConfig(t, tt.env), code)
+			if want := fmt.Sprintf("%x", sha256.Sum256([]byte(code))); result.CodeHash != want {
+				t.Errorf("code hash is %s, want %s", result.CodeHash, want)
+			}
+			if result.Code != tt.want {
+				t.Errorf("code is %q, want %q", result.Code, tt.want)
+			}
+		})
+	}
+}
+
+// The hash in both the response and the stored record is that of the
+// submission the run evaluated.
+func TestRunHandlerStoresCodeHash(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ECHO_CODE": "true"})
+	docker := newFakeDocker(t).withImage(cfg, "sum").withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+	results := newResultStore(100, 100)
+
+	code := "export const sum = (a: number, b: number) => a + b"
+	r := httptest.NewRequest("POST", "/test/sum/run?format=json", strings.NewReader(`{"user": "alice", "code": "`+code+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(t, "POST /test/{test}/run", runHandler(cfg, docker.client, nil, nil, nil, nil, nil, results), r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("run responded %d: %s", w.Code, w.Body)
+	}
+
+	want := fmt.Sprintf("%x", sha256.Sum256([]byte(code)))
+	result := RunResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.CodeHash != want || result.Code != code {
+		t.Errorf("response has hash %s and code %q, want %s and %q", result.CodeHash, result.Code, want, code)
+	}
+
+	stored := results.ByCodeHash(want)
+	if len(stored) != 1 {
+		t.Fatalf("%d stored records with the submission's hash, want 1", len(stored))
+	}
+	if stored[0].Result == nil || stored[0].Result.CodeHash != want {
+		t.Errorf("stored result is %+v, want code hash %s", stored[0].Result, want)
+	}
+}
+
+func TestAssignCaseIDs(t *testing.T) {
+	cases := func(names ...[2]string) []TestCase {
+		listed := []TestCase{}
+		for _, name := range names {
+			listed = append(listed, TestCase{Suite: name[0], Name: name[1]})
+		}
+		return listed
+	}
+	ids := func(result RunResult) []string {
+		got := []string{}
+		for _, testCase := range result.Cases {
+			got = append(got, testCase.ID)
+		}
+		return got
+	}
+
+	tests := []struct {
+		name  string
+		cases []TestCase
+		want  []string
+	}{
+		{"suite and name", cases([2]string{"test.ts", "adds"}, [2]string{"test.ts", "subtracts"}), []string{"test.ts::adds", "test.ts::subtracts"}},
+		{"whitespace", cases([2]string{" test.ts ", "adds\ttwo  numbers\n"}), []string{"test.ts::adds two numbers"}},
+		{"duplicates", cases([2]string{"test.ts", "adds"}, [2]string{"other.ts", "adds"}, [2]string{"test.ts", "adds"}, [2]string{"test.ts", "adds "}), []string{"test.ts::adds", "other.ts::adds", "test.ts::adds#2", "test.ts::adds#3"}},
+		{"no suite", cases([2]string{"", "adds"}), []string{"::adds"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := RunResult{Cases: test.cases}
+			result.assignCaseIDs()
+			if got := ids(result); !slices.Equal(got, test.want) {
+				t.Errorf("IDs are %q, want %q", got, test.want)
+			}
+
+			// The same cases in a later run get the same IDs, even when
+			// earlier ones changed status.
+			again := RunResult{Cases: slices.Clone(test.cases)}
+			again.Cases[0].Status = "failed"
+			again.Cases[0].Message = "now failing"
+			again.assignCaseIDs()
+			if got := ids(again); !slices.Equal(got, test.want) {
+				t.Errorf("IDs in a second run are %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RunRequest struct {
+	Task string
+	User string
+	Code string
+
+	// Progress, when set, receives test results parsed from the container's
+	// stdout while the run is still going.
+	Progress func(ProgressEvent)
+	// BuildProgress, when set, receives the build's output and pull
+	// progress while an image the run needs is built.
+	BuildProgress func(BuildEvent)
+
+	// Builds and Runs, when set, bound the two phases separately: the build
+	// slot is released before the run slot is taken.
+	Builds *limiter
+	Runs   *limiter
+
+	// NoCache builds any image the run needs without the build cache.
+	NoCache bool
+}
+
+type Execution struct {
+	Report   []byte
+	Stdout   []byte
+	ExitCode int64
+	TimedOut bool
+	// MemoryExceeded is set when the container was killed for nearing its
+	// memory limit.
+	MemoryExceeded bool
+	Usage          *ResourceUsage
+
+	BuildDuration time.Duration
+	RunDuration   time.Duration
+	// CacheHit is set when the task's base image already existed.
+	CacheHit bool
+	// BuildLog is the output of any image build the run needed, and RunLog
+	// the container's output when KEEP_ARTIFACTS is set; both are capped.
+	BuildLog []byte
+	RunLog   []byte
+	// BuildCache is set when the run built an image.
+	BuildCache *BuildCache
+	// ReportFormat is set when the server produced the report itself, as
+	// JUnit, overriding the task's format.
+	ReportFormat string
+	// ExitReason describes why the container stopped; see exitReason.
+	ExitReason string
+	// Stderr is the container's capped stderr, read when it exited
+	// non-zero.
+	Stderr []byte
+	// ReportPolled is set when the report was picked up while the runner
+	// was still going, and the container then killed; see pollReport.
+	ReportPolled bool
+}
+
+// executeCodeTest runs the submission against the task's tests, trying
+// again up to RUN_ATTEMPTS times if the run fails for infrastructure reasons.
+// Cancelling ctx, for example when the client disconnects, aborts the build
+// or run; the container is still removed.
+func executeCodeTest(ctx context.Context, cfg *Config, cli *client.Client, req RunRequest) (*Execution, error) {
+	ctx, span := tracer.Start(ctx, "executeCodeTest", trace.WithAttributes(
+		attribute.String("gitblame.user", req.User),
+		attribute.String("gitblame.task", req.Task),
+	))
+
+	var execution *Execution
+	var err error
+	backoff := cfg.RunRetryBackoff
+	for attempt := 1; ; attempt++ {
+		// Each attempt removes its own container and report volume.
+		execution, err = runContainer(ctx, cfg, cli, req)
+		if err == nil || attempt >= cfg.RunAttempts || !infraFailure(err) || ctx.Err() != nil {
+			break
+		}
+
+		_, code := classifyError(err)
+		runRetries.Add(code, 1)
+		slog.Warn("run failed", "task", req.Task, "user", req.User, "attempt", attempt, "attempts", cfg.RunAttempts, "error", err)
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("gitblame.attempt", attempt)))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
+	if execution != nil {
+		span.SetAttributes(attribute.Int64("gitblame.exit_code", execution.ExitCode))
+	}
+	endSpan(span, err)
+
+	return execution, err
+}
+
+func runContainer(ctx context.Context, cfg *Config, cli *client.Client, req RunRequest) (*Execution, error) {
+	task, user, code := req.Task, req.User, req.Code
+
+	testFile, err := files.ReadFile(fmt.Sprintf("tests/%s/test.ts", task))
+	if err != nil {
+		return nil, fmt.Errorf("reading test file: %w", err)
+	}
+	if err := verifyTestFile(task, testFile); err != nil {
+		return nil, err
+	}
+
+	meta, err := loadMetadata(task)
+	if err != nil {
+		return nil, err
+	}
+
+	// ExitCode stays -1 unless the container exits on its own.
+	execution := &Execution{ExitCode: -1}
+
+	buildStarted := time.Now()
+	_, buildSpan := tracer.Start(ctx, "build")
+	buildLog := newCappedBuffer(maxArtifactLog)
+	var buildOutput io.Writer = buildLog
+	if req.BuildProgress != nil {
+		buildOutput = progressOutput{Writer: buildLog, report: req.BuildProgress}
+	}
+	var imageName string
+	if meta.Rebuild {
+		imageName = userImageName(cfg, user, task)
+		var memFS fstest.MapFS
+		memFS, err = createFS(task, code)
+		if err == nil {
+			err = buildWithSlot(ctx, req.Builds, func() error {
+				slog.Info("building image", "image", imageName)
+				return buildImage(ctx, cfg, cli, imageName, meta, memFS, buildOutput, req.NoCache)
+			})
+		}
+	} else {
+		imageName, execution.CacheHit, err = ensureBaseImage(ctx, cfg, cli, task, meta, buildOutput, req.Builds, req.NoCache)
+	}
+	execution.BuildDuration = time.Since(buildStarted)
+	execution.BuildLog = buildLog.Contents()
+	if len(execution.BuildLog) > 0 {
+		cache := parseBuildCache(string(execution.BuildLog))
+		execution.BuildCache = &cache
+		buildCacheSteps.Add("hit", cache.Cached)
+		buildCacheSteps.Add("miss", cache.Built)
+	}
+	buildSpan.SetAttributes(attribute.String("gitblame.image", imageName), attribute.Bool("gitblame.cache_hit", execution.CacheHit))
+	endSpan(buildSpan, err)
+	if err != nil {
+		return execution, err
+	}
+
+	return runImage(ctx, cfg, cli, req, meta, imageName, execution)
+}
+
+// runImage runs a container from the built imageName, copying in the
+// submission first unless meta.Rebuild baked it into the image, and collects
+// the report.
+func runImage(ctx context.Context, cfg *Config, cli *client.Client, req RunRequest, meta Metadata, imageName string, execution *Execution) (*Execution, error) {
+	task, code := req.Task, req.Code
+
+	if err := req.Runs.Acquire(ctx); err != nil {
+		return execution, fmt.Errorf("%w: waiting for a run slot: %w", errNoSlot, err)
+	}
+	defer req.Runs.Release()
+
+	securityOpt, err := securityOpts(task, meta)
+	if err != nil {
+		return execution, err
+	}
+
+	containerHost := hostConfig(cfg, meta, securityOpt)
+	var volume *reportVolume
+	if cfg.ReportStrategy == reportMount {
+		volume, err = newReportVolume(cfg, meta)
+		if err != nil {
+			return execution, err
+		}
+		defer volume.Remove()
+		containerHost.Mounts = append(containerHost.Mounts, volume.Mount())
+	}
+
+	createCtx, createSpan := tracer.Start(ctx, "create")
+	containerOutput, err := cli.ContainerCreate(createCtx, &container.Config{
+		Image:      imageName,
+		Labels:     ownerLabels(cfg),
+		WorkingDir: meta.workingDir(),
+		Entrypoint: meta.Entrypoint,
+		Cmd:        meta.TestCommand,
+		StopSignal: meta.stopSignal(),
+		Hostname:   meta.hostname(cfg.RunHostname),
+		Domainname: cfg.RunDomainname,
+	}, containerHost, nil, nil, "")
+	if err != nil {
+		endSpan(createSpan, err)
+		return execution, fmt.Errorf("creating container: %w", err)
+	}
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+
+		err := cli.ContainerRemove(cleanupCtx, containerOutput.ID, client.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			slog.Error("deleting container", "container", containerOutput.ID, "error", err)
+		}
+	}()
+
+	if !meta.Rebuild {
+		codeArchive, err := tarImageContext(fstest.MapFS{
+			"code.ts": &fstest.MapFile{Data: []byte(code), Mode: 0644},
+		}, cfg.MaxContextFiles)
+		if err != nil {
+			endSpan(createSpan, err)
+			return execution, fmt.Errorf("creating code tar: %w", err)
+		}
+
+		err = cli.CopyToContainer(createCtx, containerOutput.ID, meta.workingDir(), codeArchive, client.CopyToContainerOptions{})
+		if err != nil {
+			// Starting anyway would grade the image's starter code.
+			endSpan(createSpan, err)
+			return execution, fmt.Errorf("copying code to container: %w", err)
+		}
+	}
+	endSpan(createSpan, nil)
+
+	startCtx, startSpan := tracer.Start(ctx, "start")
+	err = cli.ContainerStart(startCtx, containerOutput.ID, client.ContainerStartOptions{})
+	endSpan(startSpan, err)
+	if err != nil {
+		return execution, fmt.Errorf("starting container: %w", err)
+	}
+
+	runStarted := time.Now()
+	defer activeRuns.Add(ActiveRun{
+		RequestID:   requestID(ctx),
+		User:        req.User,
+		Task:        task,
+		ContainerID: containerOutput.ID,
+		Started:     runStarted.UTC(),
+	})()
+
+	var progressDone chan struct{}
+	progressCtx, cancelProgress := context.WithCancel(ctx)
+	defer cancelProgress()
+	if req.Progress != nil {
+		progressDone = make(chan struct{})
+		go func() {
+			defer close(progressDone)
+			if err := followProgress(progressCtx, cli, containerOutput.ID, req.Progress); err != nil && progressCtx.Err() == nil {
+				slog.Error("following progress", "container", containerOutput.ID, "error", err)
+			}
+		}()
+	}
+
+	statsDone := make(chan struct{})
+	memoryPressure := make(chan struct{})
+	statsCtx, cancelStats := context.WithCancel(ctx)
+	defer cancelStats()
+	go func() {
+		defer close(statsDone)
+		usage, err := collectStats(statsCtx, cli, containerOutput.ID, cfg.MemoryKillThreshold, memoryPressure)
+		if err != nil {
+			slog.Error("collecting stats", "container", containerOutput.ID, "error", err)
+		}
+		execution.Usage = usage
+	}()
+
+	// The log and stats streams normally end with the container; don't let
+	// a stuck stream hold up the result. Every return joins them, so that
+	// neither reports progress nor writes Usage once the caller has the
+	// execution.
+	joinStreams := sync.OnceFunc(func() {
+		if progressDone != nil {
+			select {
+			case <-progressDone:
+			case <-time.After(2 * time.Second):
+				cancelProgress()
+				<-progressDone
+			}
+		}
+		select {
+		case <-statsDone:
+		case <-time.After(2 * time.Second):
+			cancelStats()
+			<-statsDone
+		}
+	})
+	defer joinStreams()
+
+	runTimeout := meta.runTimeout(cfg.RunTimeout)
+	timeout := time.NewTimer(runTimeout)
+	defer timeout.Stop()
+
+	// A daemon that hangs may never answer the wait, so the container is
+	// inspected now and then as well.
+	waitCheck := time.NewTicker(cfg.WaitCheckInterval)
+	defer waitCheck.Stop()
+
+	var reportPoll <-chan time.Time
+	var polled []byte
+	if meta.PollReport {
+		ticker := time.NewTicker(cfg.ReportPollInterval)
+		defer ticker.Stop()
+		reportPoll = ticker.C
+	}
+
+	var waitErr error
+	var waitExitError string
+	_, waitSpan := tracer.Start(ctx, "wait")
+	// Cancelling the wait on the way out closes its request, which a hung
+	// daemon would otherwise hold open.
+	waitCtx, cancelWait := context.WithCancel(ctx)
+	defer cancelWait()
+	waitChannel, errorChannel := cli.ContainerWait(waitCtx, containerOutput.ID, container.WaitConditionNotRunning)
+wait:
+	for {
+		select {
+		case err := <-errorChannel:
+			waitErr = fmt.Errorf("waiting for container: %w", err)
+			waitSpan.RecordError(err)
+			break wait
+		case status := <-waitChannel:
+			execution.ExitCode = status.StatusCode
+			if status.Error != nil {
+				waitExitError = status.Error.Message
+			}
+			break wait
+		case <-memoryPressure:
+			slog.Warn("container killed nearing its memory limit", "container", containerOutput.ID)
+			execution.MemoryExceeded = true
+			if err := cli.ContainerKill(ctx, containerOutput.ID, "SIGKILL"); err != nil {
+				slog.Error("killing container", "container", containerOutput.ID, "error", err)
+			}
+			break wait
+		case <-timeout.C:
+			slog.Warn("container timed out", "container", containerOutput.ID, "timeout", runTimeout)
+			execution.TimedOut = true
+			if err := stopContainer(ctx, cli, containerOutput.ID, cfg.StopGracePeriod); err != nil {
+				slog.Error("stopping container", "container", containerOutput.ID, "error", err)
+			}
+			break wait
+		case <-reportPoll:
+			var complete bool
+			polled, complete = pollReport(ctx, cfg, cli, meta, containerOutput.ID, polled)
+			if !complete {
+				continue
+			}
+			slog.Info("report is written, killing container", "container", containerOutput.ID)
+			execution.Report = polled
+			execution.ReportPolled = true
+			if err := cli.ContainerKill(ctx, containerOutput.ID, "SIGKILL"); err != nil {
+				slog.Error("killing container", "container", containerOutput.ID, "error", err)
+			}
+			break wait
+		case <-waitCheck.C:
+			exitCode, exited, err := containerExited(ctx, cli, containerOutput.ID)
+			if err != nil {
+				waitErr = err
+				waitSpan.RecordError(err)
+				break wait
+			}
+			if exited {
+				slog.Warn("container exited without its wait returning", "container", containerOutput.ID)
+				execution.ExitCode = exitCode
+				break wait
+			}
+		}
+	}
+
+	execution.RunDuration = time.Since(runStarted)
+	waitSpan.SetAttributes(attribute.Bool("gitblame.timed_out", execution.TimedOut))
+	waitSpan.End()
+	if waitErr != nil {
+		// The container may still be running, so its streams won't end
+		// on their own.
+		cancelProgress()
+		cancelStats()
+		return execution, waitErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return execution, fmt.Errorf("run cancelled: %w", err)
+	}
+
+	oomKilled := false
+	if inspect, err := cli.ContainerInspect(ctx, containerOutput.ID); err != nil {
+		slog.Error("inspecting container", "container", containerOutput.ID, "error", err)
+	} else if inspect.State != nil {
+		oomKilled = inspect.State.OOMKilled
+	}
+	execution.ExitReason = exitReason(execution, oomKilled, waitExitError)
+
+	joinStreams()
+
+	if execution.ExitCode != 0 && !execution.ReportPolled {
+		execution.Stderr, err = readStderr(ctx, cli, containerOutput.ID)
+		if err != nil {
+			slog.Error("reading stderr", "container", containerOutput.ID, "error", err)
+		}
+	}
+
+	if cfg.KeepArtifacts {
+		execution.RunLog, err = readRunLog(ctx, cli, containerOutput.ID)
+		if err != nil {
+			slog.Error("reading run log", "container", containerOutput.ID, "error", err)
+		}
+	}
+
+	if meta.CompareOutput {
+		logs, err := cli.ContainerLogs(ctx, containerOutput.ID, client.ContainerLogsOptions{ShowStdout: true})
+		if err != nil {
+			return execution, fmt.Errorf("reading container output: %w", err)
+		}
+		defer logs.Close()
+
+		stdout := bytes.Buffer{}
+		if _, err := stdcopy.StdCopy(&stdout, io.Discard, logs); err != nil {
+			return execution, fmt.Errorf("demultiplexing container output: %w", err)
+		}
+		execution.Stdout = stdout.Bytes()
+	}
+
+	copyCtx, copySpan := tracer.Start(ctx, "copy")
+	if execution.ReportPolled {
+		err = nil
+	} else if volume != nil {
+		execution.Report, err = volume.Read(cfg.MaxReportBytes)
+	} else if meta.reportDir() != "" {
+		execution.Report, err = readReportDir(copyCtx, cli, containerOutput.ID, meta.reportDir(), cfg.MaxReportBytes)
+	} else {
+		execution.Report, err = readReport(copyCtx, cli, containerOutput.ID, meta.reportPath(), cfg.MaxReportBytes)
+	}
+	endSpan(copySpan, err)
+	if meta.reportDir() != "" {
+		execution.ReportFormat = reportJUnit
+	}
+	if cerrdefs.IsNotFound(err) && !meta.requiresReport() && execution.ExitCode == 0 && !execution.TimedOut && !execution.MemoryExceeded {
+		execution.Report, err = successReport(task)
+		execution.ReportFormat = reportJUnit
+	}
+	if err != nil {
+		if execution.TimedOut {
+			return execution, fmt.Errorf("%w after %s: %w", errRunTimedOut, runTimeout, err)
+		}
+		if execution.MemoryExceeded {
+			return execution, fmt.Errorf("%w: %w", errMemoryExceeded, err)
+		}
+		// In the default mode the submission is first compiled here, by
+		// the test command, rather than by a build.
+		if diagnostics := errorDiagnostics(string(execution.Stderr)); len(diagnostics) > 0 {
+			return execution, &checkFailure{err: err, diagnostics: diagnostics}
+		}
+		if runtimeError := parseRuntimeError(string(execution.Stderr)); runtimeError != nil {
+			return execution, &runtimeFailure{err: err, runtimeError: runtimeError}
+		}
+		return execution, err
+	}
+
+	return execution, nil
+}
+
+// buildResult parses the execution's report and, for output-matching tasks,
+// adds a case comparing stdout against the expected output.
+func buildResult(cfg *Config, task string, execution *Execution) (RunResult, error) {
+	meta, err := loadMetadata(task)
+	if err != nil {
+		return newRunResult(), err
+	}
+
+	format := execution.ReportFormat
+	if format == "" {
+		format = meta.ReportFormat
+	}
+	result, err := parseReport(format, meta.reportPath(), execution.Report, cfg.MaxReportCases)
+	if err != nil {
+		return result, err
+	}
+	result.TimedOut = execution.TimedOut
+	result.MemoryExceeded = execution.MemoryExceeded
+	result.ExitReason = execution.ExitReason
+	result.RuntimeError = parseRuntimeError(string(execution.Stderr))
+	result.Resources = execution.Usage
+	if execution.BuildCache != nil {
+		result.Debug = &RunDebug{BuildCache: execution.BuildCache}
+	}
+
+	if meta.CompareOutput {
+		diff, err := compareOutput(task, execution.Stdout)
+		if err != nil {
+			return result, err
+		}
+
+		outputCase := TestCase{Name: outputCaseName, Suite: task, Status: StatusPassed}
+		if diff != "" {
+			outputCase.Status = StatusFailed
+			outputCase.Message = "output differs from expected"
+			outputCase.Details = diff
+			result.Failed++
+		} else {
+			result.Passed++
+		}
+		result.Cases = append(result.Cases, outputCase)
+		result.Total++
+		result.Output = string(execution.Stdout)
+		result.OutputDiff = diff
+	}
+
+	result.TaskVersion = taskVersions[task]
+	result.assignCaseIDs()
+	applyPostProcessors(task, &result)
+
+	return result, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+// benchmarkDocker connects to the Docker daemon from the environment,
+// skipping the benchmark when there is none.
+func benchmarkDocker(b *testing.B) *client.Client {
+	b.Helper()
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		b.Fatalf("opening client: %v", err)
+	}
+	if _, err := cli.Ping(context.Background()); err != nil {
+		b.Skipf("no Docker daemon: %v", err)
+	}
+	b.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+// benchmarkRun runs the sum task's solution once per iteration, as a
+// different submission each time so that a rebuild can't reuse the previous
+// iteration's code layer.
+func benchmarkRun(b *testing.B, rebuild bool) {
+	cli := benchmarkDocker(b)
+	cfg := testConfig(b, map[string]string{"IMAGE_PREFIX": "bench"})
+	ctx := context.Background()
+
+	meta, err := loadMetadata("sum")
+	if err != nil {
+		b.Fatal(err)
+	}
+	meta.Rebuild = rebuild
+	solution, err := files.ReadFile("tests/sum/solution.ts")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// The base image is built once, as the server does on first use.
+	imageName, _, err := ensureBaseImage(ctx, cfg, cli, "sum", meta, io.Discard, nil, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { cli.ImageRemove(ctx, imageName, client.ImageRemoveOptions{Force: true}) })
+
+	b.ResetTimer()
+	for i := range b.N {
+		req := RunRequest{Task: "sum", User: fmt.Sprintf("bench-%d", i), Code: fmt.Sprintf("%s\n// submission %d\n", solution, i)}
+		runImageName := imageName
+		if rebuild {
+			runImageName = userImageName(cfg, req.User, req.Task)
+			memFS, err := createFS(req.Task, req.Code)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := buildImage(ctx, cfg, cli, runImageName, meta, memFS, io.Discard, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		execution, err := runImage(ctx, cfg, cli, req, meta, runImageName, &Execution{ExitCode: -1})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if execution.ExitCode != 0 {
+			b.Fatalf("run exited with code %d", execution.ExitCode)
+		}
+
+		if rebuild {
+			b.StopTimer()
+			cli.ImageRemove(ctx, runImageName, client.ImageRemoveOptions{Force: true})
+			b.StartTimer()
+		}
+	}
+}
+
+// BenchmarkRunCopyIntoBase measures the default mode: the submission is
+// copied into a container of the task's prebuilt base image.
+func BenchmarkRunCopyIntoBase(b *testing.B) {
+	benchmarkRun(b, false)
+}
+
+// BenchmarkRunRebuild measures rebuilding an image per submission, as tasks
+// with rebuild set do.
+func BenchmarkRunRebuild(b *testing.B) {
+	benchmarkRun(b, true)
+}
+
+func TestRunImageWithoutReport(t *testing.T) {
+	noReport := false
+	tests := []struct {
+		name     string
+		meta     Metadata
+		exitCode int64
+		passes   bool
+	}{
+		{"not required, clean exit", Metadata{RequireReport: &noReport}, 0, true},
+		{"not required, failing exit", Metadata{RequireReport: &noReport}, 1, false},
+		{"required", Metadata{}, 0, false},
+		// The report directory exists but holds no reports.
+		{"dir not required, clean exit", Metadata{RequireReport: &noReport, ReportDir: "reports"}, 0, true},
+		{"dir required", Metadata{ReportDir: "reports"}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docker := newFakeDocker(t)
+			docker.exitCode = test.exitCode
+			docker.images["base"] = fakeImage(nil)
+			if test.meta.ReportDir != "" {
+				docker.files["/test/reports/notes.txt"] = []byte("not a report")
+			}
+			cfg := testConfig(t, nil)
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			execution, err := runImage(context.Background(), cfg, docker.client, req, test.meta, "base", &Execution{ExitCode: -1})
+			if !test.passes {
+				if err == nil {
+					t.Fatal("run without a report succeeded")
+				}
+				if _, code := classifyError(err); test.exitCode == 0 && code != codeReportMissing {
+					t.Errorf("run without a report failed with %v, classified %s, want %s", err, code, codeReportMissing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("running: %v", err)
+			}
+
+			result, err := buildResult(cfg, req.Task, execution)
+			if err != nil {
+				t.Fatalf("building result: %v", err)
+			}
+			if result.Passed != 1 || result.Failed != 0 || len(result.Cases) != 1 || result.Cases[0].Name != "exits successfully" {
+				t.Errorf("result is %d passed, %d failed, cases %+v; want the one success case", result.Passed, result.Failed, result.Cases)
+			}
+		})
+	}
+}
+
+// A client disconnecting cancels the request's context, which has to stop
+// the run and still remove its container.
+func TestExecuteCodeTestCancelled(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t).withImage(cfg, "sum")
+	docker.runFor = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	docker.onStart = func(*fakeContainer) { cancel() }
+
+	started := time.Now()
+	_, err := executeCodeTest(ctx, cfg, docker.client, RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled run returned %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("cancelled run took %s to return", elapsed)
+	}
+	if c := docker.Container(); !c.Removed {
+		t.Error("cancelled run's container wasn't removed")
+	}
+}
+
+func TestRunImageAppliesTestCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		meta Metadata
+	}{
+		{"image default", Metadata{}},
+		{"test command", Metadata{TestCommand: []string{"test", "--allow-read", "--junit-path=report.xml"}}},
+		{"entrypoint only", Metadata{Entrypoint: []string{"/bin/run-tests", "--report", "report.xml"}}},
+		{"entrypoint and test command", Metadata{Entrypoint: []string{"/bin/run-tests"}, TestCommand: []string{"--report", "report.xml"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="sum"/>`))
+			docker.images["base"] = fakeImage(nil)
+			cfg := testConfig(t, nil)
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			if _, err := runImage(context.Background(), cfg, docker.client, req, test.meta, "base", &Execution{ExitCode: -1}); err != nil {
+				t.Fatal(err)
+			}
+
+			config := docker.Container().Config
+			if !slices.Equal(config.Cmd, test.meta.TestCommand) || !slices.Equal(config.Entrypoint, test.meta.Entrypoint) {
+				t.Errorf("container runs %v %v, want %v %v", config.Entrypoint, config.Cmd, test.meta.Entrypoint, test.meta.TestCommand)
+			}
+		})
+	}
+}
+
+// A wait that fails mustn't be taken for the run finishing: the report
+// already in the container may be stale or partial.
+func TestRunImageWaitFails(t *testing.T) {
+	docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+	docker.waitStatus = http.StatusInternalServerError
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, nil)
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	execution, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	if err == nil || !strings.Contains(err.Error(), "waiting for container") {
+		t.Fatalf("run whose wait failed returned %v, want a waiting for container error", err)
+	}
+	if execution.Report != nil {
+		t.Errorf("run whose wait failed read a report: %q", execution.Report)
+	}
+	if c := docker.Container(); !c.Removed {
+		t.Error("container wasn't removed after its wait failed")
+	}
+}
+
+// A daemon whose wait never answers is caught by inspecting the container
+// every WAIT_CHECK_INTERVAL.
+func TestRunImageWaitHangs(t *testing.T) {
+	docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+	docker.hangWait = true
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, map[string]string{"WAIT_CHECK_INTERVAL": "20ms"})
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	execution, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	if err != nil {
+		t.Fatalf("run with a hung wait returned %v", err)
+	}
+	if execution.ExitCode != 0 || execution.Report == nil {
+		t.Errorf("got exit code %d and report %q, want 0 and the report", execution.ExitCode, execution.Report)
+	}
+}
+
+func TestExecuteCodeTestRetriesInfraFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempts   string
+		failures   int
+		exitCode   int64
+		wantErr    bool
+		containers int
+	}{
+		{"retried once", "3", 1, 0, false, 2},
+		{"retries run out", "2", 5, 0, true, 2},
+		{"retries off", "1", 1, 0, true, 1},
+		// A failing test is the submission's fault, not the host's.
+		{"test failure", "3", 0, 1, true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t, map[string]string{"RUN_ATTEMPTS": test.attempts, "RUN_RETRY_BACKOFF": "1ms"})
+			docker := newFakeDocker(t).withImage(cfg, "sum")
+			if test.exitCode == 0 {
+				docker.withReport(junitReport(`<testcase name="adds"/>`))
+			}
+			docker.startFailures = test.failures
+			docker.exitCode = test.exitCode
+
+			_, err := executeCodeTest(context.Background(), cfg, docker.client, RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+
+			containers := docker.Containers()
+			if len(containers) != test.containers {
+				t.Errorf("created %d containers, want %d", len(containers), test.containers)
+			}
+			for _, c := range containers {
+				if !c.Removed {
+					t.Errorf("container %s of a failed attempt wasn't removed", c.ID)
+				}
+			}
+		})
+	}
+}
+
+// An internal error the daemon didn't report, here a task without its test
+// file, fails the same way every time, so it isn't tried again.
+func TestExecuteCodeTestDoesntRetryInternalErrors(t *testing.T) {
+	logs := captureLogs(t)
+	cfg := testConfig(t, map[string]string{"RUN_ATTEMPTS": "3", "RUN_RETRY_BACKOFF": "1ms"})
+	docker := newFakeDocker(t)
+
+	_, err := executeCodeTest(context.Background(), cfg, docker.client, RunRequest{Task: "fizzbuzzer", User: "alice", Code: "export const fizzbuzz = 1"})
+	if _, code := classifyError(err); code != codeInternal {
+		t.Fatalf("run failed with %v, classified %s, want an internal error", err, code)
+	}
+	for _, line := range logLines(t, logs) {
+		if line["msg"] == "run failed" {
+			t.Errorf("internal error was retried: %v", line)
+		}
+	}
+}
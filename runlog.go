@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	outcomePassed    = "passed"
+	outcomeFailed    = "failed"
+	outcomeError     = "error"
+	outcomeTimeout   = "timeout"
+	outcomeMemory    = "memory_exceeded"
+	outcomeCancelled = "cancelled"
+)
+
+// runSummary is the line logged for every completed run. Dashboards parse
+// these, so attributes may be added but never renamed or removed.
+type runSummary struct {
+	RequestID  string
+	User       string
+	Task       string
+	Outcome    string
+	ExitCode   int64
+	ExitReason string
+	BuildMs    int64
+	RunMs      int64
+	TotalMs    int64
+	CacheHit   bool
+	Passed     int
+	Failed     int
+	Skipped    int
+	Error      string
+
+	started time.Time
+}
+
+func newRunSummary(requestID string, req RunRequest) *runSummary {
+	return &runSummary{
+		RequestID: requestID,
+		User:      req.User,
+		Task:      req.Task,
+		Outcome:   outcomeError,
+		ExitCode:  -1,
+		started:   time.Now(),
+	}
+}
+
+// record fills in the summary from a finished run. Either argument may be
+// nil when the run failed before producing it.
+func (s *runSummary) record(execution *Execution, result *RunResult, err error) {
+	if execution != nil {
+		s.ExitCode = execution.ExitCode
+		s.BuildMs = execution.BuildDuration.Milliseconds()
+		s.RunMs = execution.RunDuration.Milliseconds()
+		s.CacheHit = execution.CacheHit
+		s.ExitReason = execution.ExitReason
+	}
+	if result != nil {
+		s.Passed = result.Passed
+		s.Failed = result.Failed
+		s.Skipped = result.Skipped
+	}
+
+	switch {
+	case errors.Is(err, errRunTimedOut), execution != nil && execution.TimedOut:
+		s.Outcome = outcomeTimeout
+	case errors.Is(err, errMemoryExceeded), execution != nil && execution.MemoryExceeded:
+		s.Outcome = outcomeMemory
+	case errors.Is(err, context.Canceled):
+		s.Outcome = outcomeCancelled
+	case err != nil || result == nil:
+		s.Outcome = outcomeError
+	case result.Failed > 0 || result.Total == 0:
+		s.Outcome = outcomeFailed
+	default:
+		s.Outcome = outcomePassed
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// log writes the summary as a single line through the default logger, so
+// LOG_FORMAT=json makes it one JSON object. Empty exit_reason and error
+// attributes are left out.
+func (s *runSummary) log() {
+	s.TotalMs = time.Since(s.started).Milliseconds()
+
+	attrs := []slog.Attr{
+		slog.String("request_id", s.RequestID),
+		slog.String("user", s.User),
+		slog.String("task", s.Task),
+		slog.String("outcome", s.Outcome),
+		slog.Int64("exit_code", s.ExitCode),
+	}
+	if s.ExitReason != "" {
+		attrs = append(attrs, slog.String("exit_reason", s.ExitReason))
+	}
+	attrs = append(attrs,
+		slog.Int64("build_ms", s.BuildMs),
+		slog.Int64("run_ms", s.RunMs),
+		slog.Int64("total_ms", s.TotalMs),
+		slog.Bool("cache_hit", s.CacheHit),
+		slog.Int("passed", s.Passed),
+		slog.Int("failed", s.Failed),
+		slog.Int("skipped", s.Skipped),
+	)
+	if s.Error != "" {
+		attrs = append(attrs, slog.String("error", s.Error))
+	}
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "run completed", attrs...)
+}
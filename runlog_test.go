@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// captureLogs makes the default logger write JSON to the returned buffer
+// for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	logs := &bytes.Buffer{}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	return logs
+}
+
+// logLines decodes each JSON line of logs.
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	lines := []map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		decoded := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("decoding log line %q: %v", line, err)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func TestRunSummaryLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		execution *Execution
+		result    *RunResult
+		err       error
+		want      map[string]any
+	}{
+		{
+			name:      "passed",
+			execution: &Execution{ExitCode: 0, CacheHit: true, ExitReason: "exited with code 0"},
+			result:    &RunResult{Passed: 2, Skipped: 1, Total: 3},
+			want:      map[string]any{"outcome": outcomePassed, "exit_code": 0.0, "exit_reason": "exited with code 0", "cache_hit": true, "passed": 2.0, "skipped": 1.0},
+		},
+		{
+			name:      "failed",
+			execution: &Execution{ExitCode: 1},
+			result:    &RunResult{Passed: 1, Failed: 1, Total: 2},
+			want:      map[string]any{"outcome": outcomeFailed, "exit_code": 1.0, "failed": 1.0},
+		},
+		{
+			name: "error before running",
+			err:  fmt.Errorf("building: %w", errBuildFailed),
+			want: map[string]any{"outcome": outcomeError, "exit_code": -1.0, "error": "building: image build failed"},
+		},
+		{
+			name:      "timed out",
+			execution: &Execution{ExitCode: -1, TimedOut: true},
+			err:       errRunTimedOut,
+			want:      map[string]any{"outcome": outcomeTimeout, "error": "test run timed out"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			summary := newRunSummary("req-1", RunRequest{Task: "sum", User: "alice"})
+			summary.record(test.execution, test.result, test.err)
+			summary.log()
+
+			lines := logLines(t, logs)
+			if len(lines) != 1 {
+				t.Fatalf("logged %d lines, want 1", len(lines))
+			}
+			line := lines[0]
+			for _, key := range []string{"time", "msg", "request_id", "user", "task", "outcome", "exit_code", "build_ms", "run_ms", "total_ms", "cache_hit", "passed", "failed", "skipped"} {
+				if _, ok := line[key]; !ok {
+					t.Errorf("summary has no %s: %v", key, line)
+				}
+			}
+			if line["msg"] != "run completed" || line["request_id"] != "req-1" || line["user"] != "alice" || line["task"] != "sum" {
+				t.Errorf("summary identifies the run as %v", line)
+			}
+			for key, want := range test.want {
+				if line[key] != want {
+					t.Errorf("%s is %v, want %v", key, line[key], want)
+				}
+			}
+			if _, ok := test.want["error"]; !ok {
+				if _, ok := line["error"]; ok {
+					t.Errorf("summary of a run without an error has error %v", line["error"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ActiveRun is a test container that is currently running.
+type ActiveRun struct {
+	RequestID   string    `json:"requestId"`
+	User        string    `json:"user"`
+	Task        string    `json:"task"`
+	ContainerID string    `json:"containerId"`
+	Started     time.Time `json:"started"`
+	// Elapsed is the seconds since the container started.
+	Elapsed float64 `json:"elapsed"`
+}
+
+// runRegistry tracks the test containers in flight, keyed by container ID.
+type runRegistry struct {
+	mu   sync.Mutex
+	runs map[string]ActiveRun
+}
+
+var activeRuns = &runRegistry{runs: map[string]ActiveRun{}}
+
+// Add registers a run and returns the function that removes it.
+func (r *runRegistry) Add(run ActiveRun) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.runs[run.ContainerID] = run
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.runs, run.ContainerID)
+	}
+}
+
+// List returns the runs in flight, longest running first.
+func (r *runRegistry) List() []ActiveRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := []ActiveRun{}
+	for _, run := range r.runs {
+		run.Elapsed = time.Since(run.Started).Seconds()
+		runs = append(runs, run)
+	}
+	slices.SortFunc(runs, func(a, b ActiveRun) int { return a.Started.Compare(b.Started) })
+	return runs
+}
+
+// runningHandler serves GET /admin/running.
+func runningHandler(registry *runRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(marshalResponse(r, registry.List()))
+	}
+}
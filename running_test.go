@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// listRunning fetches GET /admin/running with the admin token.
+func listRunning(t *testing.T, handler http.HandlerFunc) []ActiveRun {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/admin/running", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := serve(t, "GET /admin/running", handler, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status is %d, want 200", w.Code)
+	}
+	runs := []ActiveRun{}
+	if err := json.Unmarshal(w.Body.Bytes(), &runs); err != nil {
+		t.Fatal(err)
+	}
+	return runs
+}
+
+func TestRunningHandler(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ADMIN_TOKEN": "secret"})
+	registry := &runRegistry{runs: map[string]ActiveRun{}}
+	handler := requireAdmin(cfg, runningHandler(registry))
+
+	r := httptest.NewRequest("GET", "/admin/running", nil)
+	if w := serve(t, "GET /admin/running", handler, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("without the token status is %d, want 401", w.Code)
+	}
+
+	started := time.Now().Add(-time.Minute)
+	removeOld := registry.Add(ActiveRun{RequestID: "r1", User: "alice", Task: "sum", ContainerID: "c1", Started: started})
+	registry.Add(ActiveRun{RequestID: "r2", User: "bob", Task: "sub", ContainerID: "c2", Started: started.Add(30 * time.Second)})
+
+	runs := listRunning(t, handler)
+	if len(runs) != 2 || runs[0].ContainerID != "c1" || runs[1].ContainerID != "c2" {
+		t.Fatalf("runs are %+v, want c1 then c2", runs)
+	}
+	if runs[0].Elapsed < 60 || runs[1].Elapsed < 30 || runs[1].Elapsed >= runs[0].Elapsed {
+		t.Errorf("elapsed times are %v and %v, want about 60 and 30", runs[0].Elapsed, runs[1].Elapsed)
+	}
+
+	removeOld()
+	if runs := listRunning(t, handler); len(runs) != 1 || runs[0].ContainerID != "c2" {
+		t.Errorf("after removing c1 runs are %+v, want c2", runs)
+	}
+}
+
+// A run is listed while its container runs and no longer once it ends.
+func TestRunImageRegistersActiveRun(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ADMIN_TOKEN": "secret"})
+	handler := requireAdmin(cfg, runningHandler(activeRuns))
+	docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds"/>`))
+	docker.images["base"] = fakeImage(nil)
+	docker.runFor = time.Hour
+
+	ctx, cancel := context.WithCancel(contextWithRequestID(context.Background(), "req-1"))
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+		runImage(ctx, cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	}()
+
+	var listed []ActiveRun
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if listed = listRunning(t, handler); len(listed) > 0 {
+			break
+		}
+	}
+	if len(listed) != 1 {
+		t.Fatalf("running list is %+v, want the one run", listed)
+	}
+	run := listed[0]
+	if run.RequestID != "req-1" || run.User != "alice" || run.Task != "sum" || run.ContainerID != docker.Container().ID {
+		t.Errorf("listed run is %+v", run)
+	}
+
+	cancel()
+	<-done
+	if runs := listRunning(t, handler); len(runs) != 0 {
+		t.Errorf("finished run is still listed: %+v", runs)
+	}
+}
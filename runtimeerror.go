@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+)
+
+// RuntimeError is an uncaught exception found in a run's stderr.
+type RuntimeError struct {
+	// Type is the error's class, e.g. "TypeError".
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// Frame is the innermost stack frame outside the runtime's own code,
+	// if the trace had one.
+	Frame *StackFrame `json:"frame,omitempty"`
+}
+
+// StackFrame is a location in a stack trace. File is relative to the
+// working dir when it lies inside it.
+type StackFrame struct {
+	Function string `json:"function,omitempty"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+// runtimeFailure is a run that ended without a usable report, with the
+// exception that most likely caused it.
+type runtimeFailure struct {
+	err          error
+	runtimeError *RuntimeError
+}
+
+func (f *runtimeFailure) Error() string {
+	return fmt.Sprintf("%v: uncaught %s: %s", f.err, f.runtimeError.Type, f.runtimeError.Message)
+}
+
+func (f *runtimeFailure) Unwrap() error {
+	return f.err
+}
+
+var (
+	// V8 prints the exception as Type: message, optionally with a Node
+	// error code, and Deno prefixes it with "error: Uncaught":
+	//
+	//	TypeError [ERR_INVALID_ARG_TYPE]: The "path" argument must be of type string
+	//	error: Uncaught (in promise) Error: boom
+	exceptionLine = regexp.MustCompile(`^(?:error: )?(?:Uncaught (?:\(in promise\) )?)?([A-Z][A-Za-z]*Error|Error)(?: \[[A-Z0-9_]+\])?: (.*)$`)
+	// stackFrame matches "at fn (file:line:col)" and "at file:line:col".
+	stackFrame = regexp.MustCompile(`^\s+at (?:(.+?) \()?(\S+?):(\d+):(\d+)\)?$`)
+)
+
+// internalFrame reports whether a frame is in Node's or Deno's own code.
+func internalFrame(file string) bool {
+	return strings.HasPrefix(file, "node:") || strings.HasPrefix(file, "ext:")
+}
+
+// parseRuntimeError finds the first uncaught exception in stderr and the
+// frame it was thrown from. Lines that aren't part of a trace are skipped,
+// and it returns nil when there is no exception.
+func parseRuntimeError(stderr string) *RuntimeError {
+	var found *RuntimeError
+	var internal *StackFrame
+
+	for _, line := range strings.Split(ansiEscape.ReplaceAllString(stderr, ""), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if match := exceptionLine.FindStringSubmatch(line); match != nil {
+			// A later exception only replaces one that had no frames.
+			if internal != nil {
+				break
+			}
+			found = &RuntimeError{Type: match[1], Message: match[2]}
+			continue
+		}
+		if found == nil {
+			continue
+		}
+
+		match := stackFrame.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		file, lineNumber, column := diagnosticLocation(strings.TrimPrefix(match[2], "file://"), match[3], match[4])
+		frame := &StackFrame{Function: match[1], File: file, Line: lineNumber, Column: column}
+		if !internalFrame(file) {
+			found.Frame = frame
+			return found
+		}
+		if internal == nil {
+			internal = frame
+		}
+	}
+
+	if found != nil && found.Frame == nil {
+		found.Frame = internal
+	}
+	return found
+}
+
+// readStderr returns the container's stderr, capped like the artifact logs.
+func readStderr(ctx context.Context, cli *client.Client, containerID string) ([]byte, error) {
+	logs, err := cli.ContainerLogs(ctx, containerID, client.ContainerLogsOptions{ShowStderr: true})
+	if err != nil {
+		return nil, fmt.Errorf("reading container stderr: %w", err)
+	}
+	defer logs.Close()
+
+	stderr := newCappedBuffer(maxArtifactLog)
+	if _, err := stdcopy.StdCopy(io.Discard, stderr, logs); err != nil {
+		return nil, fmt.Errorf("demultiplexing container stderr: %w", err)
+	}
+	return stderr.Contents(), nil
+}
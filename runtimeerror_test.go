@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseRuntimeError(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   *RuntimeError
+	}{
+		{"node", "/test/code.ts:3\n  return a.b;\n           ^\n\n" +
+			"TypeError: Cannot read properties of undefined (reading 'b')\n" +
+			"    at sum (/test/code.ts:3:12)\n" +
+			"    at Object.<anonymous> (/test/test.ts:5:1)\n" +
+			"    at Module._compile (node:internal/modules/cjs/loader:1254:14)\n" +
+			"\nNode.js v20.11.0\n",
+			&RuntimeError{Type: "TypeError", Message: "Cannot read properties of undefined (reading 'b')", Frame: &StackFrame{Function: "sum", File: "code.ts", Line: 3, Column: 12}}},
+		{"node error code", "node:fs:453\n    return binding.readFileUtf8(path, stringToFlags(options.flag));\n\n" +
+			"TypeError [ERR_INVALID_ARG_TYPE]: The \"path\" argument must be of type string. Received undefined\n" +
+			"    at Object.readFileSync (node:fs:453:20)\n" +
+			"    at load (/test/code.ts:7:15)\n",
+			&RuntimeError{Type: "TypeError", Message: "The \"path\" argument must be of type string. Received undefined", Frame: &StackFrame{Function: "load", File: "code.ts", Line: 7, Column: 15}}},
+		{"anonymous frame", "RangeError: Maximum call stack size exceeded\n    at /test/code.ts:2:10\n",
+			&RuntimeError{Type: "RangeError", Message: "Maximum call stack size exceeded", Frame: &StackFrame{File: "code.ts", Line: 2, Column: 10}}},
+		{"deno uncaught", "error: Uncaught (in promise) Error: boom\n    throw new Error(\"boom\");\n          ^\n    at file:///test/code.ts:4:11\n    at ext:core/01_core.js:10:5\n",
+			&RuntimeError{Type: "Error", Message: "boom", Frame: &StackFrame{File: "code.ts", Line: 4, Column: 11}}},
+		{"deno colours", "\x1b[0m\x1b[1m\x1b[31merror\x1b[0m: Uncaught Error: boom\n    at \x1b[0m\x1b[36mfile:///test/code.ts\x1b[0m:\x1b[0m\x1b[33m1\x1b[0m:\x1b[0m\x1b[33m7\x1b[0m\n",
+			&RuntimeError{Type: "Error", Message: "boom", Frame: &StackFrame{File: "code.ts", Line: 1, Column: 7}}},
+		{"outside the working dir", "Error: boom\r\n    at run (/usr/lib/runner.js:9:3)\r\n",
+			&RuntimeError{Type: "Error", Message: "boom", Frame: &StackFrame{Function: "run", File: "/usr/lib/runner.js", Line: 9, Column: 3}}},
+		{"only internal frames", "Error: spawn ENOENT\n    at ChildProcess._handle.onexit (node:internal/child_process:284:19)\n    at onErrorNT (node:internal/child_process:477:16)\n",
+			&RuntimeError{Type: "Error", Message: "spawn ENOENT", Frame: &StackFrame{Function: "ChildProcess._handle.onexit", File: "node:internal/child_process", Line: 284, Column: 19}}},
+		{"no frames", "SyntaxError: Unexpected token '}'\n",
+			&RuntimeError{Type: "SyntaxError", Message: "Unexpected token '}'"}},
+		{"first trace wins", "noise before\nTypeError: first\n    at a (/test/code.ts:1:1)\nError: second\n    at b (/test/code.ts:2:2)\n",
+			&RuntimeError{Type: "TypeError", Message: "first", Frame: &StackFrame{Function: "a", File: "code.ts", Line: 1, Column: 1}}},
+		{"frameless exception replaced", "Error: rethrown\nTypeError: cause\n    at c (/test/code.ts:3:3)\n",
+			&RuntimeError{Type: "TypeError", Message: "cause", Frame: &StackFrame{Function: "c", File: "code.ts", Line: 3, Column: 3}}},
+		{"frames before any exception", "    at a (/test/code.ts:1:1)\nsome log line\n", nil},
+		{"no exception", "Download https://deno.land/std/assert/mod.ts\nFAILED | 0 passed | 1 failed\nerror: Test failed\n", nil},
+		{"lowercase error", "error: something went wrong\n", nil},
+		{"empty", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseRuntimeError(test.stderr); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseRuntimeError = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// A run that throws before writing its report fails with the exception,
+// which the error body carries.
+func TestRunImageRuntimeError(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.images["base"] = fakeImage(nil)
+	docker.exitCode = 1
+	docker.stderr = "TypeError: a is not a function\n    at sum (/test/code.ts:2:9)\n"
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	_, err := runImage(context.Background(), testConfig(t, nil), docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	failure := &runtimeFailure{}
+	if !errors.As(err, &failure) {
+		t.Fatalf("run failed with %v, want a runtime failure", err)
+	}
+
+	want := &RuntimeError{Type: "TypeError", Message: "a is not a function", Frame: &StackFrame{Function: "sum", File: "code.ts", Line: 2, Column: 9}}
+	if _, body := runErrorBody(err); !reflect.DeepEqual(body.RuntimeError, want) {
+		t.Errorf("error body has runtime error %+v, want %+v", body.RuntimeError, want)
+	}
+}
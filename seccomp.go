@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// Seccomp profiles use the Docker/OCI JSON format. The shipped
+// seccomp/default.json is Docker's default allowlist, so any syscall it
+// doesn't name fails with EPERM, less the syscalls a test has no business
+// making: kernel modules and keyrings, mounts and namespaces, ptrace and
+// cross-process memory access, clock changes, reboot, io_uring and bpf.
+// As in Docker's, clone is only allowed without namespace flags, and clone3,
+// whose flags seccomp can't inspect, fails with ENOSYS so libc falls back
+// to clone.
+//
+// A custom profile replaces the daemon's default profile rather than adding
+// to it, so start from seccomp/default.json and only allow what the task
+// needs, by adding the syscall to the allowlist or a rule such as
+//
+//	{"names": ["kcmp"], "action": "SCMP_ACT_ALLOW"}
+//
+// Profiles apply at three levels:
+//
+//   - SECCOMP_PROFILE="" uses the shipped profile,
+//   - SECCOMP_PROFILE=docker-default leaves the daemon's default in place,
+//   - any other SECCOMP_PROFILE is the path of a profile on the server,
+//
+// and a task's seccompProfile metadata names a profile file in its own
+// directory that replaces the server's for that task.
+const dockerDefaultSeccomp = "docker-default"
+
+// seccompProfile is the server-wide profile, or empty for the daemon's
+// default. It is set once at startup by loadSeccompProfile.
+var seccompProfile string
+
+func loadSeccompProfile(setting string) error {
+	var profile []byte
+	var err error
+	switch setting {
+	case dockerDefaultSeccomp:
+		return nil
+	case "":
+		profile, err = files.ReadFile("seccomp/default.json")
+	default:
+		profile, err = os.ReadFile(setting)
+	}
+	if err != nil {
+		return fmt.Errorf("reading seccomp profile: %w", err)
+	}
+	if !json.Valid(profile) {
+		return fmt.Errorf("seccomp profile %q is not valid JSON", setting)
+	}
+
+	seccompProfile = string(profile)
+	return nil
+}
+
+// securityOpts returns the container security options for the task.
+func securityOpts(task string, meta Metadata) ([]string, error) {
+	profile := seccompProfile
+	if meta.seccompTask != "" {
+		task = meta.seccompTask
+	}
+	if meta.SeccompProfile != "" {
+		data, err := files.ReadFile(path.Join("tests", task, meta.SeccompProfile))
+		if err != nil {
+			return nil, fmt.Errorf("reading seccomp profile for %s: %w", task, err)
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("seccomp profile for %s is not valid JSON", task)
+		}
+		profile = string(data)
+	}
+
+	if profile == "" {
+		return nil, nil
+	}
+	return []string{"seccomp=" + profile}, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type seccompArg struct {
+	Index    int    `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+type seccompRule struct {
+	Names    []string     `json:"names"`
+	Action   string       `json:"action"`
+	ErrnoRet *int         `json:"errnoRet"`
+	Args     []seccompArg `json:"args"`
+}
+
+type seccompProfileJSON struct {
+	DefaultAction   string        `json:"defaultAction"`
+	DefaultErrnoRet *int          `json:"defaultErrnoRet"`
+	Syscalls        []seccompRule `json:"syscalls"`
+}
+
+func parseSeccompProfile(t *testing.T, profile string) seccompProfileJSON {
+	t.Helper()
+
+	parsed := seccompProfileJSON{}
+	if err := json.Unmarshal([]byte(profile), &parsed); err != nil {
+		t.Fatalf("decoding profile: %v", err)
+	}
+	return parsed
+}
+
+// seccompAction returns the action and errno the profile takes for a
+// syscall whatever its arguments, ignoring rules qualified by them.
+func seccompAction(t *testing.T, profile string, syscall string) (string, int) {
+	t.Helper()
+
+	parsed := parseSeccompProfile(t, profile)
+	for _, rule := range parsed.Syscalls {
+		if slices.Contains(rule.Names, syscall) && len(rule.Args) == 0 {
+			errno := 0
+			if rule.ErrnoRet != nil {
+				errno = *rule.ErrnoRet
+			}
+			return rule.Action, errno
+		}
+	}
+	errno := 0
+	if parsed.DefaultErrnoRet != nil {
+		errno = *parsed.DefaultErrnoRet
+	}
+	return parsed.DefaultAction, errno
+}
+
+// cloneAllowed reports whether the profile allows clone with the flags,
+// evaluating the masked comparisons on its first argument.
+func cloneAllowed(t *testing.T, profile string, flags uint64) bool {
+	t.Helper()
+
+	for _, rule := range parseSeccompProfile(t, profile).Syscalls {
+		if !slices.Contains(rule.Names, "clone") || rule.Action != "SCMP_ACT_ALLOW" {
+			continue
+		}
+		matches := true
+		for _, arg := range rule.Args {
+			if arg.Index != 0 || arg.Op != "SCMP_CMP_MASKED_EQ" {
+				t.Fatalf("clone rule compares %+v, want a mask of the flags", arg)
+			}
+			matches = matches && flags&arg.Value == arg.ValueTwo
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDefaultSeccompProfile(t *testing.T) {
+	t.Cleanup(func() { seccompProfile = "" })
+	if err := loadSeccompProfile(""); err != nil {
+		t.Fatal(err)
+	}
+
+	const eperm, enosys = 1, 38
+	for _, syscall := range []string{"mount", "unshare", "setns", "ptrace", "process_vm_readv", "bpf", "keyctl", "init_module", "kexec_load", "reboot", "io_uring_setup", "clock_settime"} {
+		if action, errno := seccompAction(t, seccompProfile, syscall); action != "SCMP_ACT_ERRNO" || errno != eperm {
+			t.Errorf("%s is %s with errno %d, want it to fail with EPERM", syscall, action, errno)
+		}
+	}
+	if action, errno := seccompAction(t, seccompProfile, "clone3"); action != "SCMP_ACT_ERRNO" || errno != enosys {
+		t.Errorf("clone3 is %s with errno %d, want ENOSYS so libc falls back to clone", action, errno)
+	}
+	// What deno needs to run a test.
+	for _, syscall := range []string{"read", "write", "openat", "mmap", "futex", "epoll_wait", "execve", "statx", "rseq"} {
+		if action, _ := seccompAction(t, seccompProfile, syscall); action != "SCMP_ACT_ALLOW" {
+			t.Errorf("%s is %s, want it allowed", syscall, action)
+		}
+	}
+
+	// It's an allowlist: syscalls it doesn't name, including any a newer
+	// kernel adds, fail.
+	for _, syscall := range []string{"personality", "kcmp", "sysfs", "uselib", "some_future_syscall"} {
+		if action, errno := seccompAction(t, seccompProfile, syscall); action != "SCMP_ACT_ERRNO" || errno != eperm {
+			t.Errorf("%s is %s with errno %d, want it to fail with EPERM", syscall, action, errno)
+		}
+	}
+
+	// Threads and forks clone, but a new namespace doesn't.
+	const (
+		cloneThread = 0x00000100 | 0x00000200 | 0x00000400 | 0x00000800 | 0x00010000 // CLONE_VM|FS|FILES|SIGHAND|THREAD
+		cloneFork   = 0x01000000 | 0x00200000 | 17                                   // CLONE_CHILD_SETTID|CHILD_CLEARTID|SIGCHLD
+	)
+	for name, flags := range map[string]uint64{"thread": cloneThread, "fork": cloneFork} {
+		if !cloneAllowed(t, seccompProfile, flags) {
+			t.Errorf("clone for a %s is denied", name)
+		}
+	}
+	namespaces := map[string]uint64{"CLONE_NEWNS": 0x00020000, "CLONE_NEWCGROUP": 0x02000000, "CLONE_NEWUTS": 0x04000000, "CLONE_NEWIPC": 0x08000000, "CLONE_NEWUSER": 0x10000000, "CLONE_NEWPID": 0x20000000, "CLONE_NEWNET": 0x40000000}
+	for name, flag := range namespaces {
+		if cloneAllowed(t, seccompProfile, cloneFork|flag) {
+			t.Errorf("clone with %s is allowed", name)
+		}
+	}
+}
+
+func TestSecurityOpts(t *testing.T) {
+	t.Cleanup(func() { seccompProfile = "" })
+
+	if err := loadSeccompProfile(dockerDefaultSeccomp); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := securityOpts("sum", Metadata{}); err != nil || opts != nil {
+		t.Errorf("docker-default gives %v, %v; want no options", opts, err)
+	}
+
+	if err := loadSeccompProfile(""); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := securityOpts("sum", Metadata{})
+	if err != nil || len(opts) != 1 || opts[0] != "seccomp="+seccompProfile {
+		t.Errorf("shipped profile gives %d options, %v", len(opts), err)
+	}
+
+	if _, err := securityOpts("sum", Metadata{SeccompProfile: "missing.json"}); err == nil {
+		t.Error("task naming a missing profile got options")
+	}
+	if err := loadSeccompProfile("/nonexistent/profile.json"); err == nil {
+		t.Error("missing server profile loaded")
+	}
+}
+
+// The profile has to reach the container for a blocked syscall to fail in
+// it.
+func TestRunImageAppliesSeccompProfile(t *testing.T) {
+	t.Cleanup(func() { seccompProfile = "" })
+	if err := loadSeccompProfile(""); err != nil {
+		t.Fatal(err)
+	}
+	docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="sum"/>`))
+	docker.images["base"] = fakeImage(nil)
+	cfg := testConfig(t, nil)
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	if _, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := docker.Container().HostConfig.SecurityOpt
+	if len(opts) != 1 || !strings.HasPrefix(opts[0], "seccomp=") {
+		t.Fatalf("container security options are %v, want the seccomp profile", opts)
+	}
+	if action, _ := seccompAction(t, strings.TrimPrefix(opts[0], "seccomp="), "unshare"); action != "SCMP_ACT_ERRNO" {
+		t.Errorf("container's profile allows unshare")
+	}
+}
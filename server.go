@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+)
+
+// newServer applies the configured connection limits to the HTTP server.
+//
+// WriteTimeout bounds the whole handler, including the image build and test
+// run, so it defaults to well above the longest expected run. Streaming
+// endpoints (SSE, WebSocket) outlive any fixed write deadline and must clear
+// it for their connection with http.ResponseController.SetWriteDeadline, or
+// take over the connection by hijacking it.
+func newServer(cfg *Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sync"
+
+	"github.com/moby/moby/client"
+)
+
+const smokeTestUser = "smoke-test"
+
+var errNoSolution = errors.New("no reference solution")
+
+// smokeTestTask runs the task's packaged solution.ts through the full
+// pipeline, holding build and run slots like any other run, and fails
+// unless every case passes.
+func smokeTestTask(ctx context.Context, cfg *Config, cli *client.Client, builds *limiter, runs *limiter, task string) error {
+	solution, err := files.ReadFile(path.Join("tests", task, "solution.ts"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errNoSolution
+	}
+	if err != nil {
+		return fmt.Errorf("reading solution: %w", err)
+	}
+
+	execution, err := executeCodeTest(ctx, cfg, cli, RunRequest{Task: task, User: smokeTestUser, Code: string(solution), Builds: builds, Runs: runs})
+	if err != nil {
+		return err
+	}
+
+	result, err := buildResult(cfg, task, execution)
+	if err != nil {
+		return err
+	}
+	if result.Total == 0 || result.Failed > 0 {
+		return fmt.Errorf("reference solution passed %d of %d cases", result.Passed, result.Total)
+	}
+
+	return nil
+}
+
+// runSmokeTests smoke tests every embedded task, at most
+// cfg.WarmupConcurrency at once, and returns the failures. Tasks without a
+// reference solution are skipped.
+func runSmokeTests(ctx context.Context, cfg *Config, cli *client.Client, builds *limiter, runs *limiter) []error {
+	tasks, err := listTasks()
+	if err != nil {
+		return []error{fmt.Errorf("listing tasks: %w", err)}
+	}
+
+	mu := sync.Mutex{}
+	failures := []error{}
+	slots := make(chan struct{}, cfg.WarmupConcurrency)
+	wg := sync.WaitGroup{}
+	for _, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			err := smokeTestTask(ctx, cfg, cli, builds, runs, task)
+			switch {
+			case errors.Is(err, errNoSolution):
+				slog.Info("smoke test skipped", "task", task, "reason", err)
+			case err != nil:
+				slog.Error("smoke test failed", "task", task, "error", err)
+				mu.Lock()
+				failures = append(failures, fmt.Errorf("task %s: %w", task, err))
+				mu.Unlock()
+			default:
+				slog.Info("smoke test passed", "task", task)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return failures
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSmokeTestTask(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t).withImage(cfg, "sum").withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+
+	if err := smokeTestTask(context.Background(), cfg, docker.client, nil, nil, "sum"); err != nil {
+		t.Fatalf("passing smoke test failed: %v", err)
+	}
+	if err := smokeTestTask(context.Background(), cfg, docker.client, nil, nil, "fizzbuzzer"); !errors.Is(err, errNoSolution) {
+		t.Errorf("task without a solution got %v, want errNoSolution", err)
+	}
+
+	docker.withReport(junitReport(`<testcase name="adds" classname="test.ts"><failure message="expected 3"/></testcase>`))
+	if err := smokeTestTask(context.Background(), cfg, docker.client, nil, nil, "sum"); err == nil {
+		t.Error("smoke test with a failing case passed")
+	}
+}
+
+// Smoke tests hold build and run slots like any run, each limit applying
+// on its own.
+func TestSmokeTestTaskLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		hasImage   bool
+		fullRuns   bool
+		fullBuilds bool
+		wantErr    bool
+	}{
+		{"runs full", true, true, false, true},
+		{"builds full, image built", true, false, true, false},
+		{"builds full, image missing", false, false, true, true},
+		{"both free", false, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, nil)
+			docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="test.ts"/>`))
+			if tt.hasImage {
+				docker.withImage(cfg, "sum")
+			}
+			builds, runs := newLimiter(1), newLimiter(1)
+			if tt.fullBuilds {
+				builds = fullLimiter(t)
+			}
+			if tt.fullRuns {
+				runs = fullLimiter(t)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := smokeTestTask(ctx, cfg, docker.client, builds, runs, "sum")
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("smoke test failed: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errNoSlot) {
+				t.Fatalf("got %v, want errNoSlot", err)
+			}
+			if len(docker.Containers()) != 0 {
+				t.Error("smoke test started a container without its slots")
+			}
+		})
+	}
+}
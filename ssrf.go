@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var errPrivateAddress = errors.New("address is not publicly routable")
+
+// sharedAddressSpace is the carrier-grade NAT range, 100.64.0.0/10, which
+// netip doesn't count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether addr is a unicast address on the public
+// internet, rejecting loopback, RFC 1918 and unique local, link-local
+// (including the 169.254.169.254 metadata service), CGNAT, multicast and
+// unspecified addresses.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!addr.IsLoopback() &&
+		!addr.IsLinkLocalUnicast() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// validatePublicURL resolves the URL's host and fails unless every address
+// it resolves to is public. Use it wherever a user-supplied URL is accepted.
+func validatePublicURL(ctx context.Context, raw string) error {
+	target, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	host := target.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !publicAddress(addr) {
+			return fmt.Errorf("%w: %s", errPrivateAddress, addr)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr) {
+			return fmt.Errorf("%w: %s resolves to %s", errPrivateAddress, host, addr)
+		}
+	}
+
+	return nil
+}
+
+// publicOnlyDialer refuses connections to non-public addresses. Validating
+// a URL up front isn't enough: its DNS can change between the check and the
+// request, so clients that fetch user-supplied URLs must dial through this.
+func publicOnlyDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+}
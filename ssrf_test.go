@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"fd00::1":         false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"224.0.0.1":       false,
+		// An IPv4-mapped address is checked as the IPv4 it maps.
+		"::ffff:127.0.0.1": false,
+		"::ffff:10.0.0.1":  false,
+	}
+	for addr, want := range tests {
+		if got := publicAddress(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddress(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestValidatePublicURL(t *testing.T) {
+	for _, raw := range []string{
+		"http://127.0.0.1/",
+		"http://[::1]:8080/",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://10.0.0.5/hook",
+		"http://localhost/",
+	} {
+		if err := validatePublicURL(context.Background(), raw); !errors.Is(err, errPrivateAddress) {
+			t.Errorf("validatePublicURL(%q) = %v, want errPrivateAddress", raw, err)
+		}
+	}
+	if err := validatePublicURL(context.Background(), "https://93.184.216.34/hook"); err != nil {
+		t.Errorf("public address rejected: %v", err)
+	}
+}
+
+// A host that passed validation can resolve elsewhere by the time it is
+// dialed, so the dialer checks the address it actually connects to.
+func TestPublicOnlyDialerRefusesRebinding(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	for _, address := range []string{listener.Addr().String(), "localhost:" + port, "169.254.169.254:80", "[::1]:80"} {
+		conn, err := publicOnlyDialer().DialContext(context.Background(), "tcp", address)
+		if err == nil {
+			conn.Close()
+			t.Errorf("dialer connected to %s", address)
+			continue
+		}
+		if !errors.Is(err, errPrivateAddress) {
+			t.Errorf("dialing %s gave %v, want errPrivateAddress", address, err)
+		}
+	}
+}
+
+// Webhook deliveries go through the dialer, so a callback host that
+// resolves to loopback at delivery time isn't reached.
+func TestWebhookSenderRefusesPrivateTarget(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { reached = true }))
+	defer server.Close()
+	cfg := testConfig(t, map[string]string{"WEBHOOK_ALLOWED_HOSTS": "localhost", "WEBHOOK_SECRET": "s3cret", "WEBHOOK_ATTEMPTS": "1"})
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	err := newWebhookSender(cfg).Deliver(context.Background(), "http://localhost:"+port+"/hook", Job{ID: "job-1"})
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("delivery to loopback gave %v, want errPrivateAddress", err)
+	}
+	if reached {
+		t.Error("delivery reached the loopback server")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+type ResourceUsage struct {
+	// CPUTime is the total CPU time consumed, in nanoseconds.
+	CPUTime uint64 `json:"cpuTimeNs"`
+	// PeakMemory is the highest memory usage observed, in bytes.
+	PeakMemory uint64 `json:"peakMemoryBytes"`
+	Samples    int    `json:"samples"`
+}
+
+func (u *ResourceUsage) add(stats container.StatsResponse, osType string) {
+	u.Samples++
+
+	cpu := stats.CPUStats.CPUUsage.TotalUsage
+	memory := max(stats.MemoryStats.Usage, stats.MemoryStats.MaxUsage)
+	if osType == "windows" {
+		// Windows reports CPU in 100ns units and memory as commit charge.
+		cpu *= 100
+		memory = max(stats.MemoryStats.Commit, stats.MemoryStats.CommitPeak)
+	}
+
+	// Counters are cumulative, but the final frame of a stopped container
+	// may be zeroed, so keep the highest value seen.
+	u.CPUTime = max(u.CPUTime, cpu)
+	u.PeakMemory = max(u.PeakMemory, memory)
+}
+
+// collectStats samples the container's streamed stats until the stream ends
+// or ctx is done, returning the usage seen so far in either case. Once memory
+// use reaches thresholdPercent of the container's limit, pressure is closed;
+// a zero threshold disables the check.
+func collectStats(ctx context.Context, cli *client.Client, containerID string, thresholdPercent int, pressure chan<- struct{}) (*ResourceUsage, error) {
+	usage := &ResourceUsage{}
+
+	stats, err := cli.ContainerStats(ctx, containerID, true)
+	if err != nil {
+		return usage, fmt.Errorf("reading container stats: %w", err)
+	}
+	defer stats.Body.Close()
+
+	decoder := json.NewDecoder(stats.Body)
+	for {
+		sample := container.StatsResponse{}
+		if err := decoder.Decode(&sample); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return usage, nil
+			}
+			return usage, fmt.Errorf("decoding container stats: %w", err)
+		}
+		usage.add(sample, stats.OSType)
+
+		if thresholdPercent > 0 && pressure != nil && overMemoryThreshold(sample, thresholdPercent) {
+			close(pressure)
+			pressure = nil
+		}
+	}
+}
+
+// workingSetMemory is the sample's memory use without the inactive page
+// cache, which the kernel reclaims before it OOM-kills, as docker stats
+// reports it. cgroup v1 calls the cache total_inactive_file and v2
+// inactive_file.
+func workingSetMemory(stats container.MemoryStats) uint64 {
+	if inactive, ok := stats.Stats["total_inactive_file"]; ok && inactive < stats.Usage {
+		return stats.Usage - inactive
+	}
+	if inactive := stats.Stats["inactive_file"]; inactive < stats.Usage {
+		return stats.Usage - inactive
+	}
+	return stats.Usage
+}
+
+// overMemoryThreshold reports whether the sample's working set is at least
+// thresholdPercent of its limit. Without a container limit the daemon
+// reports the host's memory as the limit.
+func overMemoryThreshold(stats container.StatsResponse, thresholdPercent int) bool {
+	limit := stats.MemoryStats.Limit
+	return limit > 0 && workingSetMemory(stats.MemoryStats)*100 >= limit*uint64(thresholdPercent)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+// memorySample is a stats sample using usage of a 100 MiB limit, inactive
+// of it page cache.
+func memorySample(usage uint64, stats map[string]uint64) container.StatsResponse {
+	return container.StatsResponse{MemoryStats: container.MemoryStats{Usage: usage << 20, Limit: 100 << 20, Stats: stats}}
+}
+
+func TestOverMemoryThreshold(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample container.StatsResponse
+		over   bool
+	}{
+		{"below", memorySample(50, nil), false},
+		{"at", memorySample(95, nil), true},
+		{"cgroup v2 page cache", memorySample(98, map[string]uint64{"inactive_file": 30 << 20}), false},
+		{"cgroup v2 working set", memorySample(98, map[string]uint64{"inactive_file": 1 << 20}), true},
+		{"cgroup v1 page cache", memorySample(98, map[string]uint64{"total_inactive_file": 30 << 20}), false},
+		{"cgroup v1 working set", memorySample(98, map[string]uint64{"total_inactive_file": 1 << 20, "inactive_file": 30 << 20}), true},
+		{"cache over usage", memorySample(96, map[string]uint64{"inactive_file": 200 << 20}), true},
+		{"no limit", container.StatsResponse{MemoryStats: container.MemoryStats{Usage: 1 << 30}}, false},
+	}
+	for _, test := range tests {
+		if got := overMemoryThreshold(test.sample, 95); got != test.over {
+			t.Errorf("%s: overMemoryThreshold = %v, want %v", test.name, got, test.over)
+		}
+	}
+}
+
+func TestRunImageMemoryThreshold(t *testing.T) {
+	tests := []struct {
+		name   string
+		stats  []container.StatsResponse
+		killed bool
+	}{
+		{"memory hungry", []container.StatsResponse{memorySample(40, nil), memorySample(97, map[string]uint64{"inactive_file": 1 << 20})}, true},
+		{"page cache heavy", []container.StatsResponse{memorySample(40, nil), memorySample(97, map[string]uint64{"inactive_file": 40 << 20})}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docker := newFakeDocker(t).withReport(junitReport(`<testcase name="adds" classname="sum"/>`))
+			docker.images["base"] = fakeImage(nil)
+			docker.stats = test.stats
+			docker.runFor = 200 * time.Millisecond
+			cfg := testConfig(t, map[string]string{"MEMORY_KILL_THRESHOLD": "95"})
+
+			req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+			execution, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+			if execution.MemoryExceeded != test.killed {
+				t.Errorf("MemoryExceeded is %v, want %v", execution.MemoryExceeded, test.killed)
+			}
+			if killed := docker.Called("POST /containers/c1/kill"); killed != test.killed {
+				t.Errorf("container killed is %v, want %v", killed, test.killed)
+			}
+			// A killed run still has its report read, for a partial result.
+			if err != nil {
+				t.Errorf("run failed: %v", err)
+			}
+			if execution.Usage == nil || execution.Usage.PeakMemory != 97<<20 {
+				t.Errorf("usage is %+v, want a 97 MiB peak", execution.Usage)
+			}
+		})
+	}
+}
+
+func TestRunImageMemoryExceededWithoutReport(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.images["base"] = fakeImage(nil)
+	docker.stats = []container.StatsResponse{memorySample(99, nil)}
+	docker.runFor = time.Hour
+	cfg := testConfig(t, nil)
+
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	execution, err := runImage(context.Background(), cfg, docker.client, req, Metadata{}, "base", &Execution{ExitCode: -1})
+	if !errors.Is(err, errMemoryExceeded) {
+		t.Errorf("killed run without a report gave %v, want errMemoryExceeded", err)
+	}
+	if execution.ExitReason != "killed nearing its memory limit" {
+		t.Errorf("exit reason is %q", execution.ExitReason)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// toTAP renders the result as a TAP version 13 stream, attaching failure
+// messages as YAML diagnostics.
+func toTAP(result RunResult) []byte {
+	builder := strings.Builder{}
+	builder.WriteString("TAP version 13\n")
+	fmt.Fprintf(&builder, "1..%d\n", len(result.Cases))
+
+	for i, c := range result.Cases {
+		if c.Status == StatusSkipped {
+			fmt.Fprintf(&builder, "ok %d - %s # SKIP", i+1, tapEscape(c.Name))
+			if c.Message != "" {
+				builder.WriteString(" " + tapEscape(c.Message))
+			}
+			builder.WriteString("\n")
+			continue
+		}
+
+		status := "ok"
+		if c.Status != StatusPassed {
+			status = "not ok"
+		}
+		fmt.Fprintf(&builder, "%s %d - %s\n", status, i+1, tapEscape(c.Name))
+
+		if c.Status != StatusPassed {
+			builder.WriteString("  ---\n")
+			fmt.Fprintf(&builder, "  status: %s\n", c.Status)
+			if c.Message != "" {
+				fmt.Fprintf(&builder, "  message: %s\n", strconv.Quote(c.Message))
+			}
+			if c.Details != "" {
+				fmt.Fprintf(&builder, "  details: %s\n", strconv.Quote(c.Details))
+			}
+			builder.WriteString("  ...\n")
+		}
+	}
+
+	return []byte(builder.String())
+}
+
+func tapEscape(name string) string {
+	name = strings.ReplaceAll(name, "\n", " ")
+	return strings.ReplaceAll(name, "#", "\\#")
+}
+
+var tapTestLine = regexp.MustCompile(`^(not )?ok\b\s*(?:\d+)?\s*(?:- )?(.*)$`)
+
+// parseTAP converts a TAP stream into a result. Only top-level test lines
+// count; indented subtests are ignored. A "# SKIP" directive marks the case
+// skipped, as does "# TODO" on a failing case, which TAP doesn't count as a
+// failure. Failure diagnostics are read from the YAML block toTAP writes,
+// and "Bail out!" ends the stream with an errored case.
+func parseTAP(report []byte, maxCases int) (RunResult, error) {
+	result := newRunResult()
+	cases := []TestCase{}
+	inYAML := false
+
+	for _, line := range strings.Split(string(report), "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		if inYAML {
+			if trimmed == "..." {
+				inYAML = false
+				continue
+			}
+			key, value, ok := strings.Cut(trimmed, ":")
+			if !ok || len(cases) == 0 {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			last := &cases[len(cases)-1]
+			switch key {
+			case "message":
+				last.Message = value
+			case "details":
+				last.Details = value
+			case "status":
+				if value == StatusError && last.Status == StatusFailed {
+					last.Status = StatusError
+				}
+			}
+			continue
+		}
+
+		if trimmed == "---" && line != trimmed {
+			inYAML = true
+			continue
+		}
+		if strings.HasPrefix(line, "Bail out!") {
+			cases = append(cases, TestCase{
+				Name:    "bail out",
+				Status:  StatusError,
+				Message: strings.TrimSpace(strings.TrimPrefix(line, "Bail out!")),
+			})
+			break
+		}
+
+		match := tapTestLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		name, directive := splitTAPDirective(match[2])
+		testCase := TestCase{Name: name, Status: StatusPassed}
+		if match[1] != "" {
+			testCase.Status = StatusFailed
+		}
+		if keyword, reason, _ := strings.Cut(directive, " "); strings.EqualFold(keyword, "SKIP") ||
+			(strings.EqualFold(keyword, "TODO") && testCase.Status == StatusFailed) {
+			testCase.Status = StatusSkipped
+			testCase.Message = strings.TrimSpace(reason)
+		}
+		cases = append(cases, testCase)
+	}
+
+	for _, testCase := range cases {
+		result.addCase(testCase, maxCases)
+	}
+	return result, nil
+}
+
+// splitTAPDirective splits a test line's description at its first unescaped
+// "#", undoing tapEscape on the name.
+func splitTAPDirective(description string) (string, string) {
+	name := strings.Builder{}
+	for i := 0; i < len(description); i++ {
+		switch {
+		case description[i] == '\\' && i+1 < len(description) && description[i+1] == '#':
+			name.WriteByte('#')
+			i++
+		case description[i] == '#':
+			return strings.TrimSpace(name.String()), strings.TrimSpace(description[i+1:])
+		default:
+			name.WriteByte(description[i])
+		}
+	}
+	return strings.TrimSpace(name.String()), ""
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Metadata struct {
+	Points     int    `json:"points"`
+	Language   string `json:"language"`
+	Difficulty string `json:"difficulty,omitempty"`
+	BaseImage  string `json:"baseImage,omitempty"`
+	// Timeout shortens the run timeout for this task, e.g. "30s". It is
+	// capped by the server's RUN_TIMEOUT.
+	Timeout string `json:"timeout,omitempty"`
+	// Rebuild forces a full image build per submission instead of copying
+	// the code into a container created from the task's base image.
+	Rebuild bool `json:"rebuild"`
+	// WorkingDir is where the code and test live in the container and where
+	// the runner starts. Defaults to /test, matching the packaged Dockerfile.
+	WorkingDir string `json:"workingDir,omitempty"`
+	// ReportPath is the runner's report. Relative paths resolve
+	// against WorkingDir. Defaults to report.xml.
+	ReportPath string `json:"reportPath,omitempty"`
+	// ReportFormat is the report's format: "junit", "tap" or "json". Empty
+	// detects it from the report's extension and content.
+	ReportFormat string `json:"reportFormat,omitempty"`
+	// ReportDir, when set, is a directory in the container whose JUnit
+	// reports are merged into a single result. Relative paths resolve
+	// against WorkingDir.
+	ReportDir string `json:"reportDir"`
+	// CompareOutput diffs the container's stdout against the task's
+	// expected.txt and reports the result as an extra test case.
+	CompareOutput bool `json:"compareOutput"`
+	// BuildTarget selects a stage of a multi-stage Dockerfile to build.
+	// Empty builds the final stage.
+	BuildTarget string `json:"buildTarget"`
+	// RequireReport defaults to true. When false, a run that exits zero
+	// without writing a report passes.
+	RequireReport *bool `json:"requireReport,omitempty"`
+	// MaxConcurrentRuns limits how many runs of this task may execute at
+	// once, on top of the server-wide limit. Zero means no task limit.
+	MaxConcurrentRuns int `json:"maxConcurrentRuns,omitempty"`
+	// BuildArgs are passed to the Dockerfile's ARG instructions, e.g.
+	// {"DENO_VERSION": "2.1.4"}.
+	BuildArgs map[string]string `json:"buildArgs,omitempty"`
+	// BuildNetwork overrides the server's BUILD_NETWORK_MODE for this
+	// task's image builds.
+	BuildNetwork string `json:"buildNetwork,omitempty"`
+	// SeccompProfile names a seccomp profile in the task's directory that
+	// replaces the server's profile for its runs.
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+	// DNS lists the resolvers and ExtraHosts the "name:ip" entries added to
+	// /etc/hosts in the task's test containers. They only apply when the
+	// server's RUN_NETWORK_MODE enables networking, so that resolution
+	// points only at the endpoints the task means to reach.
+	DNS        []string `json:"dns,omitempty"`
+	ExtraHosts []string `json:"extraHosts,omitempty"`
+	// TestCommand replaces the image's CMD. With the packaged Deno image
+	// these are arguments to deno, e.g. ["test", "--junit-path=report.xml"].
+	TestCommand []string `json:"testCommand,omitempty"`
+	// Entrypoint replaces the image's ENTRYPOINT. The daemon then drops the
+	// image's CMD as well, so the runner's arguments come from TestCommand
+	// alone.
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	// StreamProgress defaults to true. Set it to false when the task's
+	// runner doesn't print deno's per-test lines, so streaming clients
+	// aren't left tailing a log that never reports a case.
+	StreamProgress *bool `json:"streamProgress,omitempty"`
+	// StopSignal is sent to the runner when a run times out, before it is
+	// killed at the end of the server's STOP_GRACE_PERIOD. Defaults to
+	// SIGTERM; runners that flush their report on another signal name it
+	// here.
+	StopSignal string `json:"stopSignal,omitempty"`
+	// MemoryLimit, such as "1g", and CPULimit, in CPUs, replace the
+	// server's RUN_MEMORY_LIMIT and RUN_CPU_LIMIT for the task's test
+	// containers. The server's RUN_MAX_MEMORY_LIMIT and RUN_MAX_CPU_LIMIT
+	// still cap them.
+	MemoryLimit string  `json:"memoryLimit,omitempty"`
+	CPULimit    float64 `json:"cpuLimit,omitempty"`
+	// PollReport, for runners that linger after writing their report,
+	// checks for the report every REPORT_POLL_INTERVAL while the container
+	// runs and kills it once a complete report is found. It can't be
+	// combined with ReportDir.
+	PollReport bool `json:"pollReport,omitempty"`
+	// Hostname replaces the server's RUN_HOSTNAME for the task's test
+	// containers, for tests that expect a particular name.
+	Hostname string `json:"hostname,omitempty"`
+
+	// seccompTask is the task whose directory SeccompProfile is in, when it
+	// isn't the run's own, as for a composite run.
+	seccompTask string
+}
+
+func (m Metadata) requiresReport() bool {
+	return m.RequireReport == nil || *m.RequireReport
+}
+
+func (m Metadata) streamsProgress() bool {
+	return m.StreamProgress == nil || *m.StreamProgress
+}
+
+func (m Metadata) hostname(fallback string) string {
+	if m.Hostname == "" {
+		return fallback
+	}
+	return m.Hostname
+}
+
+func (m Metadata) stopSignal() string {
+	if m.StopSignal == "" {
+		return "SIGTERM"
+	}
+	return m.StopSignal
+}
+
+var stageName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// stopSignals are the signals a task may stop its runner with. SIGKILL is
+// left out: it gives the runner no chance to write a report.
+var stopSignals = map[string]bool{"SIGTERM": true, "SIGINT": true, "SIGQUIT": true, "SIGHUP": true, "SIGUSR1": true, "SIGUSR2": true}
+
+var hostName = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+
+// hostLabel is a container hostname: a single DNS label.
+var hostLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// validDomainname reports whether name is dot-separated DNS labels that fit
+// in a domain name.
+func validDomainname(name string) bool {
+	if len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// Build arg values end up in RUN instructions, so they are limited to
+// characters that can't break out of a shell word.
+var (
+	buildArgName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	buildArgValue = regexp.MustCompile(`^[a-zA-Z0-9_.:/@+=-]*$`)
+)
+
+// networkModes are the network modes builds and runs may use. Named
+// networks are excluded so tasks can't attach to arbitrary host networks.
+var networkModes = map[string]bool{"default": true, "bridge": true, "host": true, "none": true}
+
+// buildNetwork returns the network mode for the task's builds.
+func (m Metadata) buildNetwork(fallback string) string {
+	if m.BuildNetwork == "" {
+		return fallback
+	}
+	return m.BuildNetwork
+}
+
+// buildArgs returns the task's build args in the form the Docker API takes.
+func (m Metadata) buildArgs() map[string]*string {
+	if len(m.BuildArgs) == 0 {
+		return nil
+	}
+
+	args := make(map[string]*string, len(m.BuildArgs))
+	for name, value := range m.BuildArgs {
+		args[name] = &value
+	}
+	return args
+}
+
+const defaultWorkingDir = "/test"
+
+func (m Metadata) workingDir() string {
+	if m.WorkingDir == "" {
+		return defaultWorkingDir
+	}
+	return path.Clean(m.WorkingDir)
+}
+
+func (m Metadata) resolve(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(m.workingDir(), p)
+}
+
+func (m Metadata) reportPath() string {
+	if m.ReportPath == "" {
+		return m.resolve("report.xml")
+	}
+	return m.resolve(m.ReportPath)
+}
+
+func (m Metadata) reportDir() string {
+	if m.ReportDir == "" {
+		return ""
+	}
+	return m.resolve(m.ReportDir)
+}
+
+func withinDir(dir string, p string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func (m Metadata) validate() error {
+	if m.MaxConcurrentRuns < 0 {
+		return fmt.Errorf("invalid max concurrent runs %d", m.MaxConcurrentRuns)
+	}
+	if m.WorkingDir != "" && !path.IsAbs(m.WorkingDir) {
+		return fmt.Errorf("working dir %q must be absolute", m.WorkingDir)
+	}
+	// Runners write reports relative to where they start, so reports must
+	// live under the working dir.
+	if report := m.reportPath(); !withinDir(m.workingDir(), report) || report == m.workingDir() {
+		return fmt.Errorf("report path %q is outside working dir %q", report, m.workingDir())
+	}
+	if dir := m.reportDir(); dir != "" && !withinDir(m.workingDir(), dir) {
+		return fmt.Errorf("report dir %q is outside working dir %q", dir, m.workingDir())
+	}
+	if m.PollReport && m.ReportDir != "" {
+		return fmt.Errorf("pollReport can't be used with a report dir")
+	}
+	if _, ok := reportParsers[m.ReportFormat]; m.ReportFormat != "" && !ok {
+		return fmt.Errorf("unknown report format %q", m.ReportFormat)
+	}
+	if m.Timeout != "" {
+		if timeout, err := time.ParseDuration(m.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", m.Timeout)
+		}
+	}
+	if m.BuildTarget != "" && !stageName.MatchString(m.BuildTarget) {
+		return fmt.Errorf("invalid build target %q", m.BuildTarget)
+	}
+	if m.SeccompProfile != "" && (path.IsAbs(m.SeccompProfile) || path.Clean(m.SeccompProfile) != path.Base(m.SeccompProfile)) {
+		return fmt.Errorf("seccomp profile %q must be a file in the task directory", m.SeccompProfile)
+	}
+	if err := validateArgs("test command", m.TestCommand); err != nil {
+		return err
+	}
+	if err := validateArgs("entrypoint", m.Entrypoint); err != nil {
+		return err
+	}
+	if m.MemoryLimit != "" {
+		if memory, err := parseByteSize(m.MemoryLimit); err != nil || memory < minMemoryLimit {
+			return fmt.Errorf("invalid memory limit %q", m.MemoryLimit)
+		}
+	}
+	if m.CPULimit < 0 {
+		return fmt.Errorf("invalid CPU limit %g", m.CPULimit)
+	}
+	if m.Hostname != "" && !hostLabel.MatchString(m.Hostname) {
+		return fmt.Errorf("invalid hostname %q", m.Hostname)
+	}
+	if m.StopSignal != "" && !stopSignals[m.StopSignal] {
+		return fmt.Errorf("invalid stop signal %q", m.StopSignal)
+	}
+	if m.BuildNetwork != "" && !networkModes[m.BuildNetwork] {
+		return fmt.Errorf("invalid build network %q", m.BuildNetwork)
+	}
+	if len(m.DNS) > 16 || len(m.ExtraHosts) > 16 {
+		return fmt.Errorf("at most 16 DNS servers and extra hosts are allowed")
+	}
+	for _, server := range m.DNS {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS server %q", server)
+		}
+	}
+	for _, host := range m.ExtraHosts {
+		name, ip, ok := strings.Cut(host, ":")
+		if !ok || !hostName.MatchString(name) || net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid extra host %q, want name:ip", host)
+		}
+	}
+	for name, value := range m.BuildArgs {
+		if !buildArgName.MatchString(name) {
+			return fmt.Errorf("invalid build arg name %q", name)
+		}
+		if len(value) > 256 || !buildArgValue.MatchString(value) {
+			return fmt.Errorf("invalid value for build arg %s", name)
+		}
+	}
+
+	return nil
+}
+
+// validateArgs checks an argument list that replaces part of the image's
+// command line. A nil list leaves the image's in place.
+func validateArgs(kind string, args []string) error {
+	if args == nil {
+		return nil
+	}
+	if len(args) == 0 || len(args) > 64 {
+		return fmt.Errorf("%s must have between 1 and 64 arguments", kind)
+	}
+	for _, arg := range args {
+		if arg == "" || len(arg) > 1024 || strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("invalid %s argument %q", kind, arg)
+		}
+	}
+	return nil
+}
+
+// runTimeout returns the task's timeout, bounded by the server-wide limit.
+func (m Metadata) runTimeout(limit time.Duration) time.Duration {
+	timeout, err := time.ParseDuration(m.Timeout)
+	if err != nil || timeout <= 0 || timeout > limit {
+		return limit
+	}
+	return timeout
+}
+
+func taskExists(task string) bool {
+	info, err := fs.Stat(files, path.Join("tests", task))
+	return err == nil && info.IsDir()
+}
+
+// requiredTaskFiles are the files every task directory must contain.
+var requiredTaskFiles = []string{"code.ts", "test.ts", "README.md"}
+
+// checkTasks reports packaging mistakes in the embedded tasks: task
+// directories missing a required file, and task files nested too deep to be
+// found by the handlers.
+func checkTasks() []error {
+	tasks, err := listTasks()
+	if err != nil {
+		return []error{fmt.Errorf("listing tasks: %w", err)}
+	}
+
+	problems := []error{}
+	for _, task := range tasks {
+		for _, name := range requiredTaskFiles {
+			if _, err := fs.Stat(files, path.Join("tests", task, name)); err != nil {
+				problems = append(problems, fmt.Errorf("task %s is missing %s", task, name))
+			}
+		}
+
+		fs.WalkDir(files, path.Join("tests", task), func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path.Base(file) == "test.ts" && path.Dir(file) != path.Join("tests", task) {
+				problems = append(problems, fmt.Errorf("task %s has a nested %s; tasks must sit directly under tests/", task, file))
+			}
+			return nil
+		})
+	}
+
+	return problems
+}
+
+// dockerfileBaseImage returns the image named by the first FROM line of the
+// packaged Dockerfile.
+func dockerfileBaseImage() string {
+	dockerfile, err := files.ReadFile("image/Dockerfile")
+	if err != nil {
+		return ""
+	}
+
+	if images := dockerfileBaseImages(dockerfile, Metadata{}); len(images) > 0 {
+		return images[0]
+	}
+	return ""
+}
+
+// loadMetadata reads the task's metadata.json. Tasks without one get the
+// defaults.
+func loadMetadata(task string) (Metadata, error) {
+	meta := Metadata{Points: 1, Language: "typescript", BaseImage: dockerfileBaseImage()}
+
+	data, err := files.ReadFile(fmt.Sprintf("tests/%s/metadata.json", task))
+	if err != nil {
+		return meta, nil
+	}
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("parsing metadata for %s: %w", task, err)
+	}
+
+	if err := meta.validate(); err != nil {
+		return meta, fmt.Errorf("metadata for %s: %w", task, err)
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBuildArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  map[string]string
+		valid bool
+	}{
+		{"version", map[string]string{"DENO_VERSION": "2.1.4"}, true},
+		{"image reference", map[string]string{"BASE": "denoland/deno:alpine-2.1.4@sha256:abc"}, true},
+		{"empty value", map[string]string{"FLAGS": ""}, true},
+		{"name with a dash", map[string]string{"DENO-VERSION": "2"}, false},
+		{"name starting with a digit", map[string]string{"1VERSION": "2"}, false},
+		{"command substitution", map[string]string{"VERSION": "$(id)"}, false},
+		{"space", map[string]string{"VERSION": "2 && id"}, false},
+		{"semicolon", map[string]string{"VERSION": "2;id"}, false},
+		{"newline", map[string]string{"VERSION": "2\nRUN id"}, false},
+		{"too long", map[string]string{"VERSION": strings.Repeat("1", 257)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Metadata{BuildArgs: test.args}.validate()
+			if test.valid && err != nil {
+				t.Errorf("valid build args rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("invalid build args accepted")
+			}
+		})
+	}
+}
+
+func TestMetadataBuildArgs(t *testing.T) {
+	if args := (Metadata{}).buildArgs(); args != nil {
+		t.Errorf("task without build args has %v", args)
+	}
+
+	args := Metadata{BuildArgs: map[string]string{"A": "1", "B": "2"}}.buildArgs()
+	if len(args) != 2 || *args["A"] != "1" || *args["B"] != "2" {
+		t.Errorf("build args are %v, want A=1 and B=2", args)
+	}
+}
+
+func TestValidateTestCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		valid   bool
+	}{
+		{"unset", nil, true},
+		{"deno test", []string{"test", "--junit-path=report.xml"}, true},
+		{"empty", []string{}, false},
+		{"empty argument", []string{"test", ""}, false},
+		{"NUL", []string{"test\x00--allow-all"}, false},
+		{"too long argument", []string{strings.Repeat("a", 1025)}, false},
+		{"too many arguments", make([]string, 65), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Metadata{TestCommand: test.command}.validate()
+			if test.valid && err != nil {
+				t.Errorf("valid test command rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("invalid test command accepted")
+			}
+		})
+	}
+}
+
+func TestValidateEntrypoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		entrypoint []string
+		valid      bool
+	}{
+		{"unset", nil, true},
+		{"runner script", []string{"/bin/run-tests", "--report", "report.xml"}, true},
+		{"empty", []string{}, false},
+		{"empty argument", []string{"/bin/run-tests", ""}, false},
+		{"NUL", []string{"/bin/run-tests\x00"}, false},
+		{"too many arguments", make([]string, 65), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Metadata{Entrypoint: test.entrypoint}.validate()
+			if test.valid && err != nil {
+				t.Errorf("valid entrypoint rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("invalid entrypoint accepted")
+			}
+		})
+	}
+}
+
+func TestValidateDNS(t *testing.T) {
+	tests := []struct {
+		name       string
+		dns        []string
+		extraHosts []string
+		valid      bool
+	}{
+		{"unset", nil, nil, true},
+		{"resolvers", []string{"10.0.0.53", "2001:db8::53"}, nil, true},
+		{"extra hosts", nil, []string{"api.internal:10.0.0.8", "api6:2001:db8::8"}, true},
+		{"resolver name", []string{"dns.google"}, nil, false},
+		{"host without ip", nil, []string{"api.internal"}, false},
+		{"host with a bad ip", nil, []string{"api.internal:10.0.0"}, false},
+		{"host with a space", nil, []string{"api internal:10.0.0.8"}, false},
+		{"host starting with a dot", nil, []string{".internal:10.0.0.8"}, false},
+		{"too many resolvers", make([]string, 17), nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Metadata{DNS: test.dns, ExtraHosts: test.extraHosts}.validate()
+			if test.valid && err != nil {
+				t.Errorf("valid DNS config rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("invalid DNS config accepted")
+			}
+		})
+	}
+}
+
+func TestValidateStopSignal(t *testing.T) {
+	tests := []struct {
+		signal string
+		valid  bool
+	}{
+		{"", true},
+		{"SIGTERM", true},
+		{"SIGINT", true},
+		{"SIGUSR1", true},
+		{"SIGKILL", false},
+		{"TERM", false},
+		{"sigterm", false},
+		{"15", false},
+	}
+	for _, test := range tests {
+		t.Run(test.signal, func(t *testing.T) {
+			err := Metadata{StopSignal: test.signal}.validate()
+			if test.valid && err != nil {
+				t.Errorf("valid stop signal rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("invalid stop signal accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// TaskStats summarises the results of a task held in the result store.
+type TaskStats struct {
+	Task string `json:"task"`
+	// Submissions counts finished runs, including those that errored.
+	Submissions int `json:"submissions"`
+	// PassRate is the fraction of runs with a result in which every case
+	// passed, or zero when there are none.
+	PassRate float64 `json:"passRate"`
+	// AverageDuration is the mean of those results' test durations, in
+	// seconds.
+	AverageDuration float64 `json:"averageDuration"`
+}
+
+func computeTaskStats(task string, jobs []Job) TaskStats {
+	stats := TaskStats{Task: task}
+	results, passed := 0, 0
+	duration := 0.0
+
+	for _, job := range jobs {
+		if job.Finished == nil {
+			continue
+		}
+		stats.Submissions++
+		if job.Result == nil {
+			continue
+		}
+		results++
+		duration += job.Result.Duration
+		if job.Result.Failed == 0 && !job.Result.TimedOut && !job.Result.MemoryExceeded {
+			passed++
+		}
+	}
+
+	if results > 0 {
+		stats.PassRate = float64(passed) / float64(results)
+		stats.AverageDuration = duration / float64(results)
+	}
+	return stats
+}
+
+// statsHandler serves GET /stats/{test}. Stats are recomputed only when the
+// result store has changed since they were last served.
+func statsHandler(store *resultStore) http.HandlerFunc {
+	type cached struct {
+		version uint64
+		stats   TaskStats
+	}
+	var mu sync.Mutex
+	cache := map[string]cached{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		test := r.PathValue("test")
+		if !taskExists(test) {
+			writeError(w, r, http.StatusNotFound, codeTestNotFound, "Can't find test "+test)
+			return
+		}
+
+		mu.Lock()
+		entry, ok := cache[test]
+		if !ok || entry.version != store.Version() {
+			jobs, version := store.Task(test)
+			entry = cached{version: version, stats: computeTaskStats(test, jobs)}
+			cache[test] = entry
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(marshalResponse(r, entry.stats))
+	}
+}
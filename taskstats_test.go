@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// seedResults records runs of sum: two passing, one failing, one timed out
+// and one that errored without a result.
+func seedResults(store *resultStore) {
+	req := RunRequest{Task: "sum", User: "alice", Code: "export const sum = 1"}
+	store.Record(req, &RunResult{Passed: 2, Total: 2, Duration: 1}, nil, nil)
+	store.Record(req, &RunResult{Passed: 2, Total: 2, Duration: 2}, nil, nil)
+	store.Record(req, &RunResult{Passed: 1, Failed: 1, Total: 2, Duration: 3}, nil, nil)
+	store.Record(req, &RunResult{Passed: 1, Total: 2, Duration: 6, TimedOut: true}, nil, nil)
+	store.Record(req, nil, errors.New("building image: no space left"), nil)
+	store.Record(RunRequest{Task: "sub", User: "alice"}, &RunResult{Failed: 1, Total: 1}, nil, nil)
+}
+
+func TestComputeTaskStats(t *testing.T) {
+	store := newResultStore(100, 100)
+	seedResults(store)
+	jobs, _ := store.Task("sum")
+
+	stats := computeTaskStats("sum", jobs)
+	want := TaskStats{Task: "sum", Submissions: 5, PassRate: 0.5, AverageDuration: 3}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+
+	if stats := computeTaskStats("sum", nil); stats != (TaskStats{Task: "sum"}) {
+		t.Errorf("with no jobs got %+v, want zeroes", stats)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ADMIN_TOKEN": "secret"})
+	store := newResultStore(100, 100)
+	seedResults(store)
+	handler := requireAdmin(cfg, statsHandler(store))
+
+	get := func(test string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/stats/"+test, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(t, "GET /stats/{test}", handler, r)
+	}
+	stats := func() TaskStats {
+		w := get("sum", "secret")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status is %d, want 200", w.Code)
+		}
+		stats := TaskStats{}
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	if w := get("sum", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a token status is %d, want 401", w.Code)
+	}
+	if w := get("sum", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("with the wrong token status is %d, want 401", w.Code)
+	}
+	assertAPIError(t, get("no-such-task", "secret"), http.StatusNotFound, codeTestNotFound)
+
+	if got := stats(); got.Submissions != 5 || got.PassRate != 0.5 {
+		t.Errorf("got %+v, want 5 submissions at a 0.5 pass rate", got)
+	}
+
+	// A new result changes the store's version, so the cached stats are
+	// recomputed.
+	store.Record(RunRequest{Task: "sum", User: "bob"}, &RunResult{Passed: 2, Total: 2, Duration: 3}, nil, nil)
+	if got := stats(); got.Submissions != 6 || got.PassRate != 0.6 {
+		t.Errorf("after another pass got %+v, want 6 submissions at a 0.6 pass rate", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// taskVersions maps each task to a digest of what it grades submissions
+// against, computed once at startup by loadTaskVersions.
+var taskVersions = map[string]string{}
+
+// taskVersionFiles are the files of a task, besides its Dockerfile, that
+// decide how a submission is graded. Missing ones are skipped.
+var taskVersionFiles = []string{"test.ts", "metadata.json", "expected.txt"}
+
+// taskVersion hashes the task's Dockerfile and taskVersionFiles, as
+// packaged in fsys. Results record it so that results from before a task
+// changed can be told apart.
+func taskVersion(fsys fs.FS, task string) (string, error) {
+	hash := sha256.New()
+
+	dockerfile, err := taskDockerfile(fsys, task)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(hash, "file %q %d\n", "Dockerfile", len(dockerfile))
+	hash.Write(dockerfile)
+
+	for _, name := range taskVersionFiles {
+		data, err := fs.ReadFile(fsys, path.Join("tests", task, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading %s of %s: %w", name, task, err)
+		}
+		fmt.Fprintf(hash, "file %q %d\n", name, len(data))
+		hash.Write(data)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+func loadTaskVersions() error {
+	tasks, err := listTasks()
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		version, err := taskVersion(files, task)
+		if err != nil {
+			return err
+		}
+		taskVersions[task] = version
+	}
+	return nil
+}
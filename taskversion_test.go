@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/fs"
+	"maps"
+	"testing"
+	"testing/fstest"
+)
+
+// packagedTask copies the files of task, and the shared image, out of the
+// embedded files so that a test can change them.
+func packagedTask(t *testing.T, task string) fstest.MapFS {
+	t.Helper()
+
+	fsys := fstest.MapFS{}
+	for _, dir := range []string{"image", "tests/" + task} {
+		err := fs.WalkDir(files, dir, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			data, err := files.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			fsys[name] = &fstest.MapFile{Data: data, Mode: 0644}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fsys
+}
+
+func TestTaskVersion(t *testing.T) {
+	base, err := taskVersion(packagedTask(t, "sum"), "sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(base) != 16 {
+		t.Errorf("version %q isn't 16 hex digits", base)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(fsys fstest.MapFS)
+		changed bool
+	}{
+		{"unchanged", func(fsys fstest.MapFS) {}, false},
+		{"test.ts", func(fsys fstest.MapFS) {
+			fsys["tests/sum/test.ts"].Data = append(fsys["tests/sum/test.ts"].Data, "\n// stricter\n"...)
+		}, true},
+		{"Dockerfile", func(fsys fstest.MapFS) {
+			fsys["image/Dockerfile"].Data = append(fsys["image/Dockerfile"].Data, "\nRUN true\n"...)
+		}, true},
+		{"metadata", func(fsys fstest.MapFS) {
+			fsys["tests/sum/metadata.json"] = &fstest.MapFile{Data: []byte(`{"timeout": "5s"}`)}
+		}, true},
+		{"expected output added", func(fsys fstest.MapFS) {
+			fsys["tests/sum/expected.txt"] = &fstest.MapFile{Data: []byte("3\n")}
+		}, true},
+		{"starter code", func(fsys fstest.MapFS) {
+			fsys["tests/sum/code.ts"] = &fstest.MapFile{Data: []byte("export const sum = 0\n")}
+		}, false},
+		{"solution", func(fsys fstest.MapFS) {
+			fsys["tests/sum/solution.ts"] = &fstest.MapFile{Data: []byte("export const sum = 1\n")}
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := packagedTask(t, "sum")
+			test.change(fsys)
+			version, err := taskVersion(fsys, "sum")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := version != base; changed != test.changed {
+				t.Errorf("version changed: %v, want %v (%s, was %s)", changed, test.changed, version, base)
+			}
+		})
+	}
+}
+
+func TestLoadTaskVersions(t *testing.T) {
+	previous := maps.Clone(taskVersions)
+	t.Cleanup(func() { taskVersions = previous })
+
+	if err := loadTaskVersions(); err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range []string{"sum", "sub"} {
+		want, err := taskVersion(files, task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taskVersions[task] != want {
+			t.Errorf("%s version is %q, want %q", task, taskVersions[task], want)
+		}
+	}
+	if taskVersions["sum"] == taskVersions["sub"] {
+		t.Errorf("sum and sub share version %s", taskVersions["sum"])
+	}
+}
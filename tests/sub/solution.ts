@@ -0,0 +1,3 @@
+export function sub(a: number, b: number): number {
+	return a - b
+}
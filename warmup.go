@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/moby/moby/client"
+)
+
+type warmupRequest struct {
+	// Tasks lists the tasks to warm up. Empty means every packaged task.
+	Tasks []string `json:"tasks"`
+}
+
+type warmupStatus struct {
+	Task   string `json:"task"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func listTasks() ([]string, error) {
+	entries, err := fs.ReadDir(files, "tests")
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			tasks = append(tasks, entry.Name())
+		}
+	}
+	return tasks, nil
+}
+
+func warmupTask(ctx context.Context, cfg *Config, cli *client.Client, builds *limiter, task string, noCache bool) warmupStatus {
+	status := warmupStatus{Task: task}
+
+	if !taskExists(task) {
+		status.Status = "error"
+		status.Error = "unknown task"
+		return status
+	}
+
+	if _, err := files.ReadFile(path.Join("tests", task, "code.ts")); err != nil {
+		status.Status = "error"
+		status.Error = "task has no base code"
+		return status
+	}
+
+	exists := false
+	meta, err := loadMetadata(task)
+	if err == nil {
+		_, exists, err = ensureBaseImage(ctx, cfg, cli, task, meta, io.Discard, builds, noCache)
+	}
+	if err != nil {
+		status.Status = "error"
+		status.Error = err.Error()
+		return status
+	}
+
+	status.Status = "built"
+	if exists {
+		status.Status = "exists"
+	}
+	return status
+}
+
+// warmupTasks warms up tasks, at most cfg.WarmupConcurrency at once, each
+// build also taking one of the builds that runs use.
+func warmupTasks(ctx context.Context, cfg *Config, cli *client.Client, builds *limiter, tasks []string, noCache bool) []warmupStatus {
+	statuses := make([]warmupStatus, len(tasks))
+	slots := make(chan struct{}, cfg.WarmupConcurrency)
+	wg := sync.WaitGroup{}
+	for i, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			statuses[i] = warmupTask(ctx, cfg, cli, builds, task, noCache)
+			if statuses[i].Error != "" {
+				slog.Error("warmup", "task", task, "status", statuses[i].Status, "error", statuses[i].Error)
+			} else {
+				slog.Info("warmup", "task", task, "status", statuses[i].Status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+// warmupOnStart builds every task's base image, with WARMUP_ON_START, and
+// then runs the smoke tests, unless strict mode already ran them, while the
+// server starts serving. The caller sets warmingUp, so that runs are refused
+// with 503 from the start, and it is cleared once this is done.
+func warmupOnStart(cfg *Config, cli *client.Client, builds *limiter, runs *limiter) {
+	defer warmingUp.Store(false)
+
+	if cfg.WarmupOnStart {
+		tasks, err := listTasks()
+		if err != nil {
+			slog.Error("listing tasks to warm up", "error", err)
+		} else {
+			warmupTasks(context.Background(), cfg, cli, builds, tasks, false)
+		}
+	}
+	if cfg.SmokeTestOnStart && !cfg.StrictTasks {
+		runSmokeTests(context.Background(), cfg, cli, builds, runs)
+	}
+	slog.Info("warmup finished, accepting runs")
+}
+
+// warmupHandler builds the base images of the requested tasks ahead of
+// time. With ?nocache=1 every image is rebuilt without the build cache.
+func warmupHandler(cfg *Config, cli *client.Client, builds *limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := warmupRequest{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "invalid warmup request: "+err.Error())
+				return
+			}
+		}
+
+		tasks := request.Tasks
+		if len(tasks) == 0 {
+			var err error
+			if tasks, err = listTasks(); err != nil {
+				slog.Error("listing tasks", "request_id", requestID(r.Context()), "error", err)
+				writeError(w, r, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+		}
+
+		statuses := warmupTasks(r.Context(), cfg, cli, builds, tasks, queryBool(r, "nocache"))
+
+		resp := marshalResponse(r, statuses)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarmupHandlerInvalidRequest(t *testing.T) {
+	docker := newFakeDocker(t)
+	r := httptest.NewRequest("POST", "/admin/warmup", strings.NewReader(`{"tasks": "sum"}`))
+
+	w := serve(t, "POST /admin/warmup", warmupHandler(testConfig(t, nil), docker.client, nil), r)
+	assertAPIError(t, w, http.StatusBadRequest, codeInvalidRequest)
+	if len(docker.Builds()) != 0 {
+		t.Error("invalid warmup request built images")
+	}
+}
+
+// fullLimiter returns a limiter of one slot that is taken until the test
+// ends.
+func fullLimiter(t *testing.T) *limiter {
+	l := newLimiter(1)
+	if !l.TryAcquire() {
+		t.Fatal("new limiter is full")
+	}
+	t.Cleanup(l.Release)
+	return l
+}
+
+func TestWarmupTaskWaitsForBuildSlot(t *testing.T) {
+	cfg := testConfig(t, nil)
+	docker := newFakeDocker(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	status := warmupTask(ctx, cfg, docker.client, fullLimiter(t), "sum", false)
+	if status.Status != "error" || !strings.Contains(status.Error, "waiting for a build slot") {
+		t.Errorf("warmup with every build slot taken got %+v, want a build slot error", status)
+	}
+	if len(docker.Builds()) != 0 {
+		t.Error("warmup built without a build slot")
+	}
+
+	if status := warmupTask(context.Background(), cfg, docker.client, newLimiter(1), "sum", false); status.Status != "built" {
+		t.Errorf("warmup with a free build slot got %+v, want built", status)
+	}
+	if len(docker.Builds()) != 1 {
+		t.Errorf("got %d builds, want 1", len(docker.Builds()))
+	}
+}
+
+func TestWarmupHandlerUsesBuildLimit(t *testing.T) {
+	docker := newFakeDocker(t)
+	handler := warmupHandler(testConfig(t, nil), docker.client, fullLimiter(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequestWithContext(ctx, "POST", "/admin/warmup", strings.NewReader(`{"tasks": ["sum", "sub"]}`))
+	w := serve(t, "POST /admin/warmup", handler, r)
+
+	statuses := []warmupStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+	for _, status := range statuses {
+		if status.Status != "error" {
+			t.Errorf("%s warmed up with every build slot taken: %+v", status.Task, status)
+		}
+	}
+	if len(docker.Builds()) != 0 {
+		t.Errorf("warmup made %d builds without a build slot", len(docker.Builds()))
+	}
+}
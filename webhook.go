@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const signatureHeader = "X-Signature-256"
+
+var errCallbackNotAllowed = errors.New("callback URL not allowed")
+
+// webhookSender POSTs finished jobs to client-supplied callback URLs. Each
+// delivery is signed with an HMAC-SHA256 of the body, sent as
+// "X-Signature-256: sha256=<hex>", so receivers can check it came from us.
+type webhookSender struct {
+	client       *http.Client
+	secret       []byte
+	attempts     int
+	backoff      time.Duration
+	allowedHosts map[string]bool
+}
+
+// newWebhookSender returns nil, disabling callbacks, unless some callback
+// hosts are allowed.
+func newWebhookSender(cfg *Config) *webhookSender {
+	allowed := map[string]bool{}
+	for _, host := range strings.Split(cfg.WebhookAllowedHosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			allowed[host] = true
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	return &webhookSender{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: publicOnlyDialer().DialContext,
+			},
+			// A redirect could point anywhere, including back inside.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		secret:       []byte(cfg.WebhookSecret),
+		attempts:     cfg.WebhookAttempts,
+		backoff:      time.Second,
+		allowedHosts: allowed,
+	}
+}
+
+// Validate accepts only http and https URLs to an allowed host that resolves
+// to public addresses.
+func (s *webhookSender) Validate(ctx context.Context, raw string) error {
+	if s == nil {
+		return fmt.Errorf("%w: callbacks are disabled", errCallbackNotAllowed)
+	}
+
+	callback, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errCallbackNotAllowed, err)
+	}
+	if callback.Scheme != "https" && callback.Scheme != "http" {
+		return fmt.Errorf("%w: scheme %q", errCallbackNotAllowed, callback.Scheme)
+	}
+	if callback.User != nil {
+		return fmt.Errorf("%w: credentials in URL", errCallbackNotAllowed)
+	}
+	if !s.allowedHosts[strings.ToLower(callback.Hostname())] {
+		return fmt.Errorf("%w: host %q", errCallbackNotAllowed, callback.Hostname())
+	}
+	if err := validatePublicURL(ctx, raw); err != nil {
+		return fmt.Errorf("%w: %w", errCallbackNotAllowed, err)
+	}
+
+	return nil
+}
+
+func (s *webhookSender) sign(body []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver POSTs payload to callback, retrying failed attempts with doubling
+// backoff. Any 2xx response counts as delivered.
+func (s *webhookSender) Deliver(ctx context.Context, callback string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding callback: %w", err)
+	}
+
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		err = s.post(ctx, callback, body)
+		if err == nil || attempt >= s.attempts {
+			return err
+		}
+
+		slog.Warn("callback failed", "callback", callback, "attempt", attempt, "attempts", s.attempts, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (s *webhookSender) post(ctx context.Context, callback string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, s.sign(body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback responded %s", resp.Status)
+	}
+	return nil
+}